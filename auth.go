@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/subtle"
+	"strings"
+)
+
+// noAuthReply is the error replied to the commands of the clients that did not authenticate
+// while requirepass is set.
+const noAuthReply = "-NOAUTH Authentication required.\r\n"
+
+// wrongPassReply is the error replied to AUTH with the wrong password.
+const wrongPassReply = "-WRONGPASS invalid username-password pair or user is disabled.\r\n"
+
+func init() {
+	registerCommand("AUTH", CommandSpec{connectionHandler: authCommand, arity: -2, flags: []string{"fast", "loading", "no_auth"}})
+}
+
+// authCommand authenticates the connection with the password set with requirepass.
+// The password can be preceded by a user name, which must be default, the only user.
+func authCommand(c *client, args []string) string {
+	if len(args) > 2 {
+		return returnError("syntax error")
+	}
+
+	password := c.server.config.password()
+	if password == "" {
+		return returnError("AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	}
+
+	given := args[len(args)-1]
+	if len(args) == 2 && !strings.EqualFold(args[0], "default") {
+		return wrongPassReply
+	}
+
+	if subtle.ConstantTimeCompare([]byte(given), []byte(password)) != 1 {
+		c.authenticated = false
+		return wrongPassReply
+	}
+
+	c.authenticated = true
+
+	return returnSimpleString("OK")
+}
+
+// needsAuth reports whether the client must authenticate before running the command:
+// a password is set, the client has not given it, and the command is not flagged no_auth, as AUTH and QUIT are.
+func (c *client) needsAuth(spec CommandSpec) bool {
+	return !c.authenticated && !spec.hasFlag("no_auth") && c.server.config.password() != ""
+}
@@ -0,0 +1,18 @@
+package main
+
+import "time"
+
+// A Clock tells the current time.
+// The expire times of the keys are set and checked against the clock of the server,
+// so that the tests can move the time forward instead of sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the Clock of a running server, telling the system time.
+type realClock struct{}
+
+// Now returns the current system time.
+func (realClock) Now() time.Time {
+	return time.Now()
+}
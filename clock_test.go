@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A manualClock is a Clock that only moves forward when advanced.
+type manualClock struct {
+	now   time.Time
+	mutex sync.Mutex
+}
+
+// newManualClock returns a manual clock set to the current time.
+func newManualClock() *manualClock {
+	return &manualClock{now: time.Now()}
+}
+
+// Now returns the time of the clock.
+func (c *manualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *manualClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// newTestContextWithClock returns a context running commands against a new test server,
+// along with the manual clock its expire times are checked against.
+func newTestContextWithClock() (*commandContext, *manualClock) {
+	clock := newManualClock()
+	server := newTestServerWithClock(clock)
+
+	return &commandContext{Context: context.Background(), server: server}, clock
+}
+
+func TestExpireCheckerUsesClock(t *testing.T) {
+	ctx, clock := newTestContextWithClock()
+	db := ctx.db()
+
+	setCommand(ctx, []string{"volatile", "value", "EX", "100"})
+	setCommand(ctx, []string{"key", "value"})
+
+	db.checkAndRemoveExpiredKeys()
+	if keys, expires := db.KeyCount(); keys != 2 || expires != 1 {
+		t.Errorf("KeyCount() = %d, %d before the expire time; want 2, 1", keys, expires)
+	}
+
+	clock.Advance(100*time.Second + time.Millisecond)
+
+	db.checkAndRemoveExpiredKeys()
+	if keys, expires := db.KeyCount(); keys != 1 || expires != 0 {
+		t.Errorf("KeyCount() = %d, %d after the expire time; want 1, 0", keys, expires)
+	}
+
+	if expired := ctx.server.stats.expiredKeys.Load(); expired != 1 {
+		t.Errorf("stats.expiredKeys = %d; want 1", expired)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import "strings"
+
+func init() {
+	registerCommand("CLUSTER", CommandSpec{handler: clusterCommand, arity: -2, flags: []string{"loading"}})
+}
+
+// clusterInfoReply is the reply of CLUSTER INFO: a single node, with cluster support disabled and no slot assigned.
+const clusterInfoReply = "cluster_enabled:0\r\n" +
+	"cluster_state:ok\r\n" +
+	"cluster_slots_assigned:0\r\n" +
+	"cluster_slots_ok:0\r\n" +
+	"cluster_known_nodes:1\r\n" +
+	"cluster_size:0\r\n" +
+	"cluster_current_epoch:0\r\n" +
+	"cluster_my_epoch:0\r\n"
+
+// clusterCommand reports that the server runs alone, for the clients probing the cluster on connect.
+// INFO reports cluster_enabled:0, SLOTS and SHARDS an empty array since no slot is assigned,
+// and MYID the id of the node, which stays the same until the process exits.
+func clusterCommand(ctx *commandContext, args []string) string {
+	subcommand := strings.ToUpper(args[0])
+
+	switch subcommand {
+	case "INFO", "MYID", "SLOTS", "SHARDS":
+		if len(args) != 1 {
+			return returnWrongNumberOfArgumentsError("CLUSTER " + subcommand)
+		}
+
+		switch subcommand {
+		case "INFO":
+			return returnBulkString(clusterInfoReply)
+		case "MYID":
+			return returnBulkString(ctx.server.nodeID)
+		default:
+			return "*0\r\n"
+		}
+	case "HELP":
+		return returnHelp("CLUSTER",
+			"INFO",
+			"    Return information about the cluster.",
+			"MYID",
+			"    Return the node id.",
+			"SHARDS",
+			"    Return information about slot range mappings and the nodes associated with them.",
+			"SLOTS",
+			"    Return information about slots range mappings. Each range is made of:",
+			"    start, end, master and replicas IP addresses, ports and ids",
+		)
+	default:
+		return returnUnknownSubcommandError("CLUSTER", args[0])
+	}
+}
+
+// clusterInfo returns the fields of the Cluster section of INFO.
+func clusterInfo(_ *RedisServer) string {
+	return "cluster_enabled:0\r\n"
+}
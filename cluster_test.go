@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClusterCommand(t *testing.T) {
+	result := clusterCommand(testContext, []string{"INFO"})
+	if !strings.HasPrefix(result, "$") || !strings.Contains(result, "cluster_enabled:0\r\n") {
+		t.Errorf("clusterCommand([]string{\"INFO\"}) = %q; want a bulk string with cluster_enabled:0", result)
+	}
+
+	for _, subcommand := range []string{"SLOTS", "SHARDS"} {
+		if result := clusterCommand(testContext, []string{subcommand}); result != "*0\r\n" {
+			t.Errorf("clusterCommand([]string{%q}) = %q; want an empty array", subcommand, result)
+		}
+	}
+
+	id := clusterCommand(testContext, []string{"MYID"})
+	if id != returnBulkString(testServer.nodeID) || len(testServer.nodeID) != 40 {
+		t.Errorf("clusterCommand([]string{\"MYID\"}) = %q; want the 40 characters node id", id)
+	}
+
+	if again := clusterCommand(testContext, []string{"myid"}); again != id {
+		t.Errorf("clusterCommand([]string{\"myid\"}) = %q; want the same id as before, %q", again, id)
+	}
+
+	result = clusterCommand(testContext, []string{"SLOTS", "extra"})
+	if result != "-ERR wrong number of arguments for 'CLUSTER SLOTS' command\r\n" {
+		t.Errorf("clusterCommand([]string{\"SLOTS\", \"extra\"}) = %s; want a wrong number of arguments error", result)
+	}
+
+	result = clusterCommand(testContext, []string{"NODES"})
+	if result != "-ERR Unknown subcommand or wrong number of arguments for 'NODES'. Try CLUSTER HELP.\r\n" {
+		t.Errorf("clusterCommand([]string{\"NODES\"}) = %s; want an unknown subcommand error", result)
+	}
+
+	if result := infoCommand(testContext, []string{"cluster"}); !strings.Contains(result, "# Cluster\r\ncluster_enabled:0\r\n") {
+		t.Errorf("infoCommand([]string{\"cluster\"}) = %q; want the Cluster section with cluster_enabled:0", result)
+	}
+}
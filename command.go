@@ -1,47 +1,151 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// A commandContext is what a command runs against: the server,
+// and the client issuing the command, which is nil when a command is called directly.
+// database is the database the command runs against, when it is not the selected database of the server.
+// The embedded context is canceled once the client goes away,
+// so that long running commands can stop early.
+type commandContext struct {
+	context.Context
+	server   *RedisServer
+	client   *client
+	database *Database
+}
+
+// db returns the database the command runs against:
+// the database given to the context if any, the selected database of the server otherwise.
+func (ctx *commandContext) db() *Database {
+	if ctx.database != nil {
+		return ctx.database
+	}
+
+	return ctx.server.databases[ctx.server.selectedDB]
+}
+
 // A CommandFunc is the type of a Redis command function.
-type CommandFunc func(args []string) string
-
-// CommandMap stores the Redis command functions.
-func getCommandMap() map[string]CommandFunc {
-	return map[string]CommandFunc{
-		"PING":     pingCommand,
-		"ECHO":     echoCommand,
-		"SET":      setCommand,
-		"SETEX":    setexCommand,
-		"GET":      getCommand,
-		"GETSET":   getsetCommand,
-		"GETDEL":   getdelCommand,
-		"MSET":     msetCommand,
-		"MSETNX":   msetnxCommand,
-		"MGET":     mgetCommand,
-		"DEL":      delCommand,
-		"INCR":     incrCommand,
-		"INCRBY":   incrbyCommand,
-		"DECR":     decrCommand,
-		"DECRBY":   decrbyCommand,
-		"EXPIRE":   expireCommand,
-		"TTL":      ttlCommand,
-		"PERSIST":  persistCommand,
-		"EXISTS":   existsCommand,
-		"KEYS":     keysCommand,
-		"SAVE":     saveCommand,
-		"LOAD":     loadCommand,
-		"SELECT":   selectCommand,
-		"FLUSHDB":  flushdbCommand,
-		"FLUSHALL": flushallCommand,
-	}
-}
-
-// checkNumberOfArguments checks if the number of arguments is as expected.
-func checkNumberOfArguments(args []string, expectedNumberOfArguments int) bool {
-	return len(args) >= expectedNumberOfArguments
+type CommandFunc func(ctx *commandContext, args []string) string
+
+// A ConnectionCommandFunc is the type of a Redis command function acting on the client connection.
+type ConnectionCommandFunc func(c *client, args []string) string
+
+// A CommandSpec describes a Redis command.
+// Either handler or connectionHandler is set.
+// arity is the number of arguments, including the command name.
+// A negative arity means at least that many arguments.
+// flags describe the command, e.g. "write" or "readonly".
+// keys locates the keys among the arguments, for commands taking keys.
+// The commands whose keys cannot be located by position, such as XREAD, also give findKeys,
+// which returns the indexes of the keys among the arguments, excluding the command name.
+type CommandSpec struct {
+	handler           CommandFunc
+	connectionHandler ConnectionCommandFunc
+	arity             int
+	flags             []string
+	keys              keySpec
+	findKeys          func(args []string) []int
+}
+
+// commands is the registry of the Redis commands, indexed by their upper case names.
+// It is filled once by the init functions of the files defining the commands.
+var commands = map[string]CommandSpec{}
+
+// registerCommand adds the command to the registry.
+// It panics if a command with the same name is already registered.
+func registerCommand(name string, spec CommandSpec) {
+	if _, ok := commands[name]; ok {
+		panic("command already registered: " + name)
+	}
+
+	commands[name] = spec
+}
+
+// lookupCommand returns the spec of the command with the given upper case name.
+func lookupCommand(name string) (CommandSpec, bool) {
+	spec, ok := commands[name]
+
+	return spec, ok
+}
+
+func init() {
+	registerCommand("PING", CommandSpec{handler: pingCommand, arity: -1, flags: []string{"fast", "loading"}})
+	registerCommand("ECHO", CommandSpec{handler: echoCommand, arity: 2, flags: []string{"fast", "loading"}})
+	registerCommand("QUIT", CommandSpec{connectionHandler: quitCommand, arity: -1, flags: []string{"fast", "loading", "no_auth"}})
+	registerCommand("SET", CommandSpec{handler: setCommand, arity: -3, flags: []string{"write"}, keys: keySpec{1, 1, 1, []string{"RW"}}})
+	registerCommand("SETEX", CommandSpec{handler: setexCommand, arity: 4, flags: []string{"write"}, keys: keySpec{1, 1, 1, []string{"OW"}}})
+	registerCommand("GET", CommandSpec{handler: getCommand, arity: 2, flags: []string{"readonly", "fast"}, keys: keySpec{1, 1, 1, []string{"RO"}}})
+	registerCommand("GETSET", CommandSpec{handler: getsetCommand, arity: 3, flags: []string{"write"}, keys: keySpec{1, 1, 1, []string{"RW"}}})
+	registerCommand("GETDEL", CommandSpec{handler: getdelCommand, arity: 2, flags: []string{"write", "fast"}, keys: keySpec{1, 1, 1, []string{"RW"}}})
+	registerCommand("APPEND", CommandSpec{handler: appendCommand, arity: 3, flags: []string{"write", "fast"}, keys: keySpec{1, 1, 1, []string{"RW"}}})
+	registerCommand("SETRANGE", CommandSpec{handler: setrangeCommand, arity: 4, flags: []string{"write"}, keys: keySpec{1, 1, 1, []string{"RW"}}})
+	registerCommand("MSET", CommandSpec{handler: msetCommand, arity: -3, flags: []string{"write"}, keys: keySpec{1, -1, 2, []string{"OW"}}})
+	registerCommand("MSETNX", CommandSpec{handler: msetnxCommand, arity: -3, flags: []string{"write"}, keys: keySpec{1, -1, 2, []string{"OW"}}})
+	registerCommand("MGET", CommandSpec{handler: mgetCommand, arity: -2, flags: []string{"readonly", "fast"}, keys: keySpec{1, -1, 1, []string{"RO"}}})
+	registerCommand("DEL", CommandSpec{handler: delCommand, arity: -2, flags: []string{"write"}, keys: keySpec{1, -1, 1, []string{"RM"}}})
+	registerCommand("INCR", CommandSpec{handler: incrCommand, arity: 2, flags: []string{"write", "fast"}, keys: keySpec{1, 1, 1, []string{"RW"}}})
+	registerCommand("INCRBY", CommandSpec{handler: incrbyCommand, arity: 3, flags: []string{"write", "fast"}, keys: keySpec{1, 1, 1, []string{"RW"}}})
+	registerCommand("DECR", CommandSpec{handler: decrCommand, arity: 2, flags: []string{"write", "fast"}, keys: keySpec{1, 1, 1, []string{"RW"}}})
+	registerCommand("DECRBY", CommandSpec{handler: decrbyCommand, arity: 3, flags: []string{"write", "fast"}, keys: keySpec{1, 1, 1, []string{"RW"}}})
+	registerCommand("EXPIRE", CommandSpec{handler: expireCommand, arity: 3, flags: []string{"write", "fast"}, keys: keySpec{1, 1, 1, []string{"RW"}}})
+	registerCommand("PEXPIREAT", CommandSpec{handler: pexpireatCommand, arity: 3, flags: []string{"write", "fast"}, keys: keySpec{1, 1, 1, []string{"RW"}}})
+	registerCommand("TTL", CommandSpec{handler: ttlCommand, arity: 2, flags: []string{"readonly", "fast"}, keys: keySpec{1, 1, 1, []string{"RO"}}})
+	registerCommand("PERSIST", CommandSpec{handler: persistCommand, arity: 2, flags: []string{"write", "fast"}, keys: keySpec{1, 1, 1, []string{"RW"}}})
+	registerCommand("EXISTS", CommandSpec{handler: existsCommand, arity: -2, flags: []string{"readonly", "fast"}, keys: keySpec{1, -1, 1, []string{"RO"}}})
+	registerCommand("KEYS", CommandSpec{handler: keysCommand, arity: 2, flags: []string{"readonly"}})
+	registerCommand("MOVE", CommandSpec{handler: moveCommand, arity: 3, flags: []string{"write", "fast"}, keys: keySpec{1, 1, 1, []string{"RW"}}})
+	registerCommand("RENAME", CommandSpec{handler: renameCommand, arity: 3, flags: []string{"write"}, keys: keySpec{1, 2, 1, []string{"RW"}}})
+	registerCommand("COPY", CommandSpec{handler: copyCommand, arity: -3, flags: []string{"write"}, keys: keySpec{1, 2, 1, []string{"RW"}}})
+	registerCommand("SAVE", CommandSpec{handler: saveCommand, arity: 1, flags: []string{"admin"}})
+	registerCommand("SHUTDOWN", CommandSpec{connectionHandler: shutdownCommand, arity: -1, flags: []string{"admin", "loading"}})
+	registerCommand("LOAD", CommandSpec{handler: loadCommand, arity: -2, flags: []string{"admin", "write"}})
+	registerCommand("DBSIZE", CommandSpec{handler: dbsizeCommand, arity: 1, flags: []string{"readonly", "fast"}})
+	registerCommand("SELECT", CommandSpec{handler: selectCommand, arity: 2, flags: []string{"fast"}})
+	registerCommand("FLUSHDB", CommandSpec{handler: flushdbCommand, arity: -1, flags: []string{"write"}})
+	registerCommand("FLUSHALL", CommandSpec{handler: flushallCommand, arity: -1, flags: []string{"write"}})
+	registerCommand("SWAPDB", CommandSpec{handler: swapdbCommand, arity: 3, flags: []string{"write", "fast"}})
+	registerCommand("TIME", CommandSpec{handler: timeCommand, arity: 1, flags: []string{"fast"}})
+	registerCommand("DEBUG", CommandSpec{handler: debugCommand, arity: -2, flags: []string{"admin"}})
+	registerCommand("WAIT", CommandSpec{handler: waitCommand, arity: 3})
+	registerCommand("INFO", CommandSpec{handler: infoCommand, arity: -1, flags: []string{"loading"}})
+	registerCommand("EVAL", CommandSpec{handler: scriptingCommand, arity: -3})
+	registerCommand("EVALSHA", CommandSpec{handler: scriptingCommand, arity: -3})
+	registerCommand("SCRIPT", CommandSpec{handler: scriptingCommand, arity: -2})
+	registerCommand("FUNCTION", CommandSpec{handler: scriptingCommand, arity: -2})
+	registerCommand("FCALL", CommandSpec{handler: scriptingCommand, arity: -3})
+	registerCommand("OBJECT", CommandSpec{handler: objectCommand, arity: -2, flags: []string{"readonly"}, keys: keySpec{2, 2, 1, []string{"RO"}}})
+	registerCommand("CLIENT", CommandSpec{connectionHandler: clientCommand, arity: -2, flags: []string{"loading"}})
+	registerCommand("SLOWLOG", CommandSpec{handler: slowlogCommand, arity: -2, flags: []string{"admin", "loading"}})
+}
+
+// hasFlag reports whether the command is described by the flag.
+func (spec CommandSpec) hasFlag(flag string) bool {
+	for _, f := range spec.flags {
+		if f == flag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// acceptsArguments checks if the command accepts the given number of arguments,
+// the command name excluded.
+func (spec CommandSpec) acceptsArguments(count int) bool {
+	if spec.arity < 0 {
+		return count+1 >= -spec.arity
+	}
+
+	return count+1 == spec.arity
 }
 
 // returnWrongNumberOfArgumentsError returns an error message for wrong number of arguments.
@@ -49,8 +153,21 @@ func returnWrongNumberOfArgumentsError(command string) string {
 	return returnError("wrong number of arguments for '" + command + "' command")
 }
 
+// returnUnknownSubcommandError returns an error message for an unknown subcommand of the command.
+func returnUnknownSubcommandError(command string, subcommand string) string {
+	return returnError("Unknown subcommand or wrong number of arguments for '" + subcommand + "'. Try " + command + " HELP.")
+}
+
+// returnHelp returns the usage lines of the subcommands of the command, followed by those of HELP.
+func returnHelp(command string, lines ...string) string {
+	help := append([]string{command + " <subcommand> [<arg> [value] [opt] ...]. Subcommands are:"}, lines...)
+	help = append(help, "HELP", "    Print this help.")
+
+	return returnArray(help)
+}
+
 // pingCommand returns PONG if called with no arguments, otherwise it returns the first argument.
-func pingCommand(args []string) string {
+func pingCommand(_ *commandContext, args []string) string {
 	if len(args) > 0 && args[0] != "" {
 		return returnBulkString(args[0])
 	}
@@ -58,74 +175,249 @@ func pingCommand(args []string) string {
 	return returnSimpleString("PONG")
 }
 
+// quitCommand asks the server to close the connection once the reply is written.
+func quitCommand(c *client, _ []string) string {
+	c.closeAfterReply = true
+
+	return returnSimpleString("OK")
+}
+
+// clientCommand runs a subcommand on the connection of the client.
+// ID returns the id of the connection.
+// GETNAME and SETNAME get and set the name of the connection.
+func clientCommand(c *client, args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "ID":
+		if len(args) != 1 {
+			return returnWrongNumberOfArgumentsError("CLIENT ID")
+		}
+
+		return returnInteger(int(c.id))
+	case "GETNAME":
+		if len(args) != 1 {
+			return returnWrongNumberOfArgumentsError("CLIENT GETNAME")
+		}
+
+		if c.name == "" {
+			return returnNullBulkString()
+		}
+
+		return returnBulkString(c.name)
+	case "SETNAME":
+		if len(args) != 2 {
+			return returnWrongNumberOfArgumentsError("CLIENT SETNAME")
+		}
+
+		if strings.ContainsAny(args[1], " \n") {
+			return returnError("Client names cannot contain spaces, newlines or special characters.")
+		}
+
+		c.name = args[1]
+
+		return returnSimpleString("OK")
+	case "PAUSE":
+		if len(args) != 2 && len(args) != 3 {
+			return returnWrongNumberOfArgumentsError("CLIENT PAUSE")
+		}
+
+		timeout, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return returnError("timeout is not an integer or out of range")
+		}
+
+		if timeout < 0 {
+			return returnError("timeout is negative")
+		}
+
+		all := true
+		if len(args) == 3 {
+			switch strings.ToUpper(args[2]) {
+			case "ALL":
+			case "WRITE":
+				all = false
+			default:
+				return returnError("syntax error")
+			}
+		}
+
+		c.server.pause.Pause(time.Now().Add(time.Duration(timeout)*time.Millisecond), all)
+
+		return returnSimpleString("OK")
+	case "UNPAUSE":
+		if len(args) != 1 {
+			return returnWrongNumberOfArgumentsError("CLIENT UNPAUSE")
+		}
+
+		c.server.pause.Unpause()
+
+		return returnSimpleString("OK")
+	case "HELP":
+		return returnHelp("CLIENT",
+			"ID",
+			"    Return the ID of the current connection.",
+			"GETNAME",
+			"    Return the name of the current connection.",
+			"SETNAME <name>",
+			"    Assign the name <name> to the current connection.",
+			"PAUSE <timeout> [WRITE|ALL]",
+			"    Suspend all, or just write, clients for <timeout> milliseconds.",
+			"UNPAUSE",
+			"    Stop the current client pause, resuming traffic.",
+		)
+	default:
+		return returnUnknownSubcommandError("CLIENT", args[0])
+	}
+}
+
 // echoCommand returns the first argument.
-func echoCommand(args []string) string {
+func echoCommand(_ *commandContext, args []string) string {
 	return returnBulkString(args[0])
 }
 
 // setCommand sets the value at key to value.
 // If key already holds a value, it is overwritten.
 // If PX or EX is specified, the value is set with the specified expiration.
-func setCommand(args []string) string {
-	validate := checkNumberOfArguments(args, 2)
-	if !validate {
-		return returnWrongNumberOfArgumentsError("SET")
+func setCommand(ctx *commandContext, args []string) string {
+	if int64(len(args[1])) > ctx.server.config.maxStringLength() {
+		return returnError(errStringTooLong.Error())
 	}
 
 	if len(args) >= 3 {
 		optionCommand := args[2]
 
+		if len(args) != 4 {
+			return returnError("syntax error")
+		}
+
 		switch strings.ToUpper(optionCommand) {
-		case "PX":
-			milliseconds, err := strconv.Atoi(args[3])
+		case "PX", "EX":
+			expiry, err := parseExpiry(optionCommand, args[3])
 			if err != nil {
-				return returnError("value is not an integer or out of range")
+				return returnExpiryError("set", err)
 			}
 
-			redis.databases[redis.selectedDB].Setpx(args[0], milliseconds, args[1])
-		case "EX":
-			seconds, err := strconv.Atoi(args[3])
+			ctx.db().Setpx(args[0], int(expiry.Milliseconds()), args[1])
+		case "PXAT":
+			expire, err := parseUnixMilliseconds(args[3])
 			if err != nil {
-				return returnError("value is not an integer or out of range")
+				return returnExpiryError("set", err)
 			}
 
-			redis.databases[redis.selectedDB].Setpx(args[0], seconds*1000, args[1])
+			ctx.db().SetExpireAt(args[0], args[1], expire)
 		default:
 			return returnError("unknown command '" + optionCommand + "'")
 		}
 	} else {
-		redis.databases[redis.selectedDB].Set(args[0], args[1])
+		ctx.db().Set(args[0], args[1])
 	}
 
 	return returnSimpleString("OK")
 }
 
 // setexCommand sets the value and expiration in seconds of a key.
-func setexCommand(args []string) string {
-	validate := checkNumberOfArguments(args, 3)
-	if !validate {
-		return returnWrongNumberOfArgumentsError("SETEX")
+func setexCommand(ctx *commandContext, args []string) string {
+	expiry, err := parseExpiry("EX", args[1])
+	if err != nil {
+		return returnExpiryError("setex", err)
+	}
+
+	ctx.db().Setpx(args[0], int(expiry.Milliseconds()), args[2])
+
+	return returnSimpleString("OK")
+}
+
+var (
+	errNotInteger        = errors.New("value is not an integer or out of range")
+	errInvalidExpireTime = errors.New("invalid expire time")
+	errStringTooLong     = errors.New("string exceeds maximum allowed size")
+)
+
+// parseExpiry parses the expiry of a new key given in unit, which is EX for seconds or PX for milliseconds.
+// It returns errNotInteger if value is not an integer,
+// and errInvalidExpireTime if the expiry is not positive or does not fit in a time.Duration.
+func parseExpiry(unit string, value string) (time.Duration, error) {
+	expiry, err := parseDuration(unit, value)
+	if err == nil && expiry <= 0 {
+		return 0, errInvalidExpireTime
 	}
 
-	seconds, err := strconv.Atoi(args[1])
+	return expiry, err
+}
+
+// parseDuration parses a duration given in unit, which is EX for seconds or PX for milliseconds.
+// Unlike parseExpiry, it accepts durations that are zero or negative, such as those given to EXPIRE.
+// It returns errNotInteger if value is not an integer,
+// and errInvalidExpireTime if the duration does not fit in a time.Duration.
+func parseDuration(unit string, value string) (time.Duration, error) {
+	var scale time.Duration
+
+	switch strings.ToUpper(unit) {
+	case "EX":
+		scale = time.Second
+	case "PX":
+		scale = time.Millisecond
+	default:
+		return 0, fmt.Errorf("unknown expiry unit '%s'", unit)
+	}
+
+	amount, err := strconv.ParseInt(value, 10, 64)
 	if err != nil {
-		return returnError("value is not an integer or out of range")
+		return 0, errNotInteger
 	}
 
-	redis.databases[redis.selectedDB].Setpx(args[0], seconds*1000, args[2])
+	limit := int64(math.MaxInt64 / scale)
+	if amount > limit || amount < -limit {
+		return 0, errInvalidExpireTime
+	}
 
-	return returnSimpleString("OK")
+	return time.Duration(amount) * scale, nil
+}
+
+// parseUnixMilliseconds parses an absolute expire time given as a Unix time in milliseconds.
+// It returns errNotInteger if value is not an integer, and errInvalidExpireTime if it is not positive.
+func parseUnixMilliseconds(value string) (time.Time, error) {
+	milliseconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, errNotInteger
+	}
+
+	if milliseconds <= 0 {
+		return time.Time{}, errInvalidExpireTime
+	}
+
+	return time.UnixMilli(milliseconds), nil
+}
+
+// returnExpiryError returns the error reply for an expiry that parseExpiry or parseDuration rejected in command.
+func returnExpiryError(command string, err error) string {
+	if errors.Is(err, errInvalidExpireTime) {
+		return returnError(err.Error() + " in '" + command + "' command")
+	}
+
+	return returnError(err.Error())
+}
+
+// returnKeyError returns the error reply for an operation on a key that failed with err,
+// keeping the WRONGTYPE prefix of errWrongType.
+func returnKeyError(err error) string {
+	if errors.Is(err, errWrongType) {
+		return "-" + err.Error() + "\r\n"
+	}
+
+	return returnError(err.Error())
 }
 
 // getCommand returns the value at key.
-func getCommand(args []string) string {
-	validate := checkNumberOfArguments(args, 1)
-	if !validate {
-		return returnWrongNumberOfArgumentsError("GET")
+func getCommand(ctx *commandContext, args []string) string {
+	value, found, err := ctx.db().Lookup(args[0])
+	// A key holding another type is a hit, as in Redis, even though its value cannot be read
+	ctx.server.recordKeyspaceLookup(found || err != nil)
+
+	if err != nil {
+		return returnKeyError(err)
 	}
 
-	value := redis.databases[redis.selectedDB].Get(args[0])
-	if value == "" {
+	if !found {
 		return returnNullBulkString()
 	}
 
@@ -133,14 +425,15 @@ func getCommand(args []string) string {
 }
 
 // getsetCommand sets the value at key to value and returns the old value at key.
-func getsetCommand(args []string) string {
-	validate := checkNumberOfArguments(args, 2)
-	if !validate {
-		return returnWrongNumberOfArgumentsError("GETSET")
+func getsetCommand(ctx *commandContext, args []string) string {
+	value, ok, err := ctx.db().GetSet(args[0], args[1])
+	if err != nil {
+		return returnKeyError(err)
 	}
 
-	value := redis.databases[redis.selectedDB].GetSet(args[0], args[1])
-	if value == "" {
+	ctx.server.recordKeyspaceLookup(ok)
+
+	if !ok {
 		return returnNullBulkString()
 	}
 
@@ -148,48 +441,68 @@ func getsetCommand(args []string) string {
 }
 
 // getdelCommand deletes the key and returns the value at key.
-func getdelCommand(args []string) string {
-	validate := checkNumberOfArguments(args, 1)
-	if !validate {
-		return returnWrongNumberOfArgumentsError("GETDEL")
+func getdelCommand(ctx *commandContext, args []string) string {
+	value, ok, err := ctx.db().GetDel(args[0])
+	if err != nil {
+		return returnKeyError(err)
 	}
 
-	value := redis.databases[redis.selectedDB].GetDel(args[0])
-	if value == "" {
+	ctx.server.recordKeyspaceLookup(ok)
+
+	if !ok {
 		return returnNullBulkString()
 	}
 
 	return returnBulkString(value)
 }
 
-// msetCommand sets the given keys to their respective values.
-func msetCommand(args []string) string {
-	validate := checkNumberOfArguments(args, 2)
-	if !validate {
-		return returnWrongNumberOfArgumentsError("MSET")
+// appendCommand appends the value to the value at key, and returns the new length of the value.
+func appendCommand(ctx *commandContext, args []string) string {
+	length, err := ctx.db().Append(args[0], args[1], ctx.server.config.maxStringLength())
+	if err != nil {
+		return returnKeyError(err)
+	}
+
+	return returnInteger(length)
+}
+
+// setrangeCommand overwrites the value at key from the given offset on, and returns the new length of the value.
+func setrangeCommand(ctx *commandContext, args []string) string {
+	offset, err := strconv.Atoi(args[1])
+	if err != nil {
+		return returnError(errNotInteger.Error())
+	}
+
+	if offset < 0 {
+		return returnError("offset is out of range")
+	}
+
+	length, err := ctx.db().SetRange(args[0], offset, args[2], ctx.server.config.maxStringLength())
+	if err != nil {
+		return returnKeyError(err)
 	}
 
+	return returnInteger(length)
+}
+
+// msetCommand sets the given keys to their respective values.
+func msetCommand(ctx *commandContext, args []string) string {
 	if len(args)%2 != 0 {
 		return returnError("wrong number of arguments for 'MSET' command")
 	}
 
-	redis.databases[redis.selectedDB].MSet(args...)
+	ctx.db().MSet(args...)
 
 	return returnSimpleString("OK")
 }
 
 // msetnxCommand sets the given keys to their respective values if none of the keys already exist.
-func msetnxCommand(args []string) string {
-	validate := checkNumberOfArguments(args, 2)
-	if !validate {
-		return returnWrongNumberOfArgumentsError("MSETNX")
-	}
-
+func msetnxCommand(ctx *commandContext, args []string) string {
 	if len(args)%2 != 0 {
 		return returnError("wrong number of arguments for 'MSETNX' command")
 	}
 
-	if redis.databases[redis.selectedDB].MSetNX(args...) {
+	if ctx.db().MSetNX(args...) {
 		return returnInteger(1)
 	}
 
@@ -197,90 +510,93 @@ func msetnxCommand(args []string) string {
 }
 
 // mgetCommand returns the values of all specified keys.
-func mgetCommand(args []string) string {
-	validate := checkNumberOfArguments(args, 1)
-	if !validate {
-		return returnWrongNumberOfArgumentsError("MGET")
+func mgetCommand(ctx *commandContext, args []string) string {
+	values := ctx.db().MGet(args...)
+	for _, value := range values {
+		ctx.server.recordKeyspaceLookup(value != nil)
 	}
 
-	values := redis.databases[redis.selectedDB].MGet(args...)
-	return returnArray(values)
+	return returnNullableArray(values)
 }
 
 // delCommand deletes the specified keys and returns the number of keys deleted.
-func delCommand(args []string) string {
-	validate := checkNumberOfArguments(args, 1)
-	if !validate {
-		return returnWrongNumberOfArgumentsError("DEL")
-	}
-
-	numberOfKeysDeleted := redis.databases[redis.selectedDB].Del(args...)
+func delCommand(ctx *commandContext, args []string) string {
+	numberOfKeysDeleted := ctx.db().Del(args...)
 	return returnInteger(numberOfKeysDeleted)
 }
 
 // incrCommand increments the number stored at key by one.
-func incrCommand(args []string) string {
-	validate := checkNumberOfArguments(args, 1)
-	if !validate {
-		return returnWrongNumberOfArgumentsError("INCR")
+func incrCommand(ctx *commandContext, args []string) string {
+	value, err := ctx.db().Incr(args[0])
+	if err != nil {
+		return returnKeyError(err)
 	}
 
-	return returnInteger(redis.databases[redis.selectedDB].Incr(args[0]))
+	return returnInteger(value)
 }
 
 // incrbyCommand increments the number stored at key by increment.
-func incrbyCommand(args []string) string {
-	validate := checkNumberOfArguments(args, 2)
-	if !validate {
-		return returnWrongNumberOfArgumentsError("INCRBY")
-	}
-
+func incrbyCommand(ctx *commandContext, args []string) string {
 	increment, err := strconv.Atoi(args[1])
 	if err != nil {
 		return returnError("value is not an integer or out of range")
 	}
 
-	return returnInteger(redis.databases[redis.selectedDB].IncrBy(args[0], increment))
+	value, err := ctx.db().IncrBy(args[0], increment)
+	if err != nil {
+		return returnKeyError(err)
+	}
+
+	return returnInteger(value)
 }
 
 // decrCommand decrements the number stored at key by one.
-func decrCommand(args []string) string {
-	validate := checkNumberOfArguments(args, 1)
-	if !validate {
-		return returnWrongNumberOfArgumentsError("DECR")
+func decrCommand(ctx *commandContext, args []string) string {
+	value, err := ctx.db().Decr(args[0])
+	if err != nil {
+		return returnKeyError(err)
 	}
 
-	return returnInteger(redis.databases[redis.selectedDB].Decr(args[0]))
+	return returnInteger(value)
 }
 
 // decrbyCommand decrements the number stored at key by decrement.
-func decrbyCommand(args []string) string {
-	validate := checkNumberOfArguments(args, 2)
-	if !validate {
-		return returnWrongNumberOfArgumentsError("DECRBY")
-	}
-
+func decrbyCommand(ctx *commandContext, args []string) string {
 	decrement, err := strconv.Atoi(args[1])
 	if err != nil {
 		return returnError("value is not an integer or out of range")
 	}
 
-	return returnInteger(redis.databases[redis.selectedDB].DecrBy(args[0], decrement))
+	value, err := ctx.db().DecrBy(args[0], decrement)
+	if err != nil {
+		return returnKeyError(err)
+	}
+
+	return returnInteger(value)
 }
 
 // expireCommand sets a timeout on key.
-func expireCommand(args []string) string {
-	validate := checkNumberOfArguments(args, 2)
-	if !validate {
-		return returnWrongNumberOfArgumentsError("EXPIRE")
+func expireCommand(ctx *commandContext, args []string) string {
+	expiry, err := parseDuration("EX", args[1])
+	if err != nil {
+		return returnExpiryError("expire", err)
 	}
 
-	seconds, err := strconv.Atoi(args[1])
+	if ctx.db().Expire(args[0], int(expiry/time.Second)) {
+		return returnInteger(1)
+	}
+
+	return returnInteger(0)
+}
+
+// pexpireatCommand sets the expire time of key to a Unix time in milliseconds.
+func pexpireatCommand(ctx *commandContext, args []string) string {
+	expire, err := parseUnixMilliseconds(args[1])
 	if err != nil {
-		return returnError("value is not an integer or out of range")
+		return returnExpiryError("pexpireat", err)
 	}
 
-	if redis.databases[redis.selectedDB].Expire(args[0], seconds) {
+	if ctx.db().ExpireAt(args[0], expire) {
 		return returnInteger(1)
 	}
 
@@ -288,25 +604,15 @@ func expireCommand(args []string) string {
 }
 
 // ttlCommand returns the remaining time to live of a key that has a timeout.
-func ttlCommand(args []string) string {
-	validate := checkNumberOfArguments(args, 1)
-	if !validate {
-		return returnWrongNumberOfArgumentsError("TTL")
-	}
-
-	seconds := redis.databases[redis.selectedDB].TTL(args[0])
+func ttlCommand(ctx *commandContext, args []string) string {
+	seconds := ctx.db().TTL(args[0])
 
 	return returnInteger(seconds)
 }
 
 // persistCommand removes the existing timeout on key.
-func persistCommand(args []string) string {
-	validate := checkNumberOfArguments(args, 1)
-	if !validate {
-		return returnWrongNumberOfArgumentsError("PERSIST")
-	}
-
-	if redis.databases[redis.selectedDB].Persist(args[0]) {
+func persistCommand(ctx *commandContext, args []string) string {
+	if ctx.db().Persist(args[0]) {
 		return returnInteger(1)
 	}
 
@@ -314,82 +620,610 @@ func persistCommand(args []string) string {
 }
 
 // existsCommand returns if key exists.
-func existsCommand(args []string) string {
-	validate := checkNumberOfArguments(args, 1)
-	if !validate {
-		return returnWrongNumberOfArgumentsError("EXISTS")
-	}
-
-	numberOfKeysExisting := redis.databases[redis.selectedDB].Exists(args...)
+func existsCommand(ctx *commandContext, args []string) string {
+	numberOfKeysExisting := ctx.db().Exists(args...)
 
 	return returnInteger(numberOfKeysExisting)
 }
 
 // keysCommand returns all keys matching pattern.
-func keysCommand(args []string) string {
-	validate := checkNumberOfArguments(args, 1)
-	if !validate {
-		return returnWrongNumberOfArgumentsError("KEYS")
+func keysCommand(ctx *commandContext, args []string) string {
+	keys, err := ctx.db().Keys(ctx, args[0])
+	if err != nil {
+		return returnError(err.Error())
 	}
 
-	keys := redis.databases[redis.selectedDB].Keys(args[0])
 	return returnArray(keys)
 }
 
+// dbsizeCommand returns the number of keys in the currently selected database.
+func dbsizeCommand(ctx *commandContext, _ []string) string {
+	return returnInteger(ctx.db().Size())
+}
+
+// moveCommand moves key from the currently selected database to the specified database.
+func moveCommand(ctx *commandContext, args []string) string {
+	index, err := strconv.Atoi(args[1])
+	if err != nil {
+		return returnError("value is not an integer")
+	}
+
+	if index < 0 || index >= len(ctx.server.databases) {
+		return returnError("value is out of range or invalid DB index")
+	}
+
+	if ctx.server.databases[index] == ctx.db() {
+		return returnError("source and destination objects are the same")
+	}
+
+	if ctx.db().Move(args[0], ctx.server.databases[index]) {
+		return returnInteger(1)
+	}
+
+	return returnInteger(0)
+}
+
+// renameCommand renames key to newkey, keeping its value, whatever its type, and its expire time.
+// If newkey already exists, it is overwritten.
+func renameCommand(ctx *commandContext, args []string) string {
+	if !ctx.db().Rename(args[0], args[1]) {
+		return returnError("no such key")
+	}
+
+	return returnSimpleString("OK")
+}
+
+// copyCommand copies the value of source, whatever its type, and its expire time, to destination.
+// DB copies it to another database, and REPLACE overwrites destination if it already exists.
+func copyCommand(ctx *commandContext, args []string) string {
+	destination := ctx.db()
+	replace := false
+
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "REPLACE":
+			replace = true
+		case "DB":
+			if i+1 == len(args) {
+				return returnError("syntax error")
+			}
+
+			i++
+
+			index, err := strconv.Atoi(args[i])
+			if err != nil {
+				return returnError("value is not an integer or out of range")
+			}
+
+			if index < 0 || index >= len(ctx.server.databases) {
+				return returnError("DB index is out of range")
+			}
+
+			destination = ctx.server.databases[index]
+		default:
+			return returnError("syntax error")
+		}
+	}
+
+	if destination == ctx.db() && args[0] == args[1] {
+		return returnError("source and destination objects are the same")
+	}
+
+	if ctx.db().Copy(args[0], destination, args[1], replace) {
+		return returnInteger(1)
+	}
+
+	return returnInteger(0)
+}
+
 // saveCommand saves the current database on disk.
-func saveCommand(_ []string) string {
-	redis.databases[redis.selectedDB].Save()
+func saveCommand(ctx *commandContext, _ []string) string {
+	if err := ctx.db().Save(); err != nil {
+		return returnError(err.Error())
+	}
+
 	return returnSimpleString("OK")
 }
 
-// loadCommand loads the current database from disk.
-func loadCommand(args []string) string {
-	validate := checkNumberOfArguments(args, 1)
-	if !validate {
-		return returnWrongNumberOfArgumentsError("LOAD")
+// shutdownCommand saves every database, unless NOSAVE is given, and exits the process.
+// Since the process exits, no reply is sent on success.
+// It is a connection command, so that Execute, which embeds the server in another process, refuses it.
+func shutdownCommand(c *client, args []string) string {
+	if len(args) > 1 {
+		return returnError("syntax error")
 	}
 
+	save := true
+
+	if len(args) == 1 {
+		switch strings.ToUpper(args[0]) {
+		case "SAVE":
+		case "NOSAVE":
+			save = false
+		default:
+			return returnError("syntax error")
+		}
+	}
+
+	os.Exit(c.server.Shutdown(save))
+
+	return ""
+}
+
+// loadCommand loads the current database from disk.
+func loadCommand(ctx *commandContext, args []string) string {
 	if len(args) > 0 {
-		redis.databases[redis.selectedDB].Load(args[0])
+		ctx.db().Load(args[0])
 	} else {
-		redis.databases[redis.selectedDB].Load("")
+		ctx.db().Load("")
 	}
 
 	return returnSimpleString("OK")
 }
 
 // selectCommand selects the database having the specified zero-based numeric index.
-func selectCommand(args []string) string {
-	if len(args) != 1 {
-		return returnWrongNumberOfArgumentsError("SELECT")
-	}
-
+func selectCommand(ctx *commandContext, args []string) string {
 	index, err := strconv.Atoi(args[0])
 	if err != nil {
 		return returnError("value is not an integer")
 	}
 
-	if index < 0 || index >= 16 {
+	if index < 0 || index >= len(ctx.server.databases) {
 		return returnError("value is out of range or invalid DB index")
 	}
 
-	redis.SelectDB(index)
-	redis.logger.Println("Switched to database id:", index)
+	ctx.server.SelectDB(index)
+	ctx.server.logger.Debugf("Switched to database id: %d", index)
 
 	return returnSimpleString("OK")
 }
 
 // flushdbCommand deletes all keys from the current database.
-func flushdbCommand(_ []string) string {
-	redis.databases[redis.selectedDB].Flush()
+func flushdbCommand(ctx *commandContext, _ []string) string {
+	ctx.db().Flush()
 	return returnSimpleString("OK")
 }
 
 // flushallCommand deletes all keys from all databases.
-func flushallCommand(_ []string) string {
-	for _, database := range redis.databases {
-		database.Flush()
+func flushallCommand(ctx *commandContext, _ []string) string {
+	ctx.server.FlushAll()
+
+	return returnSimpleString("OK")
+}
+
+// swapdbCommand swaps the contents of two databases.
+func swapdbCommand(ctx *commandContext, args []string) string {
+	first, err := strconv.Atoi(args[0])
+	if err != nil {
+		return returnError("invalid first DB index")
+	}
+
+	second, err := strconv.Atoi(args[1])
+	if err != nil {
+		return returnError("invalid second DB index")
+	}
+
+	if first < 0 || first >= len(ctx.server.databases) || second < 0 || second >= len(ctx.server.databases) {
+		return returnError("DB index is out of range")
+	}
+
+	ctx.server.SwapDB(first, second)
+
+	return returnSimpleString("OK")
+}
+
+// timeCommand returns the current server time as a two items array:
+// a Unix timestamp in seconds and the microseconds already elapsed in the current second.
+func timeCommand(_ *commandContext, _ []string) string {
+	now := time.Now()
+	seconds := strconv.FormatInt(now.Unix(), 10)
+	microseconds := fmt.Sprintf("%06d", now.Nanosecond()/1000)
+
+	return returnArray([]string{seconds, microseconds})
+}
+
+// sharedIntegers is the number of small integers, from 0, that Redis allocates once and shares between keys.
+const sharedIntegers = 10000
+
+// integerValue returns the integer held by the string value, and whether Redis would store it with the int encoding:
+// only the canonical decimal form of a 64-bit integer qualifies, so "100" does while "0100" or "+100" do not.
+func integerValue(value string) (int64, bool) {
+	if len(value) == 0 || len(value) > 20 {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != value {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// isSharedInteger reports whether the string value is one of the integers shared by Redis.
+func isSharedInteger(value string) bool {
+	n, ok := integerValue(value)
+
+	return ok && n >= 0 && n < sharedIntegers
+}
+
+// stringEncoding returns the name of the encoding Redis would use to store the string value.
+func stringEncoding(value string) string {
+	if _, ok := integerValue(value); ok {
+		return "int"
+	}
+
+	if len(value) <= 44 {
+		return "embstr"
+	}
+
+	return "raw"
+}
+
+// keyEncoding returns the name of the encoding Redis would use to store the string value of the key:
+// raw for the values modified in place by APPEND or SETRANGE, as Redis never re-encodes them, stringEncoding otherwise.
+func keyEncoding(db *Database, key string, value string) string {
+	if db.IsRaw(key) {
+		return "raw"
+	}
+
+	return stringEncoding(value)
+}
+
+// serializedLength returns the number of bytes the string value takes once serialized,
+// that is its length prefix followed by its contents.
+// Integers fitting in 32 bits are serialized as a type byte followed by 1, 2 or 4 bytes instead.
+func serializedLength(value string) int {
+	if n, ok := integerValue(value); ok {
+		switch {
+		case n >= math.MinInt8 && n <= math.MaxInt8:
+			return 2
+		case n >= math.MinInt16 && n <= math.MaxInt16:
+			return 3
+		case n >= math.MinInt32 && n <= math.MaxInt32:
+			return 5
+		}
+	}
+
+	switch {
+	case len(value) < 1<<6:
+		return 1 + len(value)
+	case len(value) < 1<<14:
+		return 2 + len(value)
+	default:
+		return 5 + len(value)
+	}
+}
+
+// objectCommand inspects the value stored at key.
+// ENCODING returns the internal encoding of the value, and REFCOUNT the number of references to it,
+// which is the maximum 32-bit integer for the shared integers, as in Redis.
+// Both return a null bulk string if the key does not exist.
+func objectCommand(ctx *commandContext, args []string) string {
+	subcommand := strings.ToUpper(args[0])
+
+	switch subcommand {
+	case "ENCODING", "REFCOUNT":
+		if len(args) != 2 {
+			return returnWrongNumberOfArgumentsError("OBJECT " + subcommand)
+		}
+
+		db := ctx.db()
+
+		value, ok, err := db.Lookup(args[1])
+		if err == errWrongType {
+			// The only other type is the stream, which has a single encoding
+			if subcommand == "REFCOUNT" {
+				return returnInteger(1)
+			}
+
+			return returnBulkString("stream")
+		}
+
+		if !ok {
+			return returnNullBulkString()
+		}
+
+		if subcommand == "REFCOUNT" {
+			if isSharedInteger(value) {
+				return returnInteger(math.MaxInt32)
+			}
+
+			return returnInteger(1)
+		}
+
+		return returnBulkString(keyEncoding(db, args[1], value))
+	case "HELP":
+		return returnHelp("OBJECT",
+			"ENCODING <key>",
+			"    Return the kind of internal representation used in order to store the value",
+			"    associated with a <key>.",
+			"REFCOUNT <key>",
+			"    Return the number of references of the value associated with the specified",
+			"    <key>.",
+		)
+	default:
+		return returnUnknownSubcommandError("OBJECT", args[0])
+	}
+}
+
+// debugCommand runs a debugging subcommand.
+// OBJECT describes the value stored at key.
+// SLEEP blocks the connection for the given number of seconds.
+// SET-ACTIVE-EXPIRE enables or disables the removal of expired keys in the background.
+// RELOAD saves every database on disk and loads them back.
+func debugCommand(ctx *commandContext, args []string) string {
+	subcommand := strings.ToUpper(args[0])
+
+	switch subcommand {
+	case "OBJECT":
+		if len(args) != 2 {
+			return returnWrongNumberOfArgumentsError("DEBUG OBJECT")
+		}
+
+		db := ctx.db()
+
+		value, ok, err := db.Lookup(args[1])
+		if err != nil {
+			return returnKeyError(err)
+		}
+
+		if !ok {
+			return returnError("no such key")
+		}
+
+		return returnSimpleString(fmt.Sprintf(
+			"refcount:1 encoding:%s serializedlength:%d lru:0 lru_seconds_idle:0",
+			keyEncoding(db, args[1], value),
+			serializedLength(value),
+		))
+	case "SLEEP":
+		if len(args) != 2 {
+			return returnWrongNumberOfArgumentsError("DEBUG SLEEP")
+		}
+
+		seconds, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return returnError("value is not a valid float")
+		}
+
+		timer := time.NewTimer(time.Duration(seconds * float64(time.Second)))
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return returnError(ctx.Err().Error())
+		}
+	case "SET-ACTIVE-EXPIRE":
+		if len(args) != 2 {
+			return returnWrongNumberOfArgumentsError("DEBUG SET-ACTIVE-EXPIRE")
+		}
+
+		switch args[1] {
+		case "0":
+			ctx.server.activeExpire.Store(false)
+		case "1":
+			ctx.server.activeExpire.Store(true)
+		default:
+			return returnError("value is not an integer or out of range")
+		}
+	case "RELOAD":
+		if len(args) != 1 {
+			return returnWrongNumberOfArgumentsError("DEBUG RELOAD")
+		}
+
+		if err := ctx.server.Reload(); err != nil {
+			return returnError("Error trying to reload the databases: " + err.Error())
+		}
+	case "STRINGMATCH-LEN":
+		if len(args) != 3 {
+			return returnWrongNumberOfArgumentsError("DEBUG STRINGMATCH-LEN")
+		}
+
+		matched, steps := stringMatchSteps(args[1], args[2], false)
+
+		result := 0
+		if matched {
+			result = 1
+		}
+
+		return "*2\r\n" + returnInteger(result) + returnInteger(steps)
+	case "HELP":
+		return returnHelp("DEBUG",
+			"OBJECT <key>",
+			"    Show low level info about the key and associated value.",
+			"SLEEP <seconds>",
+			"    Stop the server for <seconds>. Decimals allowed.",
+			"SET-ACTIVE-EXPIRE <0|1>",
+			"    Setting it to 0 disables expiring keys in background when they are not accessed.",
+			"RELOAD",
+			"    Save the databases on disk and reload them back to memory.",
+			"STRINGMATCH-LEN <pattern> <string>",
+			"    Return whether the string matches the glob-style pattern, and the number of steps taken to match it.",
+		)
+	default:
+		return returnUnknownSubcommandError("DEBUG", args[0])
 	}
 
 	return returnSimpleString("OK")
 }
+
+// waitCommand returns the number of replicas that acknowledged the previous write commands.
+// Since there are no replicas, it always returns 0 immediately.
+func waitCommand(_ *commandContext, args []string) string {
+	if len(args) != 2 {
+		return returnWrongNumberOfArgumentsError("WAIT")
+	}
+
+	if _, err := strconv.Atoi(args[0]); err != nil {
+		return returnError("value is not an integer or out of range")
+	}
+
+	timeout, err := strconv.Atoi(args[1])
+	if err != nil {
+		return returnError("timeout is not an integer or out of range")
+	}
+
+	if timeout < 0 {
+		return returnError("timeout is negative")
+	}
+
+	return returnInteger(0)
+}
+
+// An infoSection is a named section of the INFO reply.
+type infoSection struct {
+	name   string
+	fields func(server *RedisServer) string
+}
+
+// getInfoSections returns the INFO sections in the order they are reported.
+func getInfoSections() []infoSection {
+	return []infoSection{
+		{name: "Server", fields: serverInfo},
+		{name: "Clients", fields: clientsInfo},
+		{name: "Persistence", fields: persistenceInfo},
+		{name: "Stats", fields: statsInfo},
+		{name: "Replication", fields: replicationInfo},
+		{name: "Cluster", fields: clusterInfo},
+		{name: "Keyspace", fields: keyspaceInfo},
+	}
+}
+
+// redisVersion is the Redis version reported by INFO, which clients check before using newer commands.
+const redisVersion = "7.0.0"
+
+// serverInfo returns the fields of the Server section of INFO.
+func serverInfo(server *RedisServer) string {
+	return fmt.Sprintf("redis_version:%s\r\nprocess_id:%d\r\nrun_id:%s\r\n", redisVersion, os.Getpid(), server.runID)
+}
+
+// clientsInfo returns the fields of the Clients section of INFO.
+func clientsInfo(server *RedisServer) string {
+	return fmt.Sprintf("connected_clients:%d\r\n", server.stats.connectedClients.Load())
+}
+
+// persistenceInfo returns the fields of the Persistence section of INFO.
+func persistenceInfo(server *RedisServer) string {
+	loading := 0
+	if server.loading.Load() {
+		loading = 1
+	}
+
+	var changes int64
+	for _, database := range server.databases {
+		changes += database.ChangesSinceSave()
+	}
+
+	return fmt.Sprintf("loading:%d\r\nrdb_changes_since_last_save:%d\r\n", loading, changes)
+}
+
+// statsInfo returns the fields of the Stats section of INFO.
+func statsInfo(server *RedisServer) string {
+	return fmt.Sprintf(
+		"total_connections_received:%d\r\ntotal_commands_processed:%d\r\nexpired_keys:%d\r\nkeyspace_hits:%d\r\nkeyspace_misses:%d\r\n",
+		server.stats.totalConnectionsReceived.Load(),
+		server.stats.totalCommandsProcessed.Load(),
+		server.stats.expiredKeys.Load(),
+		server.stats.keyspaceHits.Load(),
+		server.stats.keyspaceMisses.Load(),
+	)
+}
+
+// keyspaceInfo returns the fields of the Keyspace section of INFO.
+// Only the databases holding at least one key are reported.
+func keyspaceInfo(server *RedisServer) string {
+	var builder strings.Builder
+
+	for i, database := range server.databases {
+		keys, expires := database.KeyCount()
+		if keys == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&builder, "db%d:keys=%d,expires=%d\r\n", i, keys, expires)
+	}
+
+	return builder.String()
+}
+
+// infoCommand returns information and statistics about the server.
+// If a section name is given, only that section is returned.
+func infoCommand(ctx *commandContext, args []string) string {
+	section := "all"
+	if len(args) > 0 {
+		section = strings.ToLower(args[0])
+	}
+
+	var builder strings.Builder
+
+	for _, infoSection := range getInfoSections() {
+		if section != "all" && section != "default" && section != strings.ToLower(infoSection.name) {
+			continue
+		}
+
+		if builder.Len() > 0 {
+			builder.WriteString("\r\n")
+		}
+
+		builder.WriteString("# " + infoSection.name + "\r\n")
+		builder.WriteString(infoSection.fields(ctx.server))
+	}
+
+	return returnBulkString(builder.String())
+}
+
+// slowlogCommand reads or resets the slowlog.
+// GET returns the most recent entries, 10 by default or all of them if count is -1.
+// LEN returns the number of entries and RESET deletes them.
+func slowlogCommand(ctx *commandContext, args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		count := 10
+
+		if len(args) > 1 {
+			var err error
+
+			count, err = strconv.Atoi(args[1])
+			if err != nil || count < -1 {
+				return returnError("count should be greater than or equal to -1")
+			}
+		}
+
+		entries := ctx.server.slowlog.Get(count)
+		reply := "*" + strconv.Itoa(len(entries)) + "\r\n"
+
+		for _, entry := range entries {
+			reply += "*4\r\n" +
+				returnInteger(int(entry.id)) +
+				returnInteger(int(entry.timestamp)) +
+				returnInteger(int(entry.duration)) +
+				returnArray(entry.args)
+		}
+
+		return reply
+	case "LEN":
+		return returnInteger(ctx.server.slowlog.Len())
+	case "RESET":
+		ctx.server.slowlog.Reset()
+		return returnSimpleString("OK")
+	case "HELP":
+		return returnHelp("SLOWLOG",
+			"GET [<count>]",
+			"    Return top <count> entries from the slowlog (default: 10, -1 mean all).",
+			"LEN",
+			"    Return the length of the slowlog.",
+			"RESET",
+			"    Reset the slowlog.",
+		)
+	default:
+		return returnUnknownSubcommandError("SLOWLOG", args[0])
+	}
+}
+
+// scriptingCommand replies to the scripting commands (EVAL, EVALSHA, SCRIPT, FUNCTION and FCALL),
+// so that clients probing for scripting support get a clear answer instead of an unknown command error.
+func scriptingCommand(_ *commandContext, _ []string) string {
+	return returnError("This Redis build does not support scripting")
+}
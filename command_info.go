@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// A keySpec locates the keys among the arguments of a command, and describes how they are accessed.
+// Positions count the command name as 0, so the first argument is at 1.
+// The keys are found from firstKey to lastKey, every step arguments.
+// A negative lastKey counts from the end, -1 being the last argument.
+// A firstKey of 0 means that the command takes no keys.
+// flags describe the access to the keys: RO (read only), RW (read and write),
+// OW (overwrite) or RM (remove).
+type keySpec struct {
+	firstKey int
+	lastKey  int
+	step     int
+	flags    []string
+}
+
+// keyPositions returns the indexes of the keys among the arguments of the command, excluding its name.
+func (spec keySpec) keyPositions(args []string) []int {
+	if spec.firstKey == 0 {
+		return nil
+	}
+
+	last := spec.lastKey
+	if last < 0 {
+		last += len(args) + 1
+	}
+
+	var positions []int
+
+	for i := spec.firstKey; i <= last && i <= len(args); i += spec.step {
+		positions = append(positions, i-1)
+	}
+
+	return positions
+}
+
+// keyPositions returns the indexes of the keys among the arguments of the command, excluding its name,
+// found by its findKeys function if it has one, and by its keySpec otherwise.
+func (spec CommandSpec) keyPositions(args []string) []int {
+	if spec.findKeys != nil {
+		return spec.findKeys(args)
+	}
+
+	return spec.keys.keyPositions(args)
+}
+
+func init() {
+	registerCommand("COMMAND", CommandSpec{handler: commandCommand, arity: -2, flags: []string{"loading"}})
+}
+
+// commandCommand describes the registered commands.
+// COUNT returns the number of commands.
+// GETKEYS returns the keys of the given command line,
+// and GETKEYSANDFLAGS returns them along with the flags describing how they are accessed.
+func commandCommand(_ *commandContext, args []string) string {
+	subcommand := strings.ToUpper(args[0])
+
+	switch subcommand {
+	case "COUNT":
+		if len(args) != 1 {
+			return returnWrongNumberOfArgumentsError("COMMAND COUNT")
+		}
+
+		return returnInteger(len(commands))
+	case "GETKEYS", "GETKEYSANDFLAGS":
+		if len(args) < 2 {
+			return returnWrongNumberOfArgumentsError("COMMAND " + subcommand)
+		}
+
+		spec, ok := lookupCommand(strings.ToUpper(args[1]))
+		if !ok {
+			return returnError("Invalid command specified")
+		}
+
+		commandArgs := args[2:]
+		if !spec.acceptsArguments(len(commandArgs)) {
+			return returnError("Invalid number of arguments specified for command")
+		}
+
+		positions := spec.keyPositions(commandArgs)
+		if len(positions) == 0 {
+			return returnError("The command has no key arguments")
+		}
+
+		if subcommand == "GETKEYS" {
+			keys := make([]string, 0, len(positions))
+			for _, position := range positions {
+				keys = append(keys, commandArgs[position])
+			}
+
+			return returnArray(keys)
+		}
+
+		reply := "*" + strconv.Itoa(len(positions)) + "\r\n"
+
+		for _, position := range positions {
+			reply += "*2\r\n" +
+				returnBulkString(commandArgs[position]) +
+				returnArray(spec.keys.flags)
+		}
+
+		return reply
+	case "HELP":
+		return returnHelp("COMMAND",
+			"COUNT",
+			"    Return the total number of commands in this Redis server.",
+			"GETKEYS <full-command>",
+			"    Return the keys from a full Redis command.",
+			"GETKEYSANDFLAGS <full-command>",
+			"    Return the keys and the access flags from a full Redis command.",
+		)
+	default:
+		return returnUnknownSubcommandError("COMMAND", args[0])
+	}
+}
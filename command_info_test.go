@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+func TestCommandGetKeysAndFlags(t *testing.T) {
+	// Test with SET
+	result := commandCommand(testContext, []string{"GETKEYSANDFLAGS", "SET", "key", "value"})
+	if result != "*1\r\n*2\r\n$3\r\nkey\r\n*1\r\n$2\r\nRW\r\n" {
+		t.Errorf("commandCommand([]string{\"GETKEYSANDFLAGS\", \"SET\", \"key\", \"value\"}) = %q; want key with RW", result)
+	}
+
+	// Test with MSET
+	result = commandCommand(testContext, []string{"GETKEYSANDFLAGS", "MSET", "key1", "value1", "key2", "value2"})
+	if result != "*2\r\n*2\r\n$4\r\nkey1\r\n*1\r\n$2\r\nOW\r\n*2\r\n$4\r\nkey2\r\n*1\r\n$2\r\nOW\r\n" {
+		t.Errorf("commandCommand([]string{\"GETKEYSANDFLAGS\", \"MSET\", ...}) = %q; want key1 and key2 with OW", result)
+	}
+
+	// Test with GET, in lower case
+	result = commandCommand(testContext, []string{"GETKEYSANDFLAGS", "get", "key"})
+	if result != "*1\r\n*2\r\n$3\r\nkey\r\n*1\r\n$2\r\nRO\r\n" {
+		t.Errorf("commandCommand([]string{\"GETKEYSANDFLAGS\", \"get\", \"key\"}) = %q; want key with RO", result)
+	}
+
+	// Test with a subcommand taking a key
+	result = commandCommand(testContext, []string{"GETKEYS", "OBJECT", "ENCODING", "key"})
+	if result != "*1\r\n$3\r\nkey\r\n" {
+		t.Errorf("commandCommand([]string{\"GETKEYS\", \"OBJECT\", \"ENCODING\", \"key\"}) = %q; want key", result)
+	}
+
+	// Test with XREAD, whose keys come after STREAMS
+	result = commandCommand(testContext, []string{"GETKEYSANDFLAGS", "XREAD", "COUNT", "2", "STREAMS", "k1", "k2", "0-0", "0-1"})
+	if result != "*2\r\n*2\r\n$2\r\nk1\r\n*1\r\n$2\r\nRO\r\n*2\r\n$2\r\nk2\r\n*1\r\n$2\r\nRO\r\n" {
+		t.Errorf("commandCommand([]string{\"GETKEYSANDFLAGS\", \"XREAD\", ...}) = %q; want k1 and k2 with RO", result)
+	}
+
+	result = commandCommand(testContext, []string{"GETKEYS", "XREAD", "STREAMS", "k1", "k2", "0-0"})
+	if result != "-ERR The command has no key arguments\r\n" {
+		t.Errorf("commandCommand([]string{\"GETKEYS\", \"XREAD\", \"STREAMS\", \"k1\", \"k2\", \"0-0\"}) = %s; want -ERR The command has no key arguments\\r\\n", result)
+	}
+
+	// Test with a command without keys
+	result = commandCommand(testContext, []string{"GETKEYSANDFLAGS", "PING"})
+	if result != "-ERR The command has no key arguments\r\n" {
+		t.Errorf("commandCommand([]string{\"GETKEYSANDFLAGS\", \"PING\"}) = %s; want -ERR The command has no key arguments\\r\\n", result)
+	}
+
+	// Test with the wrong number of arguments
+	result = commandCommand(testContext, []string{"GETKEYSANDFLAGS", "GET"})
+	if result != "-ERR Invalid number of arguments specified for command\r\n" {
+		t.Errorf("commandCommand([]string{\"GETKEYSANDFLAGS\", \"GET\"}) = %s; want -ERR Invalid number of arguments specified for command\\r\\n", result)
+	}
+
+	// Test with an unknown command
+	result = commandCommand(testContext, []string{"GETKEYSANDFLAGS", "FOO", "key"})
+	if result != "-ERR Invalid command specified\r\n" {
+		t.Errorf("commandCommand([]string{\"GETKEYSANDFLAGS\", \"FOO\", \"key\"}) = %s; want -ERR Invalid command specified\\r\\n", result)
+	}
+}
@@ -1,20 +1,61 @@
 package main
 
 import (
-	"log"
+	"bufio"
+	"context"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
+// testServer is the server shared by the tests, and testContext runs commands against it.
+var (
+	testServer  *RedisServer
+	testContext *commandContext
+)
+
 func init() {
 	// Initialize database
-	redis = &RedisServer{
-		logger: log.New(os.Stdout, "", log.Ldate|log.Ltime),
-		config: &config{},
+	testServer = newTestServer()
+	testContext = &commandContext{Context: context.Background(), server: testServer}
+}
+
+// newTestServer returns an initialized server, with the default configuration and its own databases.
+// The configuration can be changed before the server is initialized.
+func newTestServer(configure ...func(cfg *config)) *RedisServer {
+	return newTestServerWithClock(realClock{}, configure...)
+}
+
+// newTestServerWithClock returns an initialized server like newTestServer,
+// whose expire times are set and checked against the given clock.
+func newTestServerWithClock(clock Clock, configure ...func(cfg *config)) *RedisServer {
+	cfg := &config{
+		logLevel:                levelInfo,
+		databases:               16,
+		slowlogLogSlowerThan:    10000,
+		slowlogMaxLen:           128,
+		protoMaxBulkLen:         defaultMaxBulkLength,
+		pubsubOutputBufferLimit: defaultPubsubOutputBufferLimit,
+	}
+
+	for _, f := range configure {
+		f(cfg)
 	}
 
-	redis.Init()
+	server := &RedisServer{
+		logger: newLogger(os.Stdout, cfg),
+		config: cfg,
+		clock:  clock,
+	}
+
+	server.Init()
+
+	return server
 }
 
 const (
@@ -25,18 +66,18 @@ const (
 )
 
 func teardown() {
-	redis.databases[redis.selectedDB].Flush()
+	testServer.databases[testServer.selectedDB].Flush()
 }
 
 func TestPingCommand(t *testing.T) {
 	// Test with no arguments
-	result := pingCommand([]string{})
+	result := pingCommand(testContext, []string{})
 	if result != returnSimpleString("PONG") {
 		t.Errorf("pingCommand([]string{}) = %s; want +PONG\\r\\n", result)
 	}
 
 	// Test with one argument
-	result = pingCommand([]string{"hello"})
+	result = pingCommand(testContext, []string{"hello"})
 	if result != returnBulkString("hello") {
 		t.Errorf("pingCommand([]string{\"hello\"}) = %s; want $5\\r\\nhello\\r\\n", result)
 	}
@@ -44,7 +85,7 @@ func TestPingCommand(t *testing.T) {
 
 func TestEchoCommand(t *testing.T) {
 	// Test with one argument
-	result := echoCommand([]string{"hello"})
+	result := echoCommand(testContext, []string{"hello"})
 	if result != returnBulkString("hello") {
 		t.Errorf("echoCommand([]string{\"hello\"}) = %s; want $5\\r\\nhello\\r\\n", result)
 	}
@@ -54,25 +95,49 @@ func TestSetCommand(t *testing.T) {
 	defer teardown()
 
 	// Test with two arguments
-	result := setCommand([]string{"key", "value"})
+	result := setCommand(testContext, []string{"key", "value"})
 	if result != okReply {
 		t.Errorf("setCommand([]string{\"key\", \"value\"}) = %s; want +OK\\r\\n", result)
 	}
 
 	// Test with three arguments and PX option
-	result = setCommand([]string{"key", "value", "PX", "1000"})
+	result = setCommand(testContext, []string{"key", "value", "PX", "1000"})
 	if result != okReply {
 		t.Errorf("setCommand([]string{\"key\", \"value\", \"PX\", \"1000\"}) = %s; want +OK\\r\\n", result)
 	}
 
 	// Test with three arguments and EX option
-	result = setCommand([]string{"key", "value", "EX", "1"})
+	result = setCommand(testContext, []string{"key", "value", "EX", "1"})
 	if result != okReply {
 		t.Errorf("setCommand([]string{\"key\", \"value\", \"EX\", \"1\"}) = %s; want +OK\\r\\n", result)
 	}
 
+	// Test with expiries that are not positive
+	for _, option := range [][]string{{"EX", "0"}, {"PX", "0"}, {"EX", "-1"}, {"PX", "-100"}} {
+		result = setCommand(testContext, []string{"key", "value", option[0], option[1]})
+		if result != "-ERR invalid expire time in 'set' command\r\n" {
+			t.Errorf("setCommand([]string{\"key\", \"value\", %q, %q}) = %s; want -ERR invalid expire time in 'set' command\\r\\n", option[0], option[1], result)
+		}
+	}
+
+	// Test with three arguments and PXAT option
+	at := time.Now().Add(time.Hour).UnixMilli()
+	result = setCommand(testContext, []string{"key", "value", "PXAT", strconv.FormatInt(at, 10)})
+	if result != okReply {
+		t.Errorf("setCommand([]string{\"key\", \"value\", \"PXAT\", ...}) = %s; want +OK\\r\\n", result)
+	}
+
+	if expire := testContext.db().GetExpire("key"); expire.UnixMilli() != at {
+		t.Errorf("expire time of key = %d after SET PXAT; want %d", expire.UnixMilli(), at)
+	}
+
+	result = setCommand(testContext, []string{"key", "value", "PXAT", "0"})
+	if result != "-ERR invalid expire time in 'set' command\r\n" {
+		t.Errorf("setCommand([]string{\"key\", \"value\", \"PXAT\", \"0\"}) = %s; want an invalid expire time error", result)
+	}
+
 	// Test with three arguments and unknown option
-	result = setCommand([]string{"key", "value", "FOO", "1"})
+	result = setCommand(testContext, []string{"key", "value", "FOO", "1"})
 	if result != "-ERR unknown command 'FOO'\r\n" {
 		t.Errorf("setCommand([]string{\"key\", \"value\", \"FOO\", \"1\"}) = %s; want -ERR unknown command 'FOO'\\r\\n", result)
 	}
@@ -82,85 +147,479 @@ func TestSetexCommand(t *testing.T) {
 	defer teardown()
 
 	// Test with two arguments
-	result := setexCommand([]string{"key", "1", "value"})
+	result := setexCommand(testContext, []string{"key", "1", "value"})
 	if result != okReply {
 		t.Errorf("setexCommand([]string{\"key\", \"1\", \"value\"}) = %s; want +OK\\r\\n", result)
 	}
+
+	// Test with expiries that are not positive
+	for _, seconds := range []string{"0", "-1"} {
+		result = setexCommand(testContext, []string{"key", seconds, "value"})
+		if result != "-ERR invalid expire time in 'setex' command\r\n" {
+			t.Errorf("setexCommand([]string{\"key\", %q, \"value\"}) = %s; want -ERR invalid expire time in 'setex' command\\r\\n", seconds, result)
+		}
+	}
+
+	// Test with an expiry that overflows
+	result = setexCommand(testContext, []string{"key", "9223372036854775807", "value"})
+	if result != "-ERR invalid expire time in 'setex' command\r\n" {
+		t.Errorf("setexCommand([]string{\"key\", \"9223372036854775807\", \"value\"}) = %s; want -ERR invalid expire time in 'setex' command\\r\\n", result)
+	}
+}
+
+func TestParseExpiry(t *testing.T) {
+	tests := []struct {
+		unit  string
+		value string
+		want  time.Duration
+		err   error
+	}{
+		{"EX", "10", 10 * time.Second, nil},
+		{"ex", "-1", 0, errInvalidExpireTime},
+		{"PX", "0", 0, errInvalidExpireTime},
+		{"PX", "1500", 1500 * time.Millisecond, nil},
+		{"EX", "9223372036854775807", 0, errInvalidExpireTime},
+		{"PX", "9223372036854775807", 0, errInvalidExpireTime},
+		{"PX", "99999999999999999999", 0, errNotInteger},
+		{"EX", "1.5", 0, errNotInteger},
+		{"EX", "abc", 0, errNotInteger},
+	}
+
+	for _, test := range tests {
+		got, err := parseExpiry(test.unit, test.value)
+		if got != test.want || err != test.err {
+			t.Errorf("parseExpiry(%q, %q) = %v, %v; want %v, %v", test.unit, test.value, got, err, test.want, test.err)
+		}
+	}
+
+	if _, err := parseExpiry("KEEPTTL", "1"); err == nil {
+		t.Errorf("parseExpiry(\"KEEPTTL\", \"1\") = nil error; want an unknown unit error")
+	}
+
+	// Test that durations which are not positive are accepted outside of new keys
+	if got, err := parseDuration("EX", "-1"); got != -time.Second || err != nil {
+		t.Errorf("parseDuration(\"EX\", \"-1\") = %v, %v; want -1s, nil", got, err)
+	}
 }
 
 func TestGetCommand(t *testing.T) {
 	defer teardown()
 
 	// Test with existing key
-	setCommand([]string{"key", "value"})
-	result := getCommand([]string{"key"})
+	setCommand(testContext, []string{"key", "value"})
+	result := getCommand(testContext, []string{"key"})
 	if result != "$5\r\nvalue\r\n" {
 		t.Errorf("getCommand([]string{\"key\"}) = %s; want $5\\r\\nvalue\\r\\n", result)
 	}
 
 	// Test with non-existing key
-	result = getCommand([]string{"non-existing-key"})
+	result = getCommand(testContext, []string{"non-existing-key"})
 	if result != nullReply {
 		t.Errorf("getCommand([]string{\"non-existing-key\"}) = %s; want $-1\\r\\n", result)
 	}
+
+	// Test with a key holding an empty string
+	setCommand(testContext, []string{"empty", ""})
+	result = getCommand(testContext, []string{"empty"})
+	if result != "$0\r\n\r\n" {
+		t.Errorf("getCommand([]string{\"empty\"}) = %q; want $0\\r\\n\\r\\n", result)
+	}
 }
 
 func TestGetSetCommand(t *testing.T) {
 	defer teardown()
 
 	// Test with existing key
-	setCommand([]string{"key", "value"})
-	result := getsetCommand([]string{"key", "new-value"})
+	setCommand(testContext, []string{"key", "value"})
+	result := getsetCommand(testContext, []string{"key", "new-value"})
 	if result != "$5\r\nvalue\r\n" {
 		t.Errorf("getsetCommand([]string{\"key\", \"new-value\"}) = %s; want $5\\r\\nvalue\\r\\n", result)
 	}
 
 	// Test with non-existing key
-	result = getsetCommand([]string{"non-existing-key", "value"})
+	result = getsetCommand(testContext, []string{"non-existing-key", "value"})
 	if result != nullReply {
 		t.Errorf("getsetCommand([]string{\"non-existing-key\", \"value\"}) = %s; want $-1\\r\\n", result)
 	}
 }
 
+func TestGetSetCommandClearsExpire(t *testing.T) {
+	defer teardown()
+
+	setCommand(testContext, []string{"key", "value", "EX", "10"})
+	result := getsetCommand(testContext, []string{"key", "new-value"})
+	if result != "$5\r\nvalue\r\n" {
+		t.Errorf("getsetCommand([]string{\"key\", \"new-value\"}) = %s; want $5\\r\\nvalue\\r\\n", result)
+	}
+
+	result = ttlCommand(testContext, []string{"key"})
+	if result != ":-1\r\n" {
+		t.Errorf("ttlCommand([]string{\"key\"}) = %s; want :-1\\r\\n", result)
+	}
+}
+
+func TestGetSetCommandConcurrent(t *testing.T) {
+	defer teardown()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			key := "key" + strconv.Itoa(i)
+			for j := 0; j < 100; j++ {
+				getsetCommand(testContext, []string{key, strconv.Itoa(j)})
+				getCommand(testContext, []string{key})
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	for i := 0; i < 10; i++ {
+		key := "key" + strconv.Itoa(i)
+		if getCommand(testContext, []string{key}) != returnBulkString("99") {
+			t.Errorf("database.Get(%q) = %s; want \"99\"", key, getCommand(testContext, []string{key}))
+		}
+	}
+}
+
+func TestGetSetCommandSameKeyConcurrent(t *testing.T) {
+	defer teardown()
+
+	const goroutines, iterations = 8, 200
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	seen := make(map[string]int)
+
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < iterations; j++ {
+				old := getsetCommand(testContext, []string{"key", strconv.Itoa(i*iterations + j)})
+
+				mu.Lock()
+				seen[old]++
+				mu.Unlock()
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	// Every value is set exactly once, so it can be returned as the old value at most once.
+	for reply, count := range seen {
+		if reply != nullReply && count > 1 {
+			t.Errorf("getsetCommand returned %q %d times; want at most once", reply, count)
+		}
+	}
+	if seen[nullReply] != 1 {
+		t.Errorf("getsetCommand returned %s %d times; want exactly once", nullReply, seen[nullReply])
+	}
+}
+
 func TestGetDelCommand(t *testing.T) {
 	defer teardown()
 
 	// Test with existing key
-	setCommand([]string{"key", "value"})
-	result := getdelCommand([]string{"key"})
+	setCommand(testContext, []string{"key", "value"})
+	result := getdelCommand(testContext, []string{"key"})
 	if result != "$5\r\nvalue\r\n" {
 		t.Errorf("getdelCommand([]string{\"key\"}) = %s; want $5\\r\\nvalue\\r\\n", result)
 	}
-	if getCommand([]string{"key"}) != nullReply {
-		t.Errorf("database.Get(\"key\") = %s; want \"\"", getCommand([]string{"key"}))
+	if getCommand(testContext, []string{"key"}) != nullReply {
+		t.Errorf("database.Get(\"key\") = %s; want \"\"", getCommand(testContext, []string{"key"}))
 	}
 
 	// Test with non-existing key
-	result = getdelCommand([]string{"non-existing-key"})
+	result = getdelCommand(testContext, []string{"non-existing-key"})
 	if result != nullReply {
 		t.Errorf("getdelCommand([]string{\"non-existing-key\"}) = %s; want $-1\\r\\n", result)
 	}
 }
 
+// blockingWriter holds the first write until release is closed, telling started it is held.
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.started) })
+	<-w.release
+
+	return len(p), nil
+}
+
+func TestSaveDoesNotBlockClients(t *testing.T) {
+	defer teardown()
+
+	database := testServer.databases[testServer.selectedDB]
+	database.Set("key", "value")
+
+	writer := &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+	saved := make(chan error)
+
+	go func() {
+		saved <- database.writeSnapshot(writer)
+	}()
+
+	<-writer.started
+
+	// The save is held in the middle of writing, yet the clients go on
+	served := make(chan string)
+	go func() {
+		setCommand(testContext, []string{"other", "value"})
+		served <- getCommand(testContext, []string{"key"})
+	}()
+
+	select {
+	case result := <-served:
+		if result != "$5\r\nvalue\r\n" {
+			t.Errorf("getCommand([]string{\"key\"}) = %s during a save; want $5\\r\\nvalue\\r\\n", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SET and GET did not complete while a save was being written")
+	}
+
+	close(writer.release)
+
+	if err := <-saved; err != nil {
+		t.Errorf("writeSnapshot returned %s", err)
+	}
+}
+
+func TestGetDelCommandEmptyValue(t *testing.T) {
+	defer teardown()
+
+	setCommand(testContext, []string{"key", ""})
+	result := getdelCommand(testContext, []string{"key"})
+	if result != "$0\r\n\r\n" {
+		t.Errorf("getdelCommand([]string{\"key\"}) = %s; want $0\\r\\n\\r\\n", result)
+	}
+
+	result = existsCommand(testContext, []string{"key"})
+	if result != zeroReply {
+		t.Errorf("existsCommand([]string{\"key\"}) = %s; want :0\\r\\n", result)
+	}
+}
+
+func TestGetSetGetDelCommandConcurrent(t *testing.T) {
+	defer teardown()
+
+	const goroutines, iterations = 8, 200
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	seen := make(map[string]int)
+
+	record := func(reply string) {
+		mu.Lock()
+		seen[reply]++
+		mu.Unlock()
+	}
+
+	for i := 0; i < goroutines; i++ {
+		wg.Add(2)
+
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < iterations; j++ {
+				record(getsetCommand(testContext, []string{"key", strconv.Itoa(i*iterations + j)}))
+			}
+		}(i)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < iterations; j++ {
+				record(getdelCommand(testContext, []string{"key"}))
+			}
+		}()
+	}
+
+	wg.Wait()
+	record(getdelCommand(testContext, []string{"key"}))
+
+	// Every value is set exactly once, so it is read back by exactly one GETSET or GETDEL.
+	for i := 0; i < goroutines*iterations; i++ {
+		reply := returnBulkString(strconv.Itoa(i))
+		if seen[reply] != 1 {
+			t.Errorf("value %d was returned %d times; want exactly once", i, seen[reply])
+		}
+	}
+}
+
+func TestAppendCommand(t *testing.T) {
+	defer teardown()
+
+	if result := appendCommand(testContext, []string{"key", "Hello"}); result != ":5\r\n" {
+		t.Errorf("appendCommand([]string{\"key\", \"Hello\"}) = %s; want :5\r\n", result)
+	}
+
+	if result := appendCommand(testContext, []string{"key", " World"}); result != ":11\r\n" {
+		t.Errorf("appendCommand([]string{\"key\", \" World\"}) = %s; want :11\r\n", result)
+	}
+
+	if result := getCommand(testContext, []string{"key"}); result != "$11\r\nHello World\r\n" {
+		t.Errorf("getCommand([]string{\"key\"}) = %q; want \"Hello World\"", result)
+	}
+}
+
+func TestSetrangeCommand(t *testing.T) {
+	defer teardown()
+
+	setCommand(testContext, []string{"key", "Hello World"})
+	if result := setrangeCommand(testContext, []string{"key", "6", "Redis"}); result != ":11\r\n" {
+		t.Errorf("setrangeCommand([]string{\"key\", \"6\", \"Redis\"}) = %s; want :11\r\n", result)
+	}
+
+	if result := getCommand(testContext, []string{"key"}); result != "$11\r\nHello Redis\r\n" {
+		t.Errorf("getCommand([]string{\"key\"}) = %q; want \"Hello Redis\"", result)
+	}
+
+	if result := setrangeCommand(testContext, []string{"padded", "2", "ab"}); result != ":4\r\n" {
+		t.Errorf("setrangeCommand([]string{\"padded\", \"2\", \"ab\"}) = %s; want :4\r\n", result)
+	}
+
+	if result := getCommand(testContext, []string{"padded"}); result != "$4\r\n\x00\x00ab\r\n" {
+		t.Errorf("getCommand([]string{\"padded\"}) = %q; want the value padded with zero bytes", result)
+	}
+
+	if result := setrangeCommand(testContext, []string{"missing", "0", ""}); result != zeroReply {
+		t.Errorf("setrangeCommand([]string{\"missing\", \"0\", \"\"}) = %s; want :0\r\n", result)
+	}
+
+	if result := existsCommand(testContext, []string{"missing"}); result != zeroReply {
+		t.Errorf("existsCommand([]string{\"missing\"}) = %s after an empty SETRANGE; want :0\r\n", result)
+	}
+
+	if result := setrangeCommand(testContext, []string{"key", "-1", "x"}); result != "-ERR offset is out of range\r\n" {
+		t.Errorf("setrangeCommand([]string{\"key\", \"-1\", \"x\"}) = %s; want an out of range error", result)
+	}
+}
+
+func TestStringLengthLimit(t *testing.T) {
+	defer teardown()
+
+	cfg := testServer.config
+
+	cfg.mutex.Lock()
+	cfg.protoMaxBulkLen = 10
+	cfg.mutex.Unlock()
+
+	defer func() {
+		cfg.mutex.Lock()
+		defer cfg.mutex.Unlock()
+
+		cfg.protoMaxBulkLen = defaultMaxBulkLength
+	}()
+
+	if result := setCommand(testContext, []string{"key", "0123456789"}); result != okReply {
+		t.Errorf("setCommand([]string{\"key\", \"0123456789\"}) = %s; want +OK at exactly the limit", result)
+	}
+
+	if result := setCommand(testContext, []string{"key", "0123456789a"}); result != "-ERR string exceeds maximum allowed size\r\n" {
+		t.Errorf("setCommand([]string{\"key\", \"0123456789a\"}) = %s; want a maximum allowed size error", result)
+	}
+
+	setCommand(testContext, []string{"short", "01234"})
+	if result := appendCommand(testContext, []string{"short", "567890"}); result != "-ERR string exceeds maximum allowed size\r\n" {
+		t.Errorf("appendCommand([]string{\"short\", \"567890\"}) = %s; want a maximum allowed size error", result)
+	}
+
+	if result := getCommand(testContext, []string{"short"}); result != "$5\r\n01234\r\n" {
+		t.Errorf("getCommand([]string{\"short\"}) = %q after a refused APPEND; want the value untouched", result)
+	}
+
+	if result := setrangeCommand(testContext, []string{"short", "8", "abc"}); result != "-ERR string exceeds maximum allowed size\r\n" {
+		t.Errorf("setrangeCommand([]string{\"short\", \"8\", \"abc\"}) = %s; want a maximum allowed size error", result)
+	}
+}
+
+func TestIncrCommandConcurrent(t *testing.T) {
+	defer teardown()
+
+	const goroutines, iterations = 8, 200
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < iterations; j++ {
+				incrCommand(testContext, []string{"counter"})
+				incrbyCommand(testContext, []string{"counter", "2"})
+				decrCommand(testContext, []string{"counter"})
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	result := getCommand(testContext, []string{"counter"})
+	if want := returnBulkString(strconv.Itoa(2 * goroutines * iterations)); result != want {
+		t.Errorf("getCommand([]string{\"counter\"}) = %s; want %s", result, want)
+	}
+}
+
+func TestIncrCommandKeepsExpire(t *testing.T) {
+	defer teardown()
+
+	setCommand(testContext, []string{"counter", "1", "EX", "100"})
+	incrCommand(testContext, []string{"counter"})
+
+	result := ttlCommand(testContext, []string{"counter"})
+	if result != ":99\r\n" && result != ":100\r\n" {
+		t.Errorf("ttlCommand([]string{\"counter\"}) = %s; want :99\\r\\n or :100\\r\\n", result)
+	}
+}
+
 func TestMsetCommand(t *testing.T) {
 	defer teardown()
 
 	// Test with even number of arguments
-	result := msetCommand([]string{"key1", "value1", "key2"})
+	result := msetCommand(testContext, []string{"key1", "value1", "key2"})
 	if result != "-ERR wrong number of arguments for 'MSET' command\r\n" {
 		t.Errorf("msetCommand([]string{\"key1\", \"value1\", \"key2\"}) = %s; want -ERR wrong number of arguments for 'MSET' command\\r\\n", result)
 	}
 
 	// Test with odd number of arguments
-	result = msetCommand([]string{"key1", "value1", "key2", "value2"})
+	result = msetCommand(testContext, []string{"key1", "value1", "key2", "value2"})
 	if result != okReply {
 		t.Errorf("msetCommand([]string{\"key1\", \"value1\", \"key2\", \"value2\"}) = %s; want +OK\\r\\n", result)
 	}
-	if getCommand([]string{"key1"}) != returnBulkString("value1") {
-		t.Errorf("database.Get(\"key1\") = %s; want \"value1\"", getCommand([]string{"key1"}))
+	if getCommand(testContext, []string{"key1"}) != returnBulkString("value1") {
+		t.Errorf("database.Get(\"key1\") = %s; want \"value1\"", getCommand(testContext, []string{"key1"}))
+	}
+	if getCommand(testContext, []string{"key2"}) != returnBulkString("value2") {
+		t.Errorf("database.Get(\"key2\") = %s; want \"value2\"", getCommand(testContext, []string{"key2"}))
+	}
+
+	// Test overwriting a stream
+	xaddCommand(testContext, []string{"stream", "*", "field", "value"})
+
+	result = msetCommand(testContext, []string{"stream", "value"})
+	if result != okReply {
+		t.Errorf("msetCommand([]string{\"stream\", \"value\"}) = %s; want +OK\r\n", result)
+	}
+	if getCommand(testContext, []string{"stream"}) != returnBulkString("value") {
+		t.Errorf("database.Get(\"stream\") = %s; want \"value\"", getCommand(testContext, []string{"stream"}))
 	}
-	if getCommand([]string{"key2"}) != returnBulkString("value2") {
-		t.Errorf("database.Get(\"key2\") = %s; want \"value2\"", getCommand([]string{"key2"}))
+	if result := xlenCommand(testContext, []string{"stream"}); !strings.HasPrefix(result, "-WRONGTYPE") {
+		t.Errorf("xlenCommand([]string{\"stream\"}) = %s after MSET; want a WRONGTYPE error", result)
 	}
 }
 
@@ -168,33 +627,44 @@ func TestMsetnxCommand(t *testing.T) {
 	defer teardown()
 
 	// Test with even number of arguments
-	result := msetnxCommand([]string{"key1", "value1", "key2"})
+	result := msetnxCommand(testContext, []string{"key1", "value1", "key2"})
 	if result != "-ERR wrong number of arguments for 'MSETNX' command\r\n" {
 		t.Errorf("msetnxCommand([]string{\"key1\", \"value1\", \"key2\"}) = %s; want -ERR wrong number of arguments for 'MSETNX' command\\r\\n", result)
 	}
 
 	// Test with non-existing keys
-	result = msetnxCommand([]string{"key1", "value1", "key2", "value2"})
+	result = msetnxCommand(testContext, []string{"key1", "value1", "key2", "value2"})
 	if result != oneReply {
 		t.Errorf("msetnxCommand([]string{\"key1\", \"value1\", \"key2\", \"value2\"}) = %s; want :1\\r\\n", result)
 	}
-	if getCommand([]string{"key1"}) != returnBulkString("value1") {
-		t.Errorf("database.Get(\"key1\") = %s; want \"value1\"", getCommand([]string{"key1"}))
+	if getCommand(testContext, []string{"key1"}) != returnBulkString("value1") {
+		t.Errorf("database.Get(\"key1\") = %s; want \"value1\"", getCommand(testContext, []string{"key1"}))
 	}
-	if getCommand([]string{"key2"}) != returnBulkString("value2") {
-		t.Errorf("database.Get(\"key2\") = %s; want \"value2\"", getCommand([]string{"key2"}))
+	if getCommand(testContext, []string{"key2"}) != returnBulkString("value2") {
+		t.Errorf("database.Get(\"key2\") = %s; want \"value2\"", getCommand(testContext, []string{"key2"}))
 	}
 
 	// Test with existing keys
-	result = msetnxCommand([]string{"key1", "new-value1", "key2", "value2"})
+	result = msetnxCommand(testContext, []string{"key1", "new-value1", "key2", "value2"})
 	if result != zeroReply {
 		t.Errorf("msetnxCommand([]string{\"key1\", \"new-value1\", \"key2\", \"value2\"}) = %s; want :0\\r\\n", result)
 	}
-	if getCommand([]string{"key1"}) != returnBulkString("value1") {
-		t.Errorf("database.Get(\"key1\") = %s; want \"value1\"", getCommand([]string{"key1"}))
+	if getCommand(testContext, []string{"key1"}) != returnBulkString("value1") {
+		t.Errorf("database.Get(\"key1\") = %s; want \"value1\"", getCommand(testContext, []string{"key1"}))
+	}
+	if getCommand(testContext, []string{"key2"}) != returnBulkString("value2") {
+		t.Errorf("database.Get(\"key2\") = %s; want \"\"", getCommand(testContext, []string{"key2"}))
+	}
+
+	// Test with an existing stream
+	xaddCommand(testContext, []string{"stream", "*", "field", "value"})
+
+	result = msetnxCommand(testContext, []string{"key3", "value3", "stream", "value"})
+	if result != zeroReply {
+		t.Errorf("msetnxCommand([]string{\"key3\", \"value3\", \"stream\", \"value\"}) = %s; want :0\r\n", result)
 	}
-	if getCommand([]string{"key2"}) != returnBulkString("value2") {
-		t.Errorf("database.Get(\"key2\") = %s; want \"\"", getCommand([]string{"key2"}))
+	if getCommand(testContext, []string{"key3"}) != nullReply {
+		t.Errorf("database.Get(\"key3\") = %s; want nil", getCommand(testContext, []string{"key3"}))
 	}
 }
 
@@ -202,241 +672,754 @@ func TestMgetCommand(t *testing.T) {
 	defer teardown()
 
 	// Test with non-existing keys
-	result := mgetCommand([]string{"non-existing-key1", "non-existing-key2"})
+	result := mgetCommand(testContext, []string{"non-existing-key1", "non-existing-key2"})
 	if result != "*2\r\n$-1\r\n$-1\r\n" {
 		t.Errorf("mgetCommand([]string{\"non-existing-key1\", \"non-existing-key2\"}) = %s; want *2\\r\\n$-1\\r\\n$-1\\r\\n", result)
 	}
 
 	// Test with existing keys
-	msetCommand([]string{"key1", "value1", "key2", "value2"})
-	result = mgetCommand([]string{"key1", "key2"})
+	msetCommand(testContext, []string{"key1", "value1", "key2", "value2"})
+	result = mgetCommand(testContext, []string{"key1", "key2"})
 	if result != "*2\r\n$6\r\nvalue1\r\n$6\r\nvalue2\r\n" {
 		t.Errorf("mgetCommand([]string{\"key1\", \"key2\"}) = %s; want *2\\r\\n$6\\r\\nvalue1\\r\\n$6\\r\\nvalue2\\r\\n", result)
 	}
+
+	// Test with a stream among the keys
+	xaddCommand(testContext, []string{"stream", "*", "field", "value"})
+	result = mgetCommand(testContext, []string{"key1", "stream", "key2"})
+	if result != "*3\r\n$6\r\nvalue1\r\n$-1\r\n$6\r\nvalue2\r\n" {
+		t.Errorf("mgetCommand([]string{\"key1\", \"stream\", \"key2\"}) = %s; want *3\\r\\n$6\\r\\nvalue1\\r\\n$-1\\r\\n$6\\r\\nvalue2\\r\\n", result)
+	}
+
+	// Test with a key holding an empty string, told apart from a missing key
+	setCommand(testContext, []string{"empty", ""})
+	result = mgetCommand(testContext, []string{"empty", "key1", "missing"})
+	if result != "*3\r\n$0\r\n\r\n$6\r\nvalue1\r\n$-1\r\n" {
+		t.Errorf("mgetCommand([]string{\"empty\", \"key1\", \"missing\"}) = %q; want *3\\r\\n$0\\r\\n\\r\\n$6\\r\\nvalue1\\r\\n$-1\\r\\n", result)
+	}
 }
 
 func TestDelCommand(t *testing.T) {
 	// Test with non-existing key
-	result := delCommand([]string{"non-existing-key"})
+	result := delCommand(testContext, []string{"non-existing-key"})
 	if result != zeroReply {
 		t.Errorf("delCommand([]string{\"non-existing-key\"}) = %s; want :0\\r\\n", result)
 	}
 
 	// Test with existing key
-	setCommand([]string{"key", "value"})
-	result = delCommand([]string{"key"})
+	setCommand(testContext, []string{"key", "value"})
+	result = delCommand(testContext, []string{"key"})
 	if result != oneReply {
 		t.Errorf("delCommand([]string{\"key\"}) = %s; want :1\\r\\n", result)
 	}
 
-	if getCommand([]string{"key"}) != nullReply {
-		t.Errorf("database.Get(\"key\") = %s; want \"\"", getCommand([]string{"key"}))
+	if getCommand(testContext, []string{"key"}) != nullReply {
+		t.Errorf("database.Get(\"key\") = %s; want \"\"", getCommand(testContext, []string{"key"}))
 	}
 }
 
-func TestIncrCommand(t *testing.T) {
+func TestDelCommandEmptyValue(t *testing.T) {
 	defer teardown()
 
-	// Test with non-existing key
-	result := incrCommand([]string{"non-existing-key"})
+	setCommand(testContext, []string{"key", ""})
+	result := delCommand(testContext, []string{"key"})
 	if result != oneReply {
-		t.Errorf("incrCommand([]string{\"non-existing-key\"}) = %s; want :1\\r\\n", result)
+		t.Errorf("delCommand([]string{\"key\"}) = %s; want :1\\r\\n", result)
 	}
 
-	// Test with existing key
-	// redis.databases[redis.selectedDB].Set("key", "10")
-	setCommand([]string{"key", "10"})
-	result = incrCommand([]string{"key"})
-	if result != ":11\r\n" {
-		t.Errorf("incrCommand([]string{\"key\"}) = %s; want :11\\r\\n", result)
+	shard := testServer.databases[testServer.selectedDB].shardOf("key")
+	shard.mutex.RLock()
+	_, ok := shard.StringKeys["key"]
+	shard.mutex.RUnlock()
+	if ok {
+		t.Errorf("shard.StringKeys[\"key\"] was kept; want it to be deleted")
 	}
 }
 
-func TestDecrCommand(t *testing.T) {
-	defer teardown()
+func TestDelCommandMixedKeys(t *testing.T) {
+	ctx, clock := newTestContextWithClock()
 
-	// Test with non-existing key
-	result := decrCommand([]string{"non-existing-key"})
-	if result != ":-1\r\n" {
-		t.Errorf("decrCommand([]string{\"non-existing-key\"}) = %s; want :-1\\r\\n", result)
+	setCommand(ctx, []string{"key1", "value1"})
+	setCommand(ctx, []string{"key2", ""})
+	setCommand(ctx, []string{"key3", "value3", "EX", "100"})
+	setCommand(ctx, []string{"expired-key", "value", "PX", "1"})
+	clock.Advance(2 * time.Millisecond)
+
+	result := delCommand(ctx, []string{"key1", "key2", "key3", "expired-key", "non-existing-key"})
+	if result != ":3\r\n" {
+		t.Errorf("delCommand([]string{\"key1\", \"key2\", \"key3\", \"expired-key\", \"non-existing-key\"}) = %s; want :3\\r\\n", result)
 	}
 
-	// Test with existing key
-	setCommand([]string{"key", "10"})
-	result = decrCommand([]string{"key"})
-	if result != ":9\r\n" {
-		t.Errorf("decrCommand([]string{\"key\"}) = %s; want :9\\r\\n", result)
+	if keys, expires := ctx.db().KeyCount(); keys != 0 || expires != 0 {
+		t.Errorf("database.KeyCount() = %d, %d; want 0, 0", keys, expires)
 	}
 }
 
-func TestExpireCommand(t *testing.T) {
+func TestDelCommandDuplicateKeys(t *testing.T) {
 	defer teardown()
-	selectCommand([]string{"1"})
 
-	// Test with non-existing key
-	result := expireCommand([]string{"non-existing-key", "10"})
-	if result != zeroReply {
-		t.Errorf("expireCommand([]string{\"non-existing-key\", \"10\"}) = %s; want :0\\r\\n", result)
-	}
+	setCommand(testContext, []string{"key", "value"})
+	changes := testServer.databases[testServer.selectedDB].Changes()
 
-	// Test with existing key
-	setCommand([]string{"key", "value"})
-	result = expireCommand([]string{"key", "1"})
+	result := delCommand(testContext, []string{"key", "key"})
 	if result != oneReply {
-		t.Errorf("expireCommand([]string{\"key\", \"1\"}) = %s; want :1\\r\\n", result)
+		t.Errorf("delCommand([]string{\"key\", \"key\"}) = %s; want :1\r\n", result)
 	}
 
-	time.Sleep(2 * time.Second)
-	if getCommand([]string{"key"}) != nullReply {
-		t.Errorf("database.Get(\"key\") = %s; want \"\"", getCommand([]string{"key"}))
+	if got := testServer.databases[testServer.selectedDB].Changes() - changes; got != 1 {
+		t.Errorf("database.Changes() grew by %d after DEL; want 1", got)
 	}
-
-	selectCommand([]string{"0"})
 }
 
-func TestTtlCommand(t *testing.T) {
+func TestDelCommandConcurrent(t *testing.T) {
 	defer teardown()
-	selectCommand([]string{"2"})
 
-	// Test with non-existing key
-	result := ttlCommand([]string{"non-existing-key"})
-	if result != ":-2\r\n" {
-		t.Errorf("ttlCommand([]string{\"non-existing-key\"}) = %s; want :-2\\r\\n", result)
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			key := "key" + strconv.Itoa(i)
+			for j := 0; j < 100; j++ {
+				setCommand(testContext, []string{key, "value"})
+				if result := delCommand(testContext, []string{key}); result != oneReply {
+					t.Errorf("delCommand([]string{%q}) = %s; want :1\\r\\n", key, result)
+				}
+			}
+		}(i)
 	}
 
-	// Test with existing key
-	setCommand([]string{"key", "value"})
-	result = ttlCommand([]string{"key"})
+	wg.Wait()
+}
+
+func TestDelCommandClearsExpire(t *testing.T) {
+	defer teardown()
+
+	setCommand(testContext, []string{"key", "value", "EX", "10"})
+	delCommand(testContext, []string{"key"})
+	setCommand(testContext, []string{"key", "value"})
+
+	result := ttlCommand(testContext, []string{"key"})
 	if result != ":-1\r\n" {
 		t.Errorf("ttlCommand([]string{\"key\"}) = %s; want :-1\\r\\n", result)
 	}
-
-	expireCommand([]string{"key", "1"})
-	time.Sleep(2 * time.Second)
-	result = ttlCommand([]string{"key"})
-	if result != ":-2\r\n" {
-		t.Errorf("ttlCommand([]string{\"key\"}) = %s; want :-2\\r\\n", result)
-	}
-
-	selectCommand([]string{"0"})
 }
 
-func TestPersistCommand(t *testing.T) {
+func TestIncrCommand(t *testing.T) {
 	defer teardown()
-	selectCommand([]string{"3"})
 
 	// Test with non-existing key
-	result := persistCommand([]string{"non-existing-key"})
-	if result != zeroReply {
-		t.Errorf("persistCommand([]string{\"non-existing-key\"}) = %s; want :0\\r\\n", result)
+	result := incrCommand(testContext, []string{"non-existing-key"})
+	if result != oneReply {
+		t.Errorf("incrCommand([]string{\"non-existing-key\"}) = %s; want :1\\r\\n", result)
 	}
 
-	// Test with existing key that has no expiration
-	setCommand([]string{"key", "value"})
-	result = persistCommand([]string{"key"})
-	if result != zeroReply {
-		t.Errorf("persistCommand([]string{\"key\"}) = %s; want :0\\r\\n", result)
+	// Test with existing key
+	// testServer.databases[testServer.selectedDB].Set("key", "10")
+	setCommand(testContext, []string{"key", "10"})
+	result = incrCommand(testContext, []string{"key"})
+	if result != ":11\r\n" {
+		t.Errorf("incrCommand([]string{\"key\"}) = %s; want :11\\r\\n", result)
 	}
 
-	// Test with existing key that has expiration
-	expireCommand([]string{"key", "1"})
-	result = persistCommand([]string{"key"})
-	if result != oneReply {
-		t.Errorf("persistCommand([]string{\"key\"}) = %s; want :1\\r\\n", result)
+	notInteger := "-ERR value is not an integer or out of range\r\n"
+
+	// Test with a value that is not an integer
+	setCommand(testContext, []string{"text", "abc"})
+	result = incrbyCommand(testContext, []string{"text", "1"})
+	if result != notInteger {
+		t.Errorf("incrbyCommand([]string{\"text\", \"1\"}) = %s; want %s", result, notInteger)
 	}
 
-	time.Sleep(2 * time.Second)
-	if getCommand([]string{"key"}) == nullReply {
-		t.Errorf("database.Get(\"key\") = %s; want \"\"", getCommand([]string{"key"}))
+	if result := getCommand(testContext, []string{"text"}); result != returnBulkString("abc") {
+		t.Errorf("getCommand([]string{\"text\"}) = %s after INCRBY; want abc, left untouched", result)
 	}
 
-	selectCommand([]string{"0"})
+	// Test with an overflow
+	setCommand(testContext, []string{"max", "9223372036854775807"})
+	result = incrbyCommand(testContext, []string{"max", "1"})
+	if result != notInteger {
+		t.Errorf("incrbyCommand([]string{\"max\", \"1\"}) = %s; want %s", result, notInteger)
+	}
+
+	setCommand(testContext, []string{"min", "-9223372036854775808"})
+	result = decrCommand(testContext, []string{"min"})
+	if result != notInteger {
+		t.Errorf("decrCommand([]string{\"min\"}) = %s; want %s", result, notInteger)
+	}
+
+	if result := getCommand(testContext, []string{"max"}); result != returnBulkString("9223372036854775807") {
+		t.Errorf("getCommand([]string{\"max\"}) = %s after INCRBY; want 9223372036854775807, left untouched", result)
+	}
 }
 
-func TestExistsCommand(t *testing.T) {
+func TestDecrCommand(t *testing.T) {
 	defer teardown()
 
 	// Test with non-existing key
-	result := existsCommand([]string{"non-existing-key"})
-	if result != zeroReply {
-		t.Errorf("existsCommand([]string{\"non-existing-key\"}) = %s; want :0\\r\\n", result)
+	result := decrCommand(testContext, []string{"non-existing-key"})
+	if result != ":-1\r\n" {
+		t.Errorf("decrCommand([]string{\"non-existing-key\"}) = %s; want :-1\\r\\n", result)
 	}
 
 	// Test with existing key
-	setCommand([]string{"key", "value"})
-	result = existsCommand([]string{"key"})
-	if result != oneReply {
-		t.Errorf("existsCommand([]string{\"key\"}) = %s; want :1\\r\\n", result)
+	setCommand(testContext, []string{"key", "10"})
+	result = decrCommand(testContext, []string{"key"})
+	if result != ":9\r\n" {
+		t.Errorf("decrCommand([]string{\"key\"}) = %s; want :9\\r\\n", result)
 	}
 }
 
-func TestKeysCommand(t *testing.T) {
+func TestExpireCommand(t *testing.T) {
+	ctx, clock := newTestContextWithClock()
+
+	// Test with non-existing key
+	result := expireCommand(ctx, []string{"non-existing-key", "10"})
+	if result != zeroReply {
+		t.Errorf("expireCommand([]string{\"non-existing-key\", \"10\"}) = %s; want :0\r\n", result)
+	}
+
+	// Test with existing key
+	setCommand(ctx, []string{"key", "value"})
+	result = expireCommand(ctx, []string{"key", "1"})
+	if result != oneReply {
+		t.Errorf("expireCommand([]string{\"key\", \"1\"}) = %s; want :1\r\n", result)
+	}
+
+	clock.Advance(999 * time.Millisecond)
+	if getCommand(ctx, []string{"key"}) != "$5\r\nvalue\r\n" {
+		t.Errorf("database.Get(\"key\") = %s before its expire time; want \"value\"", getCommand(ctx, []string{"key"}))
+	}
+
+	clock.Advance(2 * time.Millisecond)
+	if getCommand(ctx, []string{"key"}) != nullReply {
+		t.Errorf("database.Get(\"key\") = %s; want \"\"", getCommand(ctx, []string{"key"}))
+	}
+}
+
+func TestPexpireatCommand(t *testing.T) {
+	ctx, clock := newTestContextWithClock()
+
+	at := clock.Now().Add(time.Second).Truncate(time.Millisecond)
+
+	// Test with non-existing key
+	result := pexpireatCommand(ctx, []string{"non-existing-key", strconv.FormatInt(at.UnixMilli(), 10)})
+	if result != zeroReply {
+		t.Errorf("pexpireatCommand([]string{\"non-existing-key\", ...}) = %s; want :0\r\n", result)
+	}
+
+	// Test with existing key
+	setCommand(ctx, []string{"key", "value"})
+	result = pexpireatCommand(ctx, []string{"key", strconv.FormatInt(at.UnixMilli(), 10)})
+	if result != oneReply {
+		t.Errorf("pexpireatCommand([]string{\"key\", ...}) = %s; want :1\r\n", result)
+	}
+
+	clock.Advance(at.Sub(clock.Now()) - time.Millisecond)
+	if result := getCommand(ctx, []string{"key"}); result != "$5\r\nvalue\r\n" {
+		t.Errorf("getCommand([]string{\"key\"}) = %s before its expire time; want $5\\r\\nvalue\\r\\n", result)
+	}
+
+	clock.Advance(2 * time.Millisecond)
+	if result := getCommand(ctx, []string{"key"}); result != nullReply {
+		t.Errorf("getCommand([]string{\"key\"}) = %s after its expire time; want $-1\\r\\n", result)
+	}
+
+	// Test with an invalid time
+	result = pexpireatCommand(ctx, []string{"key", "soon"})
+	if result != "-ERR value is not an integer or out of range\r\n" {
+		t.Errorf("pexpireatCommand([]string{\"key\", \"soon\"}) = %s; want an integer error", result)
+	}
+}
+
+func TestTtlCommand(t *testing.T) {
+	ctx, clock := newTestContextWithClock()
+
+	// Test with non-existing key
+	result := ttlCommand(ctx, []string{"non-existing-key"})
+	if result != ":-2\r\n" {
+		t.Errorf("ttlCommand([]string{\"non-existing-key\"}) = %s; want :-2\r\n", result)
+	}
+
+	// Test with existing key
+	setCommand(ctx, []string{"key", "value"})
+	result = ttlCommand(ctx, []string{"key"})
+	if result != ":-1\r\n" {
+		t.Errorf("ttlCommand([]string{\"key\"}) = %s; want :-1\r\n", result)
+	}
+
+	expireCommand(ctx, []string{"key", "10"})
+	result = ttlCommand(ctx, []string{"key"})
+	if result != ":10\r\n" {
+		t.Errorf("ttlCommand([]string{\"key\"}) = %s; want :10\r\n", result)
+	}
+
+	clock.Advance(3 * time.Second)
+	result = ttlCommand(ctx, []string{"key"})
+	if result != ":7\r\n" {
+		t.Errorf("ttlCommand([]string{\"key\"}) = %s after 3 seconds; want :7\r\n", result)
+	}
+
+	clock.Advance(8 * time.Second)
+	result = ttlCommand(ctx, []string{"key"})
+	if result != ":-2\r\n" {
+		t.Errorf("ttlCommand([]string{\"key\"}) = %s; want :-2\r\n", result)
+	}
+}
+
+func TestPersistCommand(t *testing.T) {
+	ctx, clock := newTestContextWithClock()
+
+	// Test with non-existing key
+	result := persistCommand(ctx, []string{"non-existing-key"})
+	if result != zeroReply {
+		t.Errorf("persistCommand([]string{\"non-existing-key\"}) = %s; want :0\r\n", result)
+	}
+
+	// Test with existing key that has no expiration
+	setCommand(ctx, []string{"key", "value"})
+	result = persistCommand(ctx, []string{"key"})
+	if result != zeroReply {
+		t.Errorf("persistCommand([]string{\"key\"}) = %s; want :0\r\n", result)
+	}
+
+	// Test with existing key that has expiration
+	expireCommand(ctx, []string{"key", "1"})
+	result = persistCommand(ctx, []string{"key"})
+	if result != oneReply {
+		t.Errorf("persistCommand([]string{\"key\"}) = %s; want :1\r\n", result)
+	}
+
+	clock.Advance(2 * time.Second)
+	if getCommand(ctx, []string{"key"}) == nullReply {
+		t.Errorf("database.Get(\"key\") = %s; want \"\"", getCommand(ctx, []string{"key"}))
+	}
+}
+
+func TestExistsCommand(t *testing.T) {
+	defer teardown()
+
+	// Test with non-existing key
+	result := existsCommand(testContext, []string{"non-existing-key"})
+	if result != zeroReply {
+		t.Errorf("existsCommand([]string{\"non-existing-key\"}) = %s; want :0\\r\\n", result)
+	}
+
+	// Test with existing key
+	setCommand(testContext, []string{"key", "value"})
+	result = existsCommand(testContext, []string{"key"})
+	if result != oneReply {
+		t.Errorf("existsCommand([]string{\"key\"}) = %s; want :1\\r\\n", result)
+	}
+}
+
+func TestExistsCommandDuplicateKeys(t *testing.T) {
+	defer teardown()
+
+	setCommand(testContext, []string{"key", "value"})
+
+	result := existsCommand(testContext, []string{"key", "key"})
+	if result != ":2\r\n" {
+		t.Errorf("existsCommand([]string{\"key\", \"key\"}) = %s; want :2\r\n", result)
+	}
+}
+
+func TestExistsCommandEmptyValue(t *testing.T) {
+	defer teardown()
+
+	setCommand(testContext, []string{"key", ""})
+	result := existsCommand(testContext, []string{"key"})
+	if result != oneReply {
+		t.Errorf("existsCommand([]string{\"key\"}) = %s; want :1\\r\\n", result)
+	}
+}
+
+func TestSetBytesGetBytes(t *testing.T) {
+	defer teardown()
+
+	db := testServer.databases[testServer.selectedDB]
+	db.SetBytes("key", []byte("value"))
+
+	// Test with an existing key
+	value, ok, _ := db.GetBytes("key")
+	if !ok || string(value) != "value" {
+		t.Errorf("GetBytes(\"key\") = %q, %t; want \"value\", true", value, ok)
+	}
+
+	result := getCommand(testContext, []string{"key"})
+	if result != returnBulkString("value") {
+		t.Errorf("getCommand([]string{\"key\"}) = %s; want $5\\r\\nvalue\\r\\n", result)
+	}
+
+	// Test with a non-existing key
+	value, ok, _ = db.GetBytes("non-existing-key")
+	if ok || value != nil {
+		t.Errorf("GetBytes(\"non-existing-key\") = %q, %t; want nil, false", value, ok)
+	}
+}
+
+func TestSaveLoadCommand(t *testing.T) {
+	defer teardown()
+
+	inTempDir(t, func() {
+		msetCommand(testContext, []string{"key1", "value1", "key2", "value2"})
+		setCommand(testContext, []string{"key3", "value3", "EX", "100"})
+
+		result := saveCommand(testContext, []string{})
+		if result != okReply {
+			t.Errorf("saveCommand([]string{}) = %s; want +OK\\r\\n", result)
+		}
+
+		if changes := testContext.db().ChangesSinceSave(); changes != 0 {
+			t.Errorf("ChangesSinceSave() = %d after SAVE; want 0", changes)
+		}
+
+		flushdbCommand(testContext, []string{})
+
+		if changes := testContext.db().ChangesSinceSave(); changes != 3 {
+			t.Errorf("ChangesSinceSave() = %d after FLUSHDB; want 3", changes)
+		}
+
+		result = loadCommand(testContext, []string{})
+		if result != okReply {
+			t.Errorf("loadCommand([]string{}) = %s; want +OK\\r\\n", result)
+		}
+
+		if changes := testContext.db().ChangesSinceSave(); changes != 0 {
+			t.Errorf("ChangesSinceSave() = %d after LOAD; want 0", changes)
+		}
+	})
+
+	result := mgetCommand(testContext, []string{"key1", "key2", "key3"})
+	if result != returnArray([]string{"value1", "value2", "value3"}) {
+		t.Errorf("mgetCommand([]string{\"key1\", \"key2\", \"key3\"}) = %s; want value1, value2 and value3", result)
+	}
+
+	result = ttlCommand(testContext, []string{"key3"})
+	if result != ":99\r\n" && result != ":100\r\n" {
+		t.Errorf("ttlCommand([]string{\"key3\"}) = %s; want :99\\r\\n or :100\\r\\n", result)
+	}
+}
+
+func TestKeysCommand(t *testing.T) {
 	defer teardown()
-	selectCommand([]string{"4"})
+	selectCommand(testContext, []string{"4"})
 
 	// Test with no keys
-	result := keysCommand([]string{"non-existing-pattern"})
+	result := keysCommand(testContext, []string{"non-existing-pattern"})
 	if result != "*0\r\n" {
 		t.Errorf("keysCommand([]string{\"non-existing-pattern\"}) = %s; want *0\\r\\n", result)
 	}
 
 	// Test with one key
-	setCommand([]string{"key1", "value1"})
-	result = keysCommand([]string{"key1"})
+	setCommand(testContext, []string{"key1", "value1"})
+	result = keysCommand(testContext, []string{"key1"})
 
 	if result != returnArray([]string{"key1"}) {
 		t.Errorf("keysCommand([]string{\"key1\"}) = %s; want *1\\r\\n$4\\r\nkey1\\r\\n", result)
 	}
 
-	// Test with multiple keys
-	msetCommand([]string{"key2", "value2", "key3", "value3"})
-	result = keysCommand([]string{"key*"})
+	// Test with multiple keys, returned in the order of their shards
+	msetCommand(testContext, []string{"key2", "value2", "key3", "value3"})
+	result = keysCommand(testContext, []string{"key*"})
+
+	if !strings.HasPrefix(result, "*3\r\n") ||
+		!strings.Contains(result, returnBulkString("key1")) ||
+		!strings.Contains(result, returnBulkString("key2")) ||
+		!strings.Contains(result, returnBulkString("key3")) {
+		t.Errorf("keysCommand([]string{\"key*\"}) = %s; want key1, key2 and key3", result)
+	}
+	selectCommand(testContext, []string{"0"})
+}
+
+func TestKeysCommandExpiredKey(t *testing.T) {
+	ctx, clock := newTestContextWithClock()
+
+	// Keep the expired key around until it is looked up
+	ctx.server.activeExpire.Store(false)
+
+	setCommand(ctx, []string{"key", "value"})
+	setCommand(ctx, []string{"expired", "value", "PX", "1"})
+	clock.Advance(2 * time.Millisecond)
+
+	result := keysCommand(ctx, []string{"*"})
+	if result != returnArray([]string{"key"}) {
+		t.Errorf("keysCommand([]string{\"*\"}) = %s; want *1\\r\\n$3\\r\\nkey\\r\\n", result)
+	}
+}
+
+func TestDbsizeCommand(t *testing.T) {
+	ctx, clock := newTestContextWithClock()
+
+	ctx.server.activeExpire.Store(false)
+
+	// Test with an empty database
+	result := dbsizeCommand(ctx, []string{})
+	if result != zeroReply {
+		t.Errorf("dbsizeCommand([]string{}) = %s; want :0\\r\\n", result)
+	}
+
+	// Test with an expired key
+	msetCommand(ctx, []string{"key1", "value1", "key2", "value2"})
+	setCommand(ctx, []string{"expired", "value", "PX", "1"})
+	setCommand(ctx, []string{"volatile", "value", "EX", "100"})
+	clock.Advance(2 * time.Millisecond)
+
+	result = dbsizeCommand(ctx, []string{})
+	if result != ":3\r\n" {
+		t.Errorf("dbsizeCommand([]string{}) = %s; want :3\\r\\n", result)
+	}
+}
+
+func TestKeysDuringExpirySweep(t *testing.T) {
+	defer teardown()
+
+	db := testServer.databases[testServer.selectedDB]
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				key := "key" + strconv.Itoa(i) + "-" + strconv.Itoa(j)
+				db.Setpx(key, 1, "value")
+				db.Keys(context.Background(), "key*")
+				db.Size()
+			}
+		}(i)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				db.checkAndRemoveExpiredKeys()
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestMoveCommand(t *testing.T) {
+	defer teardown()
+	defer testServer.databases[5].Flush()
 
-	if result != returnArray([]string{"key1", "key2", "key3"}) {
-		t.Errorf("keysCommand([]string{\"key*\"}) = %s; want *3\\r\\n$4\\r\nkey1\\r\\n$4\\r\nkey2\\r\\n$4\\r\nkey3\\r\\n", result)
+	// Test moving an existing key with expiration
+	setCommand(testContext, []string{"key", "value", "EX", "100"})
+	result := moveCommand(testContext, []string{"key", "5"})
+	if result != oneReply {
+		t.Errorf("moveCommand([]string{\"key\", \"5\"}) = %s; want :1\\r\\n", result)
+	}
+	if getCommand(testContext, []string{"key"}) != nullReply {
+		t.Errorf("database.Get(\"key\") = %s; want \"\"", getCommand(testContext, []string{"key"}))
+	}
+	if value := testServer.databases[5].Get("key"); value != "value" {
+		t.Errorf("databases[5].Get(\"key\") = %s; want \"value\"", value)
+	}
+	if ttl := testServer.databases[5].TTL("key"); ttl <= 0 {
+		t.Errorf("databases[5].TTL(\"key\") = %d; want a positive TTL", ttl)
+	}
+
+	// Test moving a key that already exists in the destination
+	setCommand(testContext, []string{"key", "other-value"})
+	result = moveCommand(testContext, []string{"key", "5"})
+	if result != zeroReply {
+		t.Errorf("moveCommand([]string{\"key\", \"5\"}) = %s; want :0\\r\\n", result)
+	}
+	if getCommand(testContext, []string{"key"}) != returnBulkString("other-value") {
+		t.Errorf("database.Get(\"key\") = %s; want \"other-value\"", getCommand(testContext, []string{"key"}))
+	}
+	if value := testServer.databases[5].Get("key"); value != "value" {
+		t.Errorf("databases[5].Get(\"key\") = %s; want \"value\"", value)
+	}
+
+	// Test moving a non-existing key
+	result = moveCommand(testContext, []string{"non-existing-key", "5"})
+	if result != zeroReply {
+		t.Errorf("moveCommand([]string{\"non-existing-key\", \"5\"}) = %s; want :0\\r\\n", result)
+	}
+
+	// Test moving a key to the currently selected database
+	result = moveCommand(testContext, []string{"key", "0"})
+	if result != "-ERR source and destination objects are the same\r\n" {
+		t.Errorf("moveCommand([]string{\"key\", \"0\"}) = %s; want -ERR source and destination objects are the same\\r\\n", result)
+	}
+}
+
+func TestMoveCommandExecute(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer()
+	server.Execute(3, "SET", "key", "value")
+
+	// Test moving a key to the database the command runs against
+	result := server.Execute(3, "MOVE", "key", "3")
+	if result != "-ERR source and destination objects are the same\r\n" {
+		t.Errorf("Execute(3, \"MOVE\", \"key\", \"3\") = %s; want -ERR source and destination objects are the same\\r\\n", result)
+	}
+
+	// Test moving a key to the selected database of the server
+	result = server.Execute(3, "MOVE", "key", "0")
+	if result != oneReply {
+		t.Errorf("Execute(3, \"MOVE\", \"key\", \"0\") = %s; want :1\\r\\n", result)
+	}
+
+	if result := server.Execute(0, "GET", "key"); result != "$5\r\nvalue\r\n" {
+		t.Errorf("Execute(0, \"GET\", \"key\") = %s after MOVE; want $5\\r\\nvalue\\r\\n", result)
+	}
+}
+
+func TestRenameCommand(t *testing.T) {
+	defer teardown()
+
+	// Test renaming a key holding the empty string
+	setCommand(testContext, []string{"empty", ""})
+	result := renameCommand(testContext, []string{"empty", "renamed-empty"})
+	if result != okReply {
+		t.Errorf("renameCommand([]string{\"empty\", \"renamed-empty\"}) = %s; want +OK\\r\\n", result)
+	}
+	if value, ok, _ := testServer.databases[testServer.selectedDB].Lookup("renamed-empty"); !ok || value != "" {
+		t.Errorf("Lookup(\"renamed-empty\") = %q, %t; want the empty string", value, ok)
+	}
+	if result := existsCommand(testContext, []string{"empty"}); result != zeroReply {
+		t.Errorf("existsCommand([]string{\"empty\"}) = %s; want :0\\r\\n", result)
+	}
+
+	// Test renaming a stream over an existing key
+	xaddCommand(testContext, []string{"stream", "1-1", "field", "value"})
+	setCommand(testContext, []string{"renamed-stream", "value"})
+	result = renameCommand(testContext, []string{"stream", "renamed-stream"})
+	if result != okReply {
+		t.Errorf("renameCommand([]string{\"stream\", \"renamed-stream\"}) = %s; want +OK\\r\\n", result)
+	}
+	want := "*1\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$5\r\nfield\r\n$5\r\nvalue\r\n"
+	if result := xrangeCommand(testContext, []string{"renamed-stream", "-", "+"}); result != want {
+		t.Errorf("xrangeCommand([]string{\"renamed-stream\", \"-\", \"+\"}) = %q; want %q", result, want)
+	}
+
+	// Test renaming a key with an expire time
+	setCommand(testContext, []string{"volatile", "value", "EX", "100"})
+	ttl := testServer.databases[testServer.selectedDB].GetExpire("volatile")
+	renameCommand(testContext, []string{"volatile", "renamed-volatile"})
+	if expire := testServer.databases[testServer.selectedDB].GetExpire("renamed-volatile"); !expire.Equal(ttl) {
+		t.Errorf("GetExpire(\"renamed-volatile\") = %s; want %s", expire, ttl)
+	}
+
+	// Test renaming a key onto itself
+	result = renameCommand(testContext, []string{"renamed-volatile", "renamed-volatile"})
+	if result != okReply || getCommand(testContext, []string{"renamed-volatile"}) != returnBulkString("value") {
+		t.Errorf("renameCommand([]string{\"renamed-volatile\", \"renamed-volatile\"}) = %s; want +OK and the key kept", result)
+	}
+
+	// Test renaming a non-existing key
+	result = renameCommand(testContext, []string{"non-existing-key", "other"})
+	if result != "-ERR no such key\r\n" {
+		t.Errorf("renameCommand([]string{\"non-existing-key\", \"other\"}) = %s; want -ERR no such key\\r\\n", result)
+	}
+}
+
+func TestCopyCommand(t *testing.T) {
+	defer teardown()
+	defer testServer.databases[5].Flush()
+
+	// Test copying a key with an expire time
+	setCommand(testContext, []string{"key", "", "EX", "100"})
+	result := copyCommand(testContext, []string{"key", "copy"})
+	if result != oneReply {
+		t.Errorf("copyCommand([]string{\"key\", \"copy\"}) = %s; want :1\\r\\n", result)
+	}
+	db := testServer.databases[testServer.selectedDB]
+	if value, ok, _ := db.Lookup("copy"); !ok || value != "" {
+		t.Errorf("Lookup(\"copy\") = %q, %t; want the empty string", value, ok)
+	}
+	if !db.GetExpire("copy").Equal(db.GetExpire("key")) {
+		t.Errorf("GetExpire(\"copy\") = %s; want %s", db.GetExpire("copy"), db.GetExpire("key"))
+	}
+
+	// Test copying onto an existing key, without and with REPLACE
+	setCommand(testContext, []string{"other", "value"})
+	result = copyCommand(testContext, []string{"other", "copy"})
+	if result != zeroReply {
+		t.Errorf("copyCommand([]string{\"other\", \"copy\"}) = %s; want :0\\r\\n", result)
+	}
+
+	result = copyCommand(testContext, []string{"other", "copy", "REPLACE"})
+	if result != oneReply || getCommand(testContext, []string{"copy"}) != returnBulkString("value") {
+		t.Errorf("copyCommand([]string{\"other\", \"copy\", \"REPLACE\"}) = %s; want :1 and the value replaced", result)
+	}
+
+	// Test copying a stream to another database, then adding to the copy only
+	xaddCommand(testContext, []string{"stream", "1-1", "field", "value"})
+	result = copyCommand(testContext, []string{"stream", "stream", "DB", "5"})
+	if result != oneReply {
+		t.Errorf("copyCommand([]string{\"stream\", \"stream\", \"DB\", \"5\"}) = %s; want :1\\r\\n", result)
+	}
+	if _, err := testServer.databases[5].XAdd("stream", "1-2", []string{"field", "value"}); err != nil {
+		t.Fatal(err)
+	}
+	if length, _ := db.XLen("stream"); length != 1 {
+		t.Errorf("XLen(\"stream\") = %d after adding to the copy; want 1", length)
+	}
+
+	// Test copying a key onto itself
+	result = copyCommand(testContext, []string{"key", "key"})
+	if result != "-ERR source and destination objects are the same\r\n" {
+		t.Errorf("copyCommand([]string{\"key\", \"key\"}) = %s; want -ERR source and destination objects are the same\\r\\n", result)
+	}
+
+	// Test copying a non-existing key
+	result = copyCommand(testContext, []string{"non-existing-key", "copy"})
+	if result != zeroReply {
+		t.Errorf("copyCommand([]string{\"non-existing-key\", \"copy\"}) = %s; want :0\\r\\n", result)
 	}
-	selectCommand([]string{"0"})
 }
 
 func TestSelectCommand(t *testing.T) {
 	// Test selecting an existing database
-	result := selectCommand([]string{"1"})
+	result := selectCommand(testContext, []string{"1"})
 	if result != okReply {
 		t.Errorf("selectCommand([]string{\"1\"}) = %s; want +OK\\r\\n", result)
 	}
 
 	// Test selecting a database that doesn't exist
-	result = selectCommand([]string{"100"})
+	result = selectCommand(testContext, []string{"100"})
 	if result != "-ERR value is out of range or invalid DB index\r\n" {
 		t.Errorf("selectCommand([]string{\"2\"}) = %s; want -ERR value is out of range or invalid DB index\\r\\n", result)
 	}
 
 	// Test selecting a database with a non-integer argument
-	result = selectCommand([]string{"non-integer"})
+	result = selectCommand(testContext, []string{"non-integer"})
 	if result != "-ERR value is not an integer\r\n" {
 		t.Errorf("selectCommand([]string{\"non-integer\"}) = %s; want -ERR value is not an integer\\r\\n", result)
 	}
 
 	// Test selecting a database with no argument
-	result = selectCommand([]string{})
+	result = dispatch(testServer.execute, nil, "SELECT", []string{})
 	if result != "-ERR wrong number of arguments for 'SELECT' command\r\n" {
 		t.Errorf("selectCommand([]string{}) = %s; want -ERR wrong number of arguments for 'SELECT' command\\r\\n", result)
 	}
 
 	// Test selecting a database with multiple arguments
-	result = selectCommand([]string{"1", "2"})
+	result = dispatch(testServer.execute, nil, "SELECT", []string{"1", "2"})
 	if result != "-ERR wrong number of arguments for 'SELECT' command\r\n" {
 		t.Errorf("selectCommand([]string{\"1\", \"2\"}) = %s; want -ERR wrong number of arguments for 'SELECT' command\\r\\n", result)
 	}
 
 	// Test selecting a database with a negative argument
-	result = selectCommand([]string{"-1"})
+	result = selectCommand(testContext, []string{"-1"})
 	if result != "-ERR value is out of range or invalid DB index\r\n" {
 		t.Errorf("selectCommand([]string{\"-1\"}) = %s; want -ERR value is out of range or invalid DB index\\r\\n", result)
 	}
 
 	// Test selecting a database with a zero argument
-	result = selectCommand([]string{"0"})
+	result = selectCommand(testContext, []string{"0"})
 	if result != okReply {
 		t.Errorf("selectCommand([]string{\"0\"}) = %s; want +OK\\r\\n", result)
 	}
@@ -444,18 +1427,18 @@ func TestSelectCommand(t *testing.T) {
 
 func TestFlushDBCommand(t *testing.T) {
 	// Test flushing an existing database
-	// redis.databases[redis.selectedDB].Set("key", "value")
-	setCommand([]string{"key", "value"})
-	result := flushdbCommand([]string{})
+	// testServer.databases[testServer.selectedDB].Set("key", "value")
+	setCommand(testContext, []string{"key", "value"})
+	result := flushdbCommand(testContext, []string{})
 	if result != okReply {
 		t.Errorf("flushDBCommand([]string{}) = %s; want +OK\\r\\n", result)
 	}
-	if getCommand([]string{"key"}) != nullReply {
-		t.Errorf("database.Get(\"key\") = %s; want \"\"", getCommand([]string{"key"}))
+	if getCommand(testContext, []string{"key"}) != nullReply {
+		t.Errorf("database.Get(\"key\") = %s; want \"\"", getCommand(testContext, []string{"key"}))
 	}
 
 	// Test flushing a non-existing database
-	result = flushdbCommand([]string{})
+	result = flushdbCommand(testContext, []string{})
 	if result != okReply {
 		t.Errorf("flushDBCommand([]string{}) = %s; want +OK\\r\\n", result)
 	}
@@ -463,21 +1446,739 @@ func TestFlushDBCommand(t *testing.T) {
 
 func TestFlushAllCommand(t *testing.T) {
 	// Test flushing all databases
-	setCommand([]string{"key1", "value1"})
-	selectCommand([]string{"1"})
-	setCommand([]string{"key2", "value2"})
+	setCommand(testContext, []string{"key1", "value1"})
+	selectCommand(testContext, []string{"1"})
+	setCommand(testContext, []string{"key2", "value2"})
 
-	result := flushallCommand([]string{})
+	result := flushallCommand(testContext, []string{})
 	if result != okReply {
 		t.Errorf("flushAllCommand([]string{}) = %s; want +OK\\r\\n", result)
 	}
 
-	if getCommand([]string{"key2"}) != nullReply {
-		t.Errorf("database.Get(\"key2\") = %s; want \"\"", getCommand([]string{"key2"}))
+	if getCommand(testContext, []string{"key2"}) != nullReply {
+		t.Errorf("database.Get(\"key2\") = %s; want \"\"", getCommand(testContext, []string{"key2"}))
+	}
+
+	selectCommand(testContext, []string{"0"})
+	if getCommand(testContext, []string{"key1"}) != nullReply {
+		t.Errorf("database.Get(\"key1\") = %s; want \"\"", getCommand(testContext, []string{"key1"}))
+	}
+}
+
+func TestFlushAllCommandClearsKeyState(t *testing.T) {
+	setCommand(testContext, []string{"volatile", "value", "EX", "100"})
+	xaddCommand(testContext, []string{"stream", "*", "field", "value"})
+	selectCommand(testContext, []string{"1"})
+	setCommand(testContext, []string{"other", "value", "PX", "100000"})
+	selectCommand(testContext, []string{"0"})
+
+	flushallCommand(testContext, []string{})
+
+	for i, database := range testServer.databases {
+		if keys, expires := database.KeyCount(); keys != 0 || expires != 0 {
+			t.Errorf("databases[%d].KeyCount() = %d, %d after FLUSHALL; want 0, 0", i, keys, expires)
+		}
+	}
+
+	if result := dbsizeCommand(testContext, []string{}); result != zeroReply {
+		t.Errorf("dbsizeCommand([]string{}) = %s after FLUSHALL; want :0\\r\\n", result)
+	}
+
+	if result := memoryCommand(testContext, []string{"USAGE", "volatile"}); result != nullReply {
+		t.Errorf("memoryCommand([]string{\"USAGE\", \"volatile\"}) = %s after FLUSHALL; want $-1\\r\\n", result)
+	}
+
+	if result := infoCommand(testContext, []string{"keyspace"}); strings.Contains(result, "db") {
+		t.Errorf("infoCommand([]string{\"keyspace\"}) = %s after FLUSHALL; want no database", result)
 	}
 
-	selectCommand([]string{"0"})
-	if getCommand([]string{"key1"}) != nullReply {
-		t.Errorf("database.Get(\"key1\") = %s; want \"\"", getCommand([]string{"key1"}))
+	// Test that a key set again does not inherit the expire time of the flushed one
+	setCommand(testContext, []string{"volatile", "value"})
+	defer teardown()
+
+	if result := ttlCommand(testContext, []string{"volatile"}); result != ":-1\r\n" {
+		t.Errorf("ttlCommand([]string{\"volatile\"}) = %s after FLUSHALL and SET; want :-1\\r\\n", result)
+	}
+}
+
+func TestSwapdbCommand(t *testing.T) {
+	server := newTestServer()
+	ctx := &commandContext{Context: context.Background(), server: server}
+
+	server.databases[0].Setpx("volatile", 100000, "value")
+	server.databases[1].Set("other", "value")
+
+	if result := swapdbCommand(ctx, []string{"0", "1"}); result != okReply {
+		t.Fatalf("swapdbCommand([]string{\"0\", \"1\"}) = %s; want +OK\r\n", result)
+	}
+
+	if _, ok, _ := server.databases[0].Lookup("volatile"); ok {
+		t.Errorf("databases[0] holds volatile after SWAPDB; want it in databases[1]")
+	}
+
+	if value, ok, _ := server.databases[0].Lookup("other"); !ok || value != "value" {
+		t.Errorf("databases[0].Lookup(\"other\") = %q, %t after SWAPDB; want \"value\", true", value, ok)
+	}
+
+	if expire := server.databases[1].GetExpire("volatile"); expire.IsZero() {
+		t.Errorf("databases[1].GetExpire(\"volatile\") is zero after SWAPDB; want the expire time to move along")
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"a", "1"}, "-ERR invalid first DB index\r\n"},
+		{[]string{"0", "b"}, "-ERR invalid second DB index\r\n"},
+		{[]string{"0", "16"}, "-ERR DB index is out of range\r\n"},
+		{[]string{"3", "3"}, okReply},
+	}
+
+	for _, test := range tests {
+		if result := swapdbCommand(ctx, test.args); result != test.want {
+			t.Errorf("swapdbCommand(%q) = %q; want %q", test.args, result, test.want)
+		}
+	}
+}
+
+func TestSwapdbCommandConcurrent(t *testing.T) {
+	server := newTestServer()
+	ctx := &commandContext{Context: context.Background(), server: server}
+
+	const writes = 200
+
+	var wg sync.WaitGroup
+
+	for db := 0; db < 2; db++ {
+		wg.Add(1)
+
+		go func(db int) {
+			defer wg.Done()
+
+			for i := 0; i < writes; i++ {
+				key := fmt.Sprintf("key-%d-%d", db, i)
+				server.databases[db].Set(key, "value")
+				server.databases[db].Get(key)
+				server.databases[db].Exists(key, "missing")
+			}
+		}(db)
+	}
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < writes; i++ {
+			swapdbCommand(ctx, []string{"0", "1"})
+		}
+	}()
+
+	wg.Wait()
+
+	// Every key landed in one of the databases, whichever it was swapped into
+	keys0, _ := server.databases[0].KeyCount()
+	keys1, _ := server.databases[1].KeyCount()
+	if keys0+keys1 != 2*writes {
+		t.Errorf("databases hold %d and %d keys after concurrent SWAPDB; want %d in total", keys0, keys1, 2*writes)
+	}
+}
+
+func TestTimeCommand(t *testing.T) {
+	result := timeCommand(testContext, []string{})
+
+	value, err := DecodeRESP(bufio.NewReader(strings.NewReader(result)))
+	if err != nil {
+		t.Fatalf("timeCommand([]string{}) = %s; error decoding reply: %s", result, err)
+	}
+
+	items := value.StringArray()
+	if len(items) != 2 {
+		t.Fatalf("timeCommand([]string{}) = %s; want a 2 items array", result)
+	}
+
+	seconds, err := strconv.ParseInt(items[0], 10, 64)
+	if err != nil {
+		t.Errorf("timeCommand([]string{}) seconds = %s; want a numeric string", items[0])
+	}
+	if diff := time.Now().Unix() - seconds; diff < 0 || diff > 1 {
+		t.Errorf("timeCommand([]string{}) seconds = %d; want close to %d", seconds, time.Now().Unix())
+	}
+
+	microseconds, err := strconv.Atoi(items[1])
+	if err != nil || len(items[1]) != 6 {
+		t.Errorf("timeCommand([]string{}) microseconds = %s; want a zero-padded numeric string", items[1])
+	}
+	if microseconds < 0 || microseconds > 999999 {
+		t.Errorf("timeCommand([]string{}) microseconds = %d; want between 0 and 999999", microseconds)
+	}
+}
+
+func TestDebugCommand(t *testing.T) {
+	defer teardown()
+
+	// Test sleeping for a fraction of a second
+	start := time.Now()
+	result := debugCommand(testContext, []string{"SLEEP", "0.1"})
+	if result != okReply {
+		t.Errorf("debugCommand([]string{\"SLEEP\", \"0.1\"}) = %s; want +OK\\r\\n", result)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("debugCommand([]string{\"SLEEP\", \"0.1\"}) returned after %s; want at least 100ms", elapsed)
+	}
+
+	// Test disabling active expire
+	ctx, clock := newTestContextWithClock()
+
+	result = debugCommand(ctx, []string{"SET-ACTIVE-EXPIRE", "0"})
+	if result != okReply {
+		t.Errorf("debugCommand([]string{\"SET-ACTIVE-EXPIRE\", \"0\"}) = %s; want +OK\\r\\n", result)
+	}
+
+	setCommand(ctx, []string{"key", "value", "PX", "100"})
+	clock.Advance(time.Second)
+	ctx.db().activeExpireCycle()
+
+	shard := ctx.db().shardOf("key")
+	shard.mutex.RLock()
+	_, ok := shard.StringKeys["key"]
+	shard.mutex.RUnlock()
+	if !ok {
+		t.Errorf("shard.StringKeys[\"key\"] was removed; want it to be kept until accessed")
+	}
+
+	if getCommand(ctx, []string{"key"}) != nullReply {
+		t.Errorf("database.Get(\"key\") = %s; want \"\"", getCommand(ctx, []string{"key"}))
+	}
+
+	shard.mutex.RLock()
+	_, ok = shard.StringKeys["key"]
+	shard.mutex.RUnlock()
+	if ok {
+		t.Errorf("shard.StringKeys[\"key\"] was kept; want it to be removed on access")
+	}
+
+	// Test enabling active expire again
+	debugCommand(ctx, []string{"SET-ACTIVE-EXPIRE", "1"})
+
+	setCommand(ctx, []string{"key", "value", "PX", "100"})
+	clock.Advance(time.Second)
+	ctx.db().activeExpireCycle()
+
+	if keys, _ := ctx.db().KeyCount(); keys != 0 {
+		t.Errorf("KeyCount() = %d after an active expire cycle; want 0", keys)
+	}
+
+	// Test with an unknown subcommand
+	result = debugCommand(testContext, []string{"FOO"})
+	if result != "-ERR Unknown subcommand or wrong number of arguments for 'FOO'. Try DEBUG HELP.\r\n" {
+		t.Errorf("debugCommand([]string{\"FOO\"}) = %s; want -ERR Unknown subcommand or wrong number of arguments for 'FOO'. Try DEBUG HELP.\\r\\n", result)
+	}
+}
+
+func TestDebugReloadCommand(t *testing.T) {
+	defer teardown()
+	defer testServer.databases[3].Flush()
+
+	setCommand(testContext, []string{"key", "value"})
+	setCommand(testContext, []string{"volatile", "value", "EX", "100"})
+	testServer.databases[3].Set("other", "value")
+
+	inTempDir(t, func() {
+		result := debugCommand(testContext, []string{"RELOAD"})
+		if result != okReply {
+			t.Errorf("debugCommand([]string{\"RELOAD\"}) = %s; want +OK\\r\\n", result)
+		}
+	})
+
+	result := mgetCommand(testContext, []string{"key", "volatile"})
+	if result != returnArray([]string{"value", "value"}) {
+		t.Errorf("mgetCommand([]string{\"key\", \"volatile\"}) = %s; want both values", result)
+	}
+
+	result = ttlCommand(testContext, []string{"volatile"})
+	if result != ":99\r\n" && result != ":100\r\n" {
+		t.Errorf("ttlCommand([]string{\"volatile\"}) = %s; want :99\\r\\n or :100\\r\\n", result)
+	}
+
+	result = ttlCommand(testContext, []string{"key"})
+	if result != ":-1\r\n" {
+		t.Errorf("ttlCommand([]string{\"key\"}) = %s; want :-1\\r\\n", result)
+	}
+
+	if value, ok, _ := testServer.databases[3].Lookup("other"); !ok || value != "value" {
+		t.Errorf("database 3 Lookup(\"other\") = %q, %t; want \"value\", true", value, ok)
+	}
+}
+
+func TestReloadFailureLeavesDatabasesUntouched(t *testing.T) {
+	server := newTestServer()
+
+	server.Execute(0, "SET", "key", "value")
+	server.Execute(3, "SET", "other", "value")
+
+	inTempDir(t, func() {
+		if err := server.SaveAll(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile("database_3_dump.db", []byte("corrupted"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		server.Execute(0, "SET", "key", "changed")
+
+		if err := server.loadAll(); err == nil {
+			t.Errorf("loadAll() returned no error with a corrupted dump")
+		}
+	})
+
+	if result := server.Execute(0, "GET", "key"); result != "$7\r\nchanged\r\n" {
+		t.Errorf("GET key = %q after a failed reload; want the value left untouched", result)
+	}
+
+	if result := server.Execute(3, "GET", "other"); result != "$5\r\nvalue\r\n" {
+		t.Errorf("GET other in database 3 = %q after a failed reload; want the value left untouched", result)
+	}
+}
+
+func TestWaitCommand(t *testing.T) {
+	// Test with valid arguments
+	result := waitCommand(testContext, []string{"1", "100"})
+	if result != zeroReply {
+		t.Errorf("waitCommand([]string{\"1\", \"100\"}) = %s; want :0\\r\\n", result)
+	}
+
+	// Test with a non-integer number of replicas
+	result = waitCommand(testContext, []string{"one", "100"})
+	if result != "-ERR value is not an integer or out of range\r\n" {
+		t.Errorf("waitCommand([]string{\"one\", \"100\"}) = %s; want -ERR value is not an integer or out of range\\r\\n", result)
+	}
+
+	// Test with a non-integer timeout
+	result = waitCommand(testContext, []string{"1", "soon"})
+	if result != "-ERR timeout is not an integer or out of range\r\n" {
+		t.Errorf("waitCommand([]string{\"1\", \"soon\"}) = %s; want -ERR timeout is not an integer or out of range\\r\\n", result)
+	}
+
+	// Test with a negative timeout
+	result = waitCommand(testContext, []string{"1", "-1"})
+	if result != "-ERR timeout is negative\r\n" {
+		t.Errorf("waitCommand([]string{\"1\", \"-1\"}) = %s; want -ERR timeout is negative\\r\\n", result)
+	}
+
+	// Test with a wrong number of arguments
+	result = waitCommand(testContext, []string{"1"})
+	if result != "-ERR wrong number of arguments for 'WAIT' command\r\n" {
+		t.Errorf("waitCommand([]string{\"1\"}) = %s; want -ERR wrong number of arguments for 'WAIT' command\\r\\n", result)
+	}
+}
+
+func TestShutdownCommand(t *testing.T) {
+	client := newTestClient(t)
+
+	// Test with an unknown option
+	result := client.send(t, "SHUTDOWN", "NOW")
+	if result != "-ERR syntax error\r\n" {
+		t.Errorf("SHUTDOWN NOW = %s; want -ERR syntax error\\r\\n", result)
+	}
+
+	// Test with too many options
+	result = client.send(t, "SHUTDOWN", "SAVE", "NOSAVE")
+	if result != "-ERR syntax error\r\n" {
+		t.Errorf("SHUTDOWN SAVE NOSAVE = %s; want -ERR syntax error\\r\\n", result)
+	}
+
+	// Test that Execute does not let the process exit
+	result = testServer.Execute(0, "SHUTDOWN", "NOSAVE")
+	if result != "-ERR Can't execute 'SHUTDOWN' without a connection\r\n" {
+		t.Errorf("Execute(SHUTDOWN NOSAVE) = %s; want a connection error", result)
+	}
+}
+
+func TestDatabaseChanges(t *testing.T) {
+	defer teardown()
+
+	db := testContext.db()
+
+	expectChanges := func(description string, want int64, run func()) {
+		t.Helper()
+
+		before := db.Changes()
+		run()
+
+		if got := db.Changes() - before; got != want {
+			t.Errorf("%s counted %d changes; want %d", description, got, want)
+		}
+	}
+
+	expectChanges("SET", 1, func() { setCommand(testContext, []string{"key", "1"}) })
+	expectChanges("INCR", 1, func() { incrCommand(testContext, []string{"key"}) })
+	expectChanges("EXPIRE", 1, func() { expireCommand(testContext, []string{"key", "100"}) })
+	expectChanges("PERSIST", 1, func() { persistCommand(testContext, []string{"key"}) })
+	expectChanges("MSET", 2, func() { msetCommand(testContext, []string{"a", "1", "b", "2"}) })
+	expectChanges("XADD", 1, func() { xaddCommand(testContext, []string{"stream", "*", "field", "value"}) })
+	expectChanges("DEL", 2, func() { delCommand(testContext, []string{"a", "b", "missing"}) })
+
+	// Reads, and writes that change nothing, are not counted
+	expectChanges("GET", 0, func() { getCommand(testContext, []string{"key"}) })
+	expectChanges("MGET", 0, func() { mgetCommand(testContext, []string{"key", "missing"}) })
+	expectChanges("XRANGE", 0, func() { xrangeCommand(testContext, []string{"stream", "-", "+"}) })
+	expectChanges("DEL of a missing key", 0, func() { delCommand(testContext, []string{"missing"}) })
+	expectChanges("GETDEL of a missing key", 0, func() { getdelCommand(testContext, []string{"missing"}) })
+	expectChanges("PERSIST without expire", 0, func() { persistCommand(testContext, []string{"key"}) })
+
+	setCommand(testContext, []string{"text", "abc"})
+	expectChanges("INCR of a non-integer", 0, func() { incrCommand(testContext, []string{"text"}) })
+
+	expectChanges("FLUSHDB", 3, func() { flushdbCommand(testContext, []string{}) })
+
+	if got := infoField(t, "rdb_changes_since_last_save"); got == 0 {
+		t.Errorf("rdb_changes_since_last_save = 0; want the changes since the last save")
+	}
+}
+
+func TestDebugObjectCommand(t *testing.T) {
+	defer teardown()
+
+	// Test with a short value
+	setCommand(testContext, []string{"key", "hello"})
+	result := debugCommand(testContext, []string{"OBJECT", "key"})
+	if !strings.Contains(result, "encoding:embstr ") || !strings.Contains(result, "serializedlength:6 ") {
+		t.Errorf("debugCommand([]string{\"OBJECT\", \"key\"}) = %s; want encoding:embstr serializedlength:6", result)
+	}
+
+	// Test with a long value
+	setCommand(testContext, []string{"key", strings.Repeat("a", 100)})
+	result = debugCommand(testContext, []string{"OBJECT", "key"})
+	if !strings.Contains(result, "encoding:raw ") || !strings.Contains(result, "serializedlength:102 ") {
+		t.Errorf("debugCommand([]string{\"OBJECT\", \"key\"}) = %s; want encoding:raw serializedlength:102", result)
+	}
+
+	// Test with an empty value
+	setCommand(testContext, []string{"key", ""})
+	result = debugCommand(testContext, []string{"OBJECT", "key"})
+	if !strings.Contains(result, "serializedlength:1 ") {
+		t.Errorf("debugCommand([]string{\"OBJECT\", \"key\"}) = %s; want serializedlength:1", result)
+	}
+
+	// Test with integers
+	for _, test := range []struct {
+		value  string
+		length int
+	}{{"100", 2}, {"1000", 3}, {"100000", 5}, {"10000000000", 12}} {
+		setCommand(testContext, []string{"key", test.value})
+
+		result = debugCommand(testContext, []string{"OBJECT", "key"})
+		if want := fmt.Sprintf("encoding:int serializedlength:%d ", test.length); !strings.Contains(result, want) {
+			t.Errorf("DEBUG OBJECT key = %s for %s; want %s", result, test.value, want)
+		}
+	}
+
+	// Test with a non-existing key
+	result = debugCommand(testContext, []string{"OBJECT", "non-existing-key"})
+	if result != "-ERR no such key\r\n" {
+		t.Errorf("debugCommand([]string{\"OBJECT\", \"non-existing-key\"}) = %s; want -ERR no such key\\r\\n", result)
+	}
+}
+
+func TestCommandRegistry(t *testing.T) {
+	names := []string{
+		"PING", "ECHO", "QUIT", "SET", "SETEX", "GET", "GETSET", "GETDEL", "MSET", "MSETNX", "MGET",
+		"DEL", "INCR", "INCRBY", "DECR", "DECRBY", "EXPIRE", "TTL", "PERSIST", "EXISTS", "KEYS",
+		"MOVE", "SAVE", "LOAD", "SELECT", "FLUSHDB", "FLUSHALL", "TIME", "DEBUG", "WAIT", "INFO",
+		"SLOWLOG", "MONITOR",
+	}
+
+	for _, name := range names {
+		spec, ok := lookupCommand(name)
+		if !ok {
+			t.Errorf("lookupCommand(%q) not found; want it registered", name)
+			continue
+		}
+
+		if (spec.handler == nil) == (spec.connectionHandler == nil) {
+			t.Errorf("lookupCommand(%q) has no handler or both handlers; want exactly one", name)
+		}
+
+		if spec.arity == 0 {
+			t.Errorf("lookupCommand(%q).arity = 0; want a non-zero arity", name)
+		}
+	}
+
+	if _, ok := lookupCommand("FOO"); ok {
+		t.Errorf("lookupCommand(\"FOO\") found; want it not registered")
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		lookupCommand("GET")
+	})
+	if allocs != 0 {
+		t.Errorf("lookupCommand(\"GET\") allocates %v times; want no allocation", allocs)
+	}
+}
+
+func TestDispatchArity(t *testing.T) {
+	defer teardown()
+
+	tests := []struct {
+		command string
+		args    []string
+	}{
+		{"ECHO", []string{}},
+		{"ECHO", []string{"hello", "world"}},
+		{"GET", []string{}},
+		{"GET", []string{"key", "other-key"}},
+		{"SET", []string{"key"}},
+		{"SETEX", []string{"key", "1"}},
+		{"DEL", []string{}},
+		{"TIME", []string{"now"}},
+		{"SELECT", []string{}},
+	}
+
+	for _, test := range tests {
+		want := "-ERR wrong number of arguments for '" + test.command + "' command\r\n"
+
+		result := dispatch(testServer.execute, nil, test.command, test.args)
+		if result != want {
+			t.Errorf("dispatch(%s, %q) = %s; want %s", test.command, test.args, result, want)
+		}
+	}
+
+	// Test that a valid number of arguments reaches the command
+	result := dispatch(testServer.execute, nil, "ECHO", []string{"hello"})
+	if result != returnBulkString("hello") {
+		t.Errorf("dispatch(ECHO, [hello]) = %s; want $5\\r\\nhello\\r\\n", result)
+	}
+
+	// Test an option missing its value
+	result = dispatch(testServer.execute, nil, "SET", []string{"key", "value", "PX"})
+	if result != "-ERR syntax error\r\n" {
+		t.Errorf("dispatch(SET, [key value PX]) = %s; want -ERR syntax error\\r\\n", result)
+	}
+
+	// Test an unknown command
+	result = dispatch(testServer.execute, nil, "FOO", []string{})
+	if result != "-ERR Unknown command 'FOO'\r\n" {
+		t.Errorf("dispatch(FOO, []) = %s; want -ERR Unknown command 'FOO'\\r\\n", result)
+	}
+}
+
+func BenchmarkConcurrentSet(b *testing.B) {
+	defer teardown()
+
+	db := testServer.databases[testServer.selectedDB]
+
+	var next atomic.Int64
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		prefix := "key" + strconv.FormatInt(next.Add(1), 10) + "-"
+		i := 0
+
+		for pb.Next() {
+			db.Set(prefix+strconv.Itoa(i%1024), "value")
+			i++
+		}
+	})
+}
+
+func TestScriptingCommands(t *testing.T) {
+	for _, args := range [][]string{
+		{"EVAL", "return 1", "0"},
+		{"EVALSHA", "e0e1f9fabfc9d4800c877a703b823ac0578ff8db", "0"},
+		{"SCRIPT", "LOAD", "return 1"},
+		{"FUNCTION", "LIST"},
+		{"FCALL", "myfunc", "0"},
+	} {
+		result := dispatch(testServer.execute, nil, args[0], args[1:])
+		if result != "-ERR This Redis build does not support scripting\r\n" {
+			t.Errorf("%s = %s; want -ERR This Redis build does not support scripting\\r\\n", args[0], result)
+		}
+	}
+}
+
+func TestObjectCommand(t *testing.T) {
+	defer teardown()
+
+	// Test with HELP
+	result := objectCommand(testContext, []string{"HELP"})
+	if !strings.HasPrefix(result, "*") || strings.HasPrefix(result, "*0\r\n") {
+		t.Errorf("objectCommand([]string{\"HELP\"}) = %s; want a non-empty array", result)
+	}
+
+	// Test with a key that does not exist
+	result = objectCommand(testContext, []string{"ENCODING", "key"})
+	if result != "$-1\r\n" {
+		t.Errorf("objectCommand([]string{\"ENCODING\", \"key\"}) = %s; want $-1\\r\\n", result)
+	}
+
+	// Test with an existing key
+	setCommand(testContext, []string{"key", "value"})
+
+	result = objectCommand(testContext, []string{"ENCODING", "key"})
+	if result != "$6\r\nembstr\r\n" {
+		t.Errorf("objectCommand([]string{\"ENCODING\", \"key\"}) = %s; want $6\\r\\nembstr\\r\\n", result)
+	}
+
+	result = objectCommand(testContext, []string{"REFCOUNT", "key"})
+	if result != ":1\r\n" {
+		t.Errorf("objectCommand([]string{\"REFCOUNT\", \"key\"}) = %s; want :1\\r\\n", result)
+	}
+
+	// Test with integers
+	encodingTests := []struct {
+		value string
+		want  string
+	}{
+		{"100", "int"},
+		{"-42", "int"},
+		{"9223372036854775807", "int"},
+		{"9223372036854775808", "embstr"},
+		{"100x", "embstr"},
+		{"0100", "embstr"},
+		{"+100", "embstr"},
+		{" 100", "embstr"},
+		{"-0", "embstr"},
+	}
+
+	for _, test := range encodingTests {
+		setCommand(testContext, []string{"key", test.value})
+
+		result = objectCommand(testContext, []string{"ENCODING", "key"})
+		if result != returnBulkString(test.want) {
+			t.Errorf("OBJECT ENCODING key = %q after SET key %q; want %s", result, test.value, test.want)
+		}
+	}
+
+	// INCR and DECR keep the int encoding
+	setCommand(testContext, []string{"key", "100"})
+	incrCommand(testContext, []string{"key"})
+	decrbyCommand(testContext, []string{"key", "1000"})
+
+	result = objectCommand(testContext, []string{"ENCODING", "key"})
+	if result != returnBulkString("int") {
+		t.Errorf("OBJECT ENCODING key = %q after INCR and DECRBY; want int", result)
+	}
+
+	// The shared integers have the refcount of Redis shared objects
+	result = objectCommand(testContext, []string{"REFCOUNT", "key"})
+	if result != ":1\r\n" {
+		t.Errorf("OBJECT REFCOUNT key = %q for -899; want :1\\r\\n", result)
+	}
+
+	setCommand(testContext, []string{"key", "9999"})
+
+	result = objectCommand(testContext, []string{"REFCOUNT", "key"})
+	if result != ":2147483647\r\n" {
+		t.Errorf("OBJECT REFCOUNT key = %q for 9999; want :2147483647\\r\\n", result)
+	}
+
+	// APPEND and SETRANGE make the value raw, until it is overwritten
+	setCommand(testContext, []string{"key", "1"})
+	appendCommand(testContext, []string{"key", "2"})
+
+	result = objectCommand(testContext, []string{"ENCODING", "key"})
+	if result != returnBulkString("raw") {
+		t.Errorf("OBJECT ENCODING key = %q after SET key 1 and APPEND key 2; want raw", result)
+	}
+
+	renameCommand(testContext, []string{"key", "renamed"})
+
+	result = objectCommand(testContext, []string{"ENCODING", "renamed"})
+	if result != returnBulkString("raw") {
+		t.Errorf("OBJECT ENCODING renamed = %q after RENAME; want raw", result)
+	}
+
+	setCommand(testContext, []string{"key", "abc"})
+	setrangeCommand(testContext, []string{"key", "1", "x"})
+
+	result = objectCommand(testContext, []string{"ENCODING", "key"})
+	if result != returnBulkString("raw") {
+		t.Errorf("OBJECT ENCODING key = %q after SETRANGE; want raw", result)
+	}
+
+	// Test with an unknown subcommand
+	result = objectCommand(testContext, []string{"FOO", "key"})
+	if result != "-ERR Unknown subcommand or wrong number of arguments for 'FOO'. Try OBJECT HELP.\r\n" {
+		t.Errorf("objectCommand([]string{\"FOO\", \"key\"}) = %s; want -ERR Unknown subcommand or wrong number of arguments for 'FOO'. Try OBJECT HELP.\\r\\n", result)
+	}
+}
+
+func enableLockFreeReads(cfg *config) {
+	cfg.lockFreeReads = true
+}
+
+func TestLockFreeReads(t *testing.T) {
+	clock := newManualClock()
+	server := newTestServerWithClock(clock, enableLockFreeReads)
+
+	tests := []struct {
+		command []string
+		want    string
+	}{
+		{[]string{"GET", "key"}, nullReply},
+		{[]string{"SET", "key", "value"}, okReply},
+		{[]string{"GET", "key"}, "$5\r\nvalue\r\n"},
+		{[]string{"INCR", "counter"}, oneReply},
+		{[]string{"MGET", "key", "counter", "missing"}, "*3\r\n$5\r\nvalue\r\n$1\r\n1\r\n$-1\r\n"},
+		{[]string{"EXPIRE", "key", "100"}, oneReply},
+		{[]string{"PERSIST", "key"}, oneReply},
+		{[]string{"TTL", "key"}, ":-1\r\n"},
+		{[]string{"DEL", "key"}, oneReply},
+		{[]string{"GET", "key"}, nullReply},
+		{[]string{"FLUSHDB"}, okReply},
+		{[]string{"GET", "counter"}, nullReply},
+	}
+
+	for _, test := range tests {
+		if result := server.Execute(0, test.command[0], test.command[1:]...); result != test.want {
+			t.Errorf("Execute(0, %q) = %q; want %q", test.command, result, test.want)
+		}
+	}
+
+	// Test with an expired key
+	server.Execute(0, "SET", "volatile", "value", "PX", "1")
+	clock.Advance(2 * time.Millisecond)
+
+	if result := server.Execute(0, "GET", "volatile"); result != nullReply {
+		t.Errorf("Execute(0, \"GET\", \"volatile\") = %s after its expire time; want $-1\\r\\n", result)
+	}
+
+	if size := server.databases[0].Size(); size != 0 {
+		t.Errorf("Size() = %d after the key expired; want 0", size)
+	}
+}
+
+// BenchmarkGetParallel compares the throughput of GET under heavy concurrency,
+// with reads taking the read lock of the shards or loading their views.
+func BenchmarkGetParallel(b *testing.B) {
+	benchmarks := []struct {
+		name      string
+		configure []func(cfg *config)
+	}{
+		{"locked", nil},
+		{"lock-free", []func(cfg *config){enableLockFreeReads}},
+	}
+
+	for _, benchmark := range benchmarks {
+		b.Run(benchmark.name, func(b *testing.B) {
+			db := newTestServer(benchmark.configure...).databases[0]
+
+			keys := make([]string, 1000)
+			for i := range keys {
+				keys[i] = "key:" + strconv.Itoa(i)
+				db.Set(keys[i], "value")
+			}
+
+			b.SetParallelism(16)
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					db.Get(keys[i%len(keys)])
+				}
+			})
+		})
 	}
 }
@@ -0,0 +1,845 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A config represents the server configuration.
+// bind holds the addresses to listen on, separated by spaces.
+// configFile is the path of the file the configuration was loaded from, if any.
+// save holds the snapshotting rules as "seconds changes" pairs separated by spaces.
+// normalOutputBufferLimit and pubsubOutputBufferLimit bound the pending output of the clients of each class.
+// The mutex guards the parameters that can be changed at runtime with CONFIG SET.
+type config struct {
+	bind                    string
+	port                    int
+	databases               int
+	tlsPort                 int
+	tlsCertFile             string
+	tlsKeyFile              string
+	unixSocket              string
+	logLevel                logLevel
+	logFile                 string
+	fileName                string
+	configFile              string
+	dir                     string
+	requirePass             string
+	maxMemory               int64
+	appendOnly              bool
+	readOnly                bool
+	save                    string
+	slowlogLogSlowerThan    int
+	slowlogMaxLen           int
+	latencyMonitorThreshold int
+	protoMaxBulkLen         int64
+	normalOutputBufferLimit outputBufferLimit
+	pubsubOutputBufferLimit outputBufferLimit
+	lockFreeReads           bool
+	mutex                   sync.RWMutex
+}
+
+// A savePoint triggers a save of a database once it was modified at least changes times,
+// and seconds went by since it was last saved.
+type savePoint struct {
+	seconds int
+	changes int64
+}
+
+// An outputBufferLimit bounds the output pending for a client, in bytes.
+// The client is disconnected as soon as its pending output goes over the hard limit,
+// or once it stayed over the soft limit for longer than softSeconds. A limit of 0 disables it.
+type outputBufferLimit struct {
+	hard        int64
+	soft        int64
+	softSeconds int
+}
+
+// defaultPubsubOutputBufferLimit is the pubsub limit of Redis: subscribers are expected to keep up.
+// Normal clients are not limited by default.
+var defaultPubsubOutputBufferLimit = outputBufferLimit{hard: 32 << 20, soft: 8 << 20, softSeconds: 60}
+
+// minMaxBulkLength is the lowest proto-max-bulk-len accepted, as in Redis.
+const minMaxBulkLength = 1 << 20
+
+// exceeded reports whether the pending output goes over the limit at the given time.
+// softSince is the time the pending output went over the soft limit, and is updated accordingly.
+func (limit outputBufferLimit) exceeded(pending int64, softSince *time.Time, now time.Time) bool {
+	if limit.hard > 0 && pending > limit.hard {
+		return true
+	}
+
+	if limit.soft == 0 || pending <= limit.soft {
+		*softSince = time.Time{}
+		return false
+	}
+
+	if softSince.IsZero() {
+		*softSince = now
+		return false
+	}
+
+	return now.Sub(*softSince) > time.Duration(limit.softSeconds)*time.Second
+}
+
+// A configParameter is a configuration parameter,
+// which can be set from the config file and read with CONFIG GET.
+// Only mutable parameters can be changed with CONFIG SET.
+// A variadic parameter takes every argument of its directive as a single value separated by spaces.
+type configParameter struct {
+	name     string
+	mutable  bool
+	variadic bool
+	get      func(cfg *config) string
+	set      func(cfg *config, value string) error
+}
+
+// intParameter returns a configuration parameter backed by an integer field.
+func intParameter(name string, mutable bool, field func(cfg *config) *int) configParameter {
+	return configParameter{
+		name:    name,
+		mutable: mutable,
+		get: func(cfg *config) string {
+			return strconv.Itoa(*field(cfg))
+		},
+		set: func(cfg *config, value string) error {
+			number, err := strconv.Atoi(value)
+			if err != nil {
+				return errors.New("argument couldn't be parsed into an integer")
+			}
+
+			*field(cfg) = number
+
+			return nil
+		},
+	}
+}
+
+// nonNegativeIntParameter returns a configuration parameter backed by an integer field that cannot be negative.
+func nonNegativeIntParameter(name string, mutable bool, field func(cfg *config) *int) configParameter {
+	parameter := intParameter(name, mutable, field)
+	parameter.set = func(cfg *config, value string) error {
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New("argument couldn't be parsed into an integer")
+		}
+
+		if number < 0 {
+			return errors.New("argument must not be negative")
+		}
+
+		*field(cfg) = number
+
+		return nil
+	}
+
+	return parameter
+}
+
+// stringParameter returns a configuration parameter backed by a string field.
+func stringParameter(name string, mutable bool, field func(cfg *config) *string) configParameter {
+	return configParameter{
+		name:    name,
+		mutable: mutable,
+		get: func(cfg *config) string {
+			return *field(cfg)
+		},
+		set: func(cfg *config, value string) error {
+			*field(cfg) = value
+
+			return nil
+		},
+	}
+}
+
+// boolParameter returns a configuration parameter backed by a boolean field, written as yes or no.
+func boolParameter(name string, mutable bool, field func(cfg *config) *bool) configParameter {
+	return configParameter{
+		name:    name,
+		mutable: mutable,
+		get: func(cfg *config) string {
+			if *field(cfg) {
+				return "yes"
+			}
+
+			return "no"
+		},
+		set: func(cfg *config, value string) error {
+			switch strings.ToLower(value) {
+			case "yes":
+				*field(cfg) = true
+			case "no":
+				*field(cfg) = false
+			default:
+				return errors.New("argument must be 'yes' or 'no'")
+			}
+
+			return nil
+		},
+	}
+}
+
+// memoryParameter returns a configuration parameter backed by a number of bytes,
+// which can be given with a unit (e.g. 100mb), and must be at least minimum.
+func memoryParameter(name string, mutable bool, minimum int64, field func(cfg *config) *int64) configParameter {
+	return configParameter{
+		name:    name,
+		mutable: mutable,
+		get: func(cfg *config) string {
+			return strconv.FormatInt(*field(cfg), 10)
+		},
+		set: func(cfg *config, value string) error {
+			bytes, err := parseMemory(value)
+			if err != nil {
+				return err
+			}
+
+			if bytes < minimum {
+				return fmt.Errorf("argument must be at least %d", minimum)
+			}
+
+			*field(cfg) = bytes
+
+			return nil
+		},
+	}
+}
+
+// listParameter returns a configuration parameter backed by a list of words separated by spaces.
+// The list must not be empty.
+func listParameter(name string, mutable bool, field func(cfg *config) *string) configParameter {
+	return configParameter{
+		name:     name,
+		mutable:  mutable,
+		variadic: true,
+		get: func(cfg *config) string {
+			return *field(cfg)
+		},
+		set: func(cfg *config, value string) error {
+			words := strings.Fields(value)
+			if len(words) == 0 {
+				return errors.New("argument must not be empty")
+			}
+
+			*field(cfg) = strings.Join(words, " ")
+
+			return nil
+		},
+	}
+}
+
+// saveParameter returns the save configuration parameter,
+// whose value is a list of "seconds changes" pairs, or an empty string to disable snapshotting.
+func saveParameter() configParameter {
+	return configParameter{
+		name:     "save",
+		mutable:  true,
+		variadic: true,
+		get: func(cfg *config) string {
+			return cfg.save
+		},
+		set: func(cfg *config, value string) error {
+			fields := strings.Fields(value)
+			if len(fields)%2 != 0 {
+				return errors.New("Invalid save parameters")
+			}
+
+			for _, field := range fields {
+				if number, err := strconv.Atoi(field); err != nil || number < 0 {
+					return errors.New("Invalid save parameters")
+				}
+			}
+
+			cfg.save = strings.Join(fields, " ")
+
+			return nil
+		},
+	}
+}
+
+// outputBufferLimitParameter returns the client-output-buffer-limit configuration parameter,
+// whose value is a list of "class hard soft seconds" groups, the class being normal or pubsub.
+// Setting it only changes the limits of the given classes.
+func outputBufferLimitParameter() configParameter {
+	return configParameter{
+		name:     "client-output-buffer-limit",
+		mutable:  true,
+		variadic: true,
+		get: func(cfg *config) string {
+			return "normal " + cfg.normalOutputBufferLimit.String() + " pubsub " + cfg.pubsubOutputBufferLimit.String()
+		},
+		set: func(cfg *config, value string) error {
+			fields := strings.Fields(value)
+			if len(fields) == 0 || len(fields)%4 != 0 {
+				return errors.New("Wrong number of arguments in buffer limit configuration.")
+			}
+
+			normal, pubsub := cfg.normalOutputBufferLimit, cfg.pubsubOutputBufferLimit
+
+			for i := 0; i < len(fields); i += 4 {
+				var limit *outputBufferLimit
+
+				switch strings.ToLower(fields[i]) {
+				case "normal":
+					limit = &normal
+				case "pubsub":
+					limit = &pubsub
+				default:
+					return errors.New("Invalid client class specified in buffer limit configuration.")
+				}
+
+				hard, hardErr := parseMemory(fields[i+1])
+				soft, softErr := parseMemory(fields[i+2])
+				seconds, secondsErr := strconv.Atoi(fields[i+3])
+
+				if hardErr != nil || softErr != nil || secondsErr != nil || seconds < 0 {
+					return errors.New("Error in hard, soft or soft_seconds setting in buffer limit configuration.")
+				}
+
+				*limit = outputBufferLimit{hard: hard, soft: soft, softSeconds: seconds}
+			}
+
+			cfg.normalOutputBufferLimit, cfg.pubsubOutputBufferLimit = normal, pubsub
+
+			return nil
+		},
+	}
+}
+
+// String returns the limit as "hard soft seconds", as written in the config file.
+func (limit outputBufferLimit) String() string {
+	return strconv.FormatInt(limit.hard, 10) + " " + strconv.FormatInt(limit.soft, 10) + " " + strconv.Itoa(limit.softSeconds)
+}
+
+// logLevelParameter returns the loglevel configuration parameter.
+func logLevelParameter() configParameter {
+	return configParameter{
+		name:    "loglevel",
+		mutable: true,
+		get: func(cfg *config) string {
+			return cfg.logLevel.String()
+		},
+		set: func(cfg *config, value string) error {
+			level, err := parseLogLevel(value)
+			if err != nil {
+				return err
+			}
+
+			cfg.logLevel = level
+
+			return nil
+		},
+	}
+}
+
+// parseMemory parses a number of bytes with an optional unit:
+// k, m and g are powers of 1000, while kb, mb and gb are powers of 1024.
+func parseMemory(value string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"kb", 1 << 10},
+		{"mb", 1 << 20},
+		{"gb", 1 << 30},
+		{"k", 1000},
+		{"m", 1000 * 1000},
+		{"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+
+	number, multiplier := strings.ToLower(value), int64(1)
+
+	for _, unit := range units {
+		if trimmed, found := strings.CutSuffix(number, unit.suffix); found {
+			number, multiplier = trimmed, unit.multiplier
+			break
+		}
+	}
+
+	bytes, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || bytes < 0 || bytes > math.MaxInt64/multiplier {
+		return 0, errors.New("argument must be a memory value")
+	}
+
+	return bytes * multiplier, nil
+}
+
+// getConfigParameters returns the configuration parameters in the order they are written by CONFIG REWRITE.
+func getConfigParameters() []configParameter {
+	return []configParameter{
+		listParameter("bind", false, func(cfg *config) *string { return &cfg.bind }),
+		intParameter("port", false, func(cfg *config) *int { return &cfg.port }),
+		intParameter("databases", false, func(cfg *config) *int { return &cfg.databases }),
+		intParameter("tls-port", false, func(cfg *config) *int { return &cfg.tlsPort }),
+		stringParameter("tls-cert-file", false, func(cfg *config) *string { return &cfg.tlsCertFile }),
+		stringParameter("tls-key-file", false, func(cfg *config) *string { return &cfg.tlsKeyFile }),
+		stringParameter("unixsocket", false, func(cfg *config) *string { return &cfg.unixSocket }),
+		logLevelParameter(),
+		stringParameter("logfile", false, func(cfg *config) *string { return &cfg.logFile }),
+		stringParameter("dir", true, func(cfg *config) *string { return &cfg.dir }),
+		stringParameter("requirepass", true, func(cfg *config) *string { return &cfg.requirePass }),
+		memoryParameter("maxmemory", true, 0, func(cfg *config) *int64 { return &cfg.maxMemory }),
+		boolParameter("appendonly", true, func(cfg *config) *bool { return &cfg.appendOnly }),
+		boolParameter("read-only", true, func(cfg *config) *bool { return &cfg.readOnly }),
+		saveParameter(),
+		intParameter("slowlog-log-slower-than", true, func(cfg *config) *int { return &cfg.slowlogLogSlowerThan }),
+		nonNegativeIntParameter("slowlog-max-len", true, func(cfg *config) *int { return &cfg.slowlogMaxLen }),
+		intParameter("latency-monitor-threshold", true, func(cfg *config) *int { return &cfg.latencyMonitorThreshold }),
+		memoryParameter("proto-max-bulk-len", true, minMaxBulkLength, func(cfg *config) *int64 { return &cfg.protoMaxBulkLen }),
+		outputBufferLimitParameter(),
+		boolParameter("lock-free-reads", false, func(cfg *config) *bool { return &cfg.lockFreeReads }),
+	}
+}
+
+// lookupConfigParameter returns the configuration parameter with the given name.
+func lookupConfigParameter(name string) (configParameter, bool) {
+	for _, parameter := range getConfigParameters() {
+		if parameter.name == strings.ToLower(name) {
+			return parameter, true
+		}
+	}
+
+	return configParameter{}, false
+}
+
+// format returns the directive line of the parameter, as written in the config file.
+// Values that are empty or contain spaces or quotes are quoted.
+func (parameter configParameter) format(cfg *config) string {
+	value := parameter.get(cfg)
+	if value == "" || (!parameter.variadic && strings.ContainsAny(value, " \t\"'\\")) {
+		value = strconv.Quote(value)
+	}
+
+	return parameter.name + " " + value
+}
+
+// isConfigComment reports whether the config file line is a comment.
+func isConfigComment(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "#")
+}
+
+// splitConfigLine splits a config file line into its arguments.
+// Arguments are separated by spaces, and can be enclosed in double quotes, which support escape sequences,
+// or in single quotes, which are taken literally.
+func splitConfigLine(line string) ([]string, error) {
+	var args []string
+
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' || line[i] == '\r' {
+			i++
+			continue
+		}
+
+		var arg string
+
+		switch line[i] {
+		case '"':
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+
+			if end >= len(line) {
+				return nil, errors.New("unbalanced quotes in configuration line")
+			}
+
+			unquoted, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, errors.New("invalid escape sequence in configuration line")
+			}
+
+			arg, i = unquoted, end+1
+		case '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unbalanced quotes in configuration line")
+			}
+
+			arg, i = line[i+1:i+1+end], i+end+2
+		default:
+			end := strings.IndexAny(line[i:], " \t\r")
+			if end < 0 {
+				end = len(line) - i
+			}
+
+			arg, i = line[i:i+end], i+end
+		}
+
+		if i < len(line) && line[i] != ' ' && line[i] != '\t' && line[i] != '\r' {
+			return nil, errors.New("closing quote must be followed by a space")
+		}
+
+		args = append(args, arg)
+	}
+
+	return args, nil
+}
+
+// slowlogLimits returns the slowlog threshold in microseconds and its maximum length.
+func (cfg *config) slowlogLimits() (int, int) {
+	cfg.mutex.RLock()
+	defer cfg.mutex.RUnlock()
+
+	return cfg.slowlogLogSlowerThan, cfg.slowlogMaxLen
+}
+
+// bindAddresses returns the network addresses to listen on for the port, one per bound address.
+func (cfg *config) bindAddresses(port int) []string {
+	var addresses []string
+
+	for _, host := range strings.Fields(cfg.bind) {
+		addresses = append(addresses, net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+
+	return addresses
+}
+
+// currentLogLevel returns the minimum level of the messages written to the log.
+func (cfg *config) currentLogLevel() logLevel {
+	cfg.mutex.RLock()
+	defer cfg.mutex.RUnlock()
+
+	return cfg.logLevel
+}
+
+// latencyThreshold returns the latency monitor threshold in milliseconds.
+func (cfg *config) latencyThreshold() int {
+	cfg.mutex.RLock()
+	defer cfg.mutex.RUnlock()
+
+	return cfg.latencyMonitorThreshold
+}
+
+// password returns the password the clients must give with AUTH, or an empty string if none is required.
+func (cfg *config) password() string {
+	cfg.mutex.RLock()
+	defer cfg.mutex.RUnlock()
+
+	return cfg.requirePass
+}
+
+// dumpDir returns the directory the databases are saved to and loaded from, the working directory if empty.
+func (cfg *config) dumpDir() string {
+	cfg.mutex.RLock()
+	defer cfg.mutex.RUnlock()
+
+	return cfg.dir
+}
+
+// isReadOnly reports whether the write commands are refused.
+func (cfg *config) isReadOnly() bool {
+	cfg.mutex.RLock()
+	defer cfg.mutex.RUnlock()
+
+	return cfg.readOnly
+}
+
+// savePoints returns the save points of the save parameter, none meaning that snapshotting is disabled.
+func (cfg *config) savePoints() []savePoint {
+	cfg.mutex.RLock()
+	defer cfg.mutex.RUnlock()
+
+	fields := strings.Fields(cfg.save)
+	points := make([]savePoint, 0, len(fields)/2)
+
+	for i := 0; i+1 < len(fields); i += 2 {
+		seconds, _ := strconv.Atoi(fields[i])
+		changes, _ := strconv.ParseInt(fields[i+1], 10, 64)
+		points = append(points, savePoint{seconds: seconds, changes: changes})
+	}
+
+	return points
+}
+
+// maxMemoryLimit returns the maxmemory setting in bytes, zero meaning no limit.
+func (cfg *config) maxMemoryLimit() int64 {
+	cfg.mutex.RLock()
+	defer cfg.mutex.RUnlock()
+
+	return cfg.maxMemory
+}
+
+// maxStringLength returns the length in bytes of the longest value a write may create.
+func (cfg *config) maxStringLength() int64 {
+	cfg.mutex.RLock()
+	defer cfg.mutex.RUnlock()
+
+	return cfg.protoMaxBulkLen
+}
+
+// outputBufferLimit returns the output buffer limit of the clients of the given class, normal or pubsub.
+func (cfg *config) outputBufferLimit(class string) outputBufferLimit {
+	cfg.mutex.RLock()
+	defer cfg.mutex.RUnlock()
+
+	if class == "pubsub" {
+		return cfg.pubsubOutputBufferLimit
+	}
+
+	return cfg.normalOutputBufferLimit
+}
+
+// decoderLimits returns the limits applied when decoding the requests of the clients.
+func (cfg *config) decoderLimits() DecoderLimits {
+	cfg.mutex.RLock()
+	defer cfg.mutex.RUnlock()
+
+	return DecoderLimits{
+		MaxBulkLength:      cfg.protoMaxBulkLen,
+		MaxMultibulkLength: defaultMaxMultibulkLength,
+		MaxLineLength:      defaultMaxLineLength,
+	}
+}
+
+// LoadFile loads the configuration from a redis.conf-style file:
+// one "directive value" per line, blank lines and lines starting with # being ignored.
+func (cfg *config) LoadFile(path string) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	cfg.mutex.Lock()
+	defer cfg.mutex.Unlock()
+
+	for i, line := range strings.Split(string(contents), "\n") {
+		if isConfigComment(line) {
+			continue
+		}
+
+		args, err := splitConfigLine(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		parameter, ok := lookupConfigParameter(args[0])
+		if !ok {
+			return fmt.Errorf("%s:%d: bad directive or wrong number of arguments", path, i+1)
+		}
+
+		if len(args) != 2 && !parameter.variadic {
+			return fmt.Errorf("%s:%d: wrong number of arguments", path, i+1)
+		}
+
+		if err := parameter.set(cfg, strings.Join(args[1:], " ")); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+	}
+
+	cfg.configFile = path
+
+	return nil
+}
+
+// Rewrite writes the current configuration back to the file it was loaded from.
+// The lines of known directives are updated in place, comments and blank lines are kept,
+// and the parameters missing from the file are appended.
+func (cfg *config) Rewrite() error {
+	cfg.mutex.RLock()
+	defer cfg.mutex.RUnlock()
+
+	if cfg.configFile == "" {
+		return errors.New("The server is running without a config file")
+	}
+
+	contents, err := os.ReadFile(cfg.configFile)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(strings.TrimRight(string(contents), "\n"), "\n")
+	written := make(map[string]bool)
+
+	for i, line := range lines {
+		if isConfigComment(line) {
+			continue
+		}
+
+		args, err := splitConfigLine(line)
+		if err != nil || len(args) == 0 {
+			continue
+		}
+
+		if parameter, ok := lookupConfigParameter(args[0]); ok {
+			lines[i] = parameter.format(cfg)
+			written[parameter.name] = true
+		}
+	}
+
+	for _, parameter := range getConfigParameters() {
+		if !written[parameter.name] {
+			lines = append(lines, parameter.format(cfg))
+		}
+	}
+
+	return os.WriteFile(cfg.configFile, []byte(strings.Join(lines, "\n")+"\n"), 0o644)
+}
+
+// A logLevelFlag is the command-line flag setting the loglevel of the config.
+type logLevelFlag struct {
+	cfg *config
+}
+
+// String returns the name of the log level.
+func (f logLevelFlag) String() string {
+	if f.cfg == nil {
+		return levelInfo.String()
+	}
+
+	return f.cfg.logLevel.String()
+}
+
+// Set sets the log level with the given name.
+func (f logLevelFlag) Set(value string) error {
+	level, err := parseLogLevel(value)
+	if err != nil {
+		return err
+	}
+
+	f.cfg.logLevel = level
+
+	return nil
+}
+
+// loadConfig builds the configuration from the command-line arguments.
+// If a config file is given with -configfile, its directives are applied on top of the flag defaults,
+// and the flags explicitly set on the command line take precedence over them.
+func loadConfig(arguments []string) (*config, error) {
+	cfg := &config{logLevel: levelInfo, protoMaxBulkLen: defaultMaxBulkLength, pubsubOutputBufferLimit: defaultPubsubOutputBufferLimit}
+
+	var configFile string
+
+	flags := flag.NewFlagSet("redis-whistle", flag.ContinueOnError)
+	flags.StringVar(&cfg.bind, "bind", "127.0.0.1", "Addresses to listen on, separated by spaces")
+	flags.IntVar(&cfg.port, "port", 6379, "REDIS server port")
+	flags.IntVar(&cfg.databases, "databases", 16, "Number of databases, selected with SELECT from 0 to databases-1")
+	flags.IntVar(&cfg.tlsPort, "tls-port", 0, "REDIS server TLS port, 0 disables TLS")
+	flags.StringVar(&cfg.tlsCertFile, "tls-cert-file", "", "Certificate file of the TLS port")
+	flags.StringVar(&cfg.tlsKeyFile, "tls-key-file", "", "Private key file of the TLS port")
+	flags.StringVar(&cfg.unixSocket, "unixsocket", "", "Also listen on a Unix socket at this path")
+	flags.Var(logLevelFlag{cfg}, "loglevel", "Minimum level of the logged messages: debug, info, warning or error")
+	flags.StringVar(&cfg.logFile, "logfile", "", "Write the log to this file instead of the standard output")
+	flags.StringVar(&cfg.fileName, "load", "", "Load DB from a file")
+	flags.StringVar(&configFile, "configfile", "", "Load the configuration from a redis.conf-style file")
+	flags.IntVar(&cfg.slowlogLogSlowerThan, "slowlog-log-slower-than", 10000, "Log commands slower than this many microseconds")
+	flags.IntVar(&cfg.slowlogMaxLen, "slowlog-max-len", 128, "Maximum number of commands kept in the slowlog")
+	flags.BoolVar(&cfg.readOnly, "read-only", false, "Refuse the write commands, as a read only replica does")
+
+	if err := flags.Parse(arguments); err != nil {
+		return nil, err
+	}
+
+	if configFile != "" {
+		explicit := make(map[string]string)
+		flags.Visit(func(f *flag.Flag) {
+			explicit[f.Name] = f.Value.String()
+		})
+
+		if err := cfg.LoadFile(configFile); err != nil {
+			return nil, err
+		}
+
+		for name, value := range explicit {
+			if err := flags.Set(name, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if cfg.databases < 1 {
+		return nil, errors.New("databases must be at least 1")
+	}
+
+	if cfg.slowlogMaxLen < 0 {
+		return nil, errors.New("slowlog-max-len must not be negative")
+	}
+
+	return cfg, nil
+}
+
+func init() {
+	registerCommand("CONFIG", CommandSpec{handler: configCommand, arity: -2, flags: []string{"admin", "loading"}})
+}
+
+// configCommand reads or changes the configuration at runtime.
+// GET returns the parameters matching the pattern along with their values.
+// SET changes the value of a mutable parameter.
+// REWRITE writes the current configuration back to the config file.
+func configCommand(ctx *commandContext, args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		if len(args) != 2 {
+			return returnWrongNumberOfArgumentsError("CONFIG GET")
+		}
+
+		ctx.server.config.mutex.RLock()
+		defer ctx.server.config.mutex.RUnlock()
+
+		// Empty values, such as an unset requirepass, are replied as empty strings, not nulls
+		values := []*string{}
+
+		for _, parameter := range getConfigParameters() {
+			if stringMatch(args[1], parameter.name, true) {
+				name, value := parameter.name, parameter.get(ctx.server.config)
+				values = append(values, &name, &value)
+			}
+		}
+
+		return returnNullableArray(values)
+	case "SET":
+		if len(args) != 3 {
+			return returnWrongNumberOfArgumentsError("CONFIG SET")
+		}
+
+		parameter, ok := lookupConfigParameter(args[1])
+		if !ok {
+			return returnError("Unknown option or number of arguments for CONFIG SET - '" + args[1] + "'")
+		}
+
+		if !parameter.mutable {
+			return returnError("CONFIG SET failed (possibly related to argument '" + parameter.name + "') - can't set immutable config")
+		}
+
+		ctx.server.config.mutex.Lock()
+		defer ctx.server.config.mutex.Unlock()
+
+		if err := parameter.set(ctx.server.config, args[2]); err != nil {
+			return returnError("CONFIG SET failed (possibly related to argument '" + parameter.name + "') - " + err.Error())
+		}
+
+		return returnSimpleString("OK")
+	case "REWRITE":
+		if err := ctx.server.config.Rewrite(); err != nil {
+			return returnError(err.Error())
+		}
+
+		return returnSimpleString("OK")
+	case "HELP":
+		return returnHelp("CONFIG",
+			"GET <pattern>",
+			"    Return parameters matching the glob-like <pattern> and their values.",
+			"SET <directive> <value>",
+			"    Set the configuration <directive> to <value>.",
+			"REWRITE",
+			"    Rewrite the configuration file.",
+		)
+	default:
+		return returnUnknownSubcommandError("CONFIG", args[0])
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestConfigCommand(t *testing.T) {
+	threshold, maxLen := testServer.config.slowlogLimits()
+	defer func() {
+		testServer.config.slowlogLogSlowerThan = threshold
+		testServer.config.slowlogMaxLen = maxLen
+	}()
+
+	// Test with a mutable parameter
+	result := configCommand(testContext, []string{"SET", "slowlog-max-len", "64"})
+	if result != okReply {
+		t.Errorf("configCommand([]string{\"SET\", \"slowlog-max-len\", \"64\"}) = %s; want +OK\\r\\n", result)
+	}
+
+	result = configCommand(testContext, []string{"GET", "slowlog-max-len"})
+	if result != returnArray([]string{"slowlog-max-len", "64"}) {
+		t.Errorf("configCommand([]string{\"GET\", \"slowlog-max-len\"}) = %s; want slowlog-max-len 64", result)
+	}
+
+	// Test with a pattern
+	result = configCommand(testContext, []string{"GET", "slowlog-*"})
+	if !strings.HasPrefix(result, "*4\r\n") {
+		t.Errorf("configCommand([]string{\"GET\", \"slowlog-*\"}) = %s; want both slowlog parameters", result)
+	}
+
+	// Test with an immutable parameter
+	result = configCommand(testContext, []string{"SET", "port", "6380"})
+	if !strings.HasPrefix(result, "-ERR CONFIG SET failed") {
+		t.Errorf("configCommand([]string{\"SET\", \"port\", \"6380\"}) = %s; want an immutable config error", result)
+	}
+
+	// Test with an invalid value
+	result = configCommand(testContext, []string{"SET", "slowlog-max-len", "many"})
+	if !strings.HasPrefix(result, "-ERR CONFIG SET failed") {
+		t.Errorf("configCommand([]string{\"SET\", \"slowlog-max-len\", \"many\"}) = %s; want an integer error", result)
+	}
+
+	result = configCommand(testContext, []string{"SET", "slowlog-max-len", "-1"})
+	if !strings.HasPrefix(result, "-ERR CONFIG SET failed") {
+		t.Errorf("configCommand([]string{\"SET\", \"slowlog-max-len\", \"-1\"}) = %s; want a negative value error", result)
+	}
+
+	// Test with a bulk length below the minimum of 1mb
+	result = configCommand(testContext, []string{"SET", "proto-max-bulk-len", "1023kb"})
+	if !strings.HasPrefix(result, "-ERR CONFIG SET failed") {
+		t.Errorf("configCommand([]string{\"SET\", \"proto-max-bulk-len\", \"1023kb\"}) = %s; want a minimum error", result)
+	}
+
+	result = configCommand(testContext, []string{"SET", "proto-max-bulk-len", "1mb"})
+	if result != okReply {
+		t.Errorf("configCommand([]string{\"SET\", \"proto-max-bulk-len\", \"1mb\"}) = %s; want +OK\\r\\n", result)
+	}
+
+	configCommand(testContext, []string{"SET", "proto-max-bulk-len", strconv.Itoa(defaultMaxBulkLength)})
+
+	// Test with an unknown parameter
+	result = configCommand(testContext, []string{"SET", "foo", "bar"})
+	if result != "-ERR Unknown option or number of arguments for CONFIG SET - 'foo'\r\n" {
+		t.Errorf("configCommand([]string{\"SET\", \"foo\", \"bar\"}) = %s; want an unknown option error", result)
+	}
+}
+
+func TestConfigGetEmptyValue(t *testing.T) {
+	ctx := &commandContext{Context: context.Background(), server: newTestServer()}
+
+	result := configCommand(ctx, []string{"GET", "requirepass"})
+	if result != "*2\r\n$11\r\nrequirepass\r\n$0\r\n\r\n" {
+		t.Errorf("configCommand([]string{\"GET\", \"requirepass\"}) = %q; want an empty string, not null", result)
+	}
+}
+
+func TestConfigRewrite(t *testing.T) {
+	path := writeConfigFile(t, "# RedisWhistle\nport 7000\n\nslowlog-max-len 10\n")
+
+	server := newTestServer(func(cfg *config) {
+		cfg.bind = "127.0.0.1"
+		cfg.slowlogLogSlowerThan = 0
+		cfg.protoMaxBulkLen = defaultMaxBulkLength
+		cfg.pubsubOutputBufferLimit = outputBufferLimit{}
+
+		if err := cfg.LoadFile(path); err != nil {
+			t.Fatalf("LoadFile(%s) returned %s", path, err)
+		}
+	})
+	ctx := &commandContext{Context: context.Background(), server: server}
+
+	configCommand(ctx, []string{"SET", "slowlog-max-len", "20"})
+	configCommand(ctx, []string{"SET", "slowlog-log-slower-than", "500"})
+
+	result := configCommand(ctx, []string{"REWRITE"})
+	if result != okReply {
+		t.Fatalf("configCommand([]string{\"REWRITE\"}) = %s; want +OK\\r\\n", result)
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "# RedisWhistle\nport 7000\n\nslowlog-max-len 20\n" +
+		"bind 127.0.0.1\ndatabases 16\n" +
+		"tls-port 0\ntls-cert-file \"\"\ntls-key-file \"\"\nunixsocket \"\"\nloglevel info\nlogfile \"\"\n" +
+		"dir \"\"\nrequirepass \"\"\nmaxmemory 0\nappendonly no\nread-only no\nsave \"\"\nslowlog-log-slower-than 500\nlatency-monitor-threshold 0\n" +
+		"proto-max-bulk-len 536870912\n" +
+		"client-output-buffer-limit normal 0 0 0 pubsub 0 0 0\n" +
+		"lock-free-reads no\n"
+	if string(contents) != want {
+		t.Errorf("rewritten config = %q; want %q", contents, want)
+	}
+
+	// Test without a config file
+	result = configCommand(testContext, []string{"REWRITE"})
+	if result != "-ERR The server is running without a config file\r\n" {
+		t.Errorf("configCommand([]string{\"REWRITE\"}) = %s; want -ERR The server is running without a config file\\r\\n", result)
+	}
+}
+
+// writeConfigFile writes the contents to a config file in a temporary directory and returns its path.
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "testServer.conf")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := writeConfigFile(t, `# Sample configuration, don't mind the quote
+port 7000
+dir "/var/lib/redis whistle"
+requirepass 'pa ss"word'
+maxmemory 100mb
+
+appendonly yes
+save 900 1 300 10
+bind  127.0.0.1   ::1
+`)
+
+	cfg, err := loadConfig([]string{"-configfile", path})
+	if err != nil {
+		t.Fatalf("loadConfig returned %s", err)
+	}
+
+	if cfg.port != 7000 {
+		t.Errorf("port = %d; want 7000", cfg.port)
+	}
+	if cfg.dir != "/var/lib/redis whistle" {
+		t.Errorf("dir = %s; want /var/lib/redis whistle", cfg.dir)
+	}
+	if cfg.requirePass != `pa ss"word` {
+		t.Errorf("requirepass = %s; want pa ss\"word", cfg.requirePass)
+	}
+	if cfg.maxMemory != 100*1024*1024 {
+		t.Errorf("maxmemory = %d; want %d", cfg.maxMemory, 100*1024*1024)
+	}
+	if !cfg.appendOnly {
+		t.Errorf("appendonly = false; want true")
+	}
+	if cfg.save != "900 1 300 10" {
+		t.Errorf("save = %s; want 900 1 300 10", cfg.save)
+	}
+	if cfg.slowlogMaxLen != 128 {
+		t.Errorf("slowlog-max-len = %d; want the default 128", cfg.slowlogMaxLen)
+	}
+	if cfg.bind != "127.0.0.1 ::1" {
+		t.Errorf("bind = %s; want 127.0.0.1 ::1", cfg.bind)
+	}
+
+	// Test with invalid files
+	for _, contents := range []string{"port seven", "foo bar", "port 1 2", `dir "unbalanced`, "appendonly maybe", "save 900", "bind \"\"", "slowlog-max-len -1", "maxmemory 9999999999gb", "proto-max-bulk-len 1000"} {
+		if _, err := loadConfig([]string{"-configfile", writeConfigFile(t, contents)}); err == nil {
+			t.Errorf("loadConfig with %q returned no error", contents)
+		}
+	}
+}
+
+func TestLoadConfigFlagPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "port 7000\nslowlog-max-len 10\n")
+
+	cfg, err := loadConfig([]string{"-port", "8000", "-configfile", path})
+	if err != nil {
+		t.Fatalf("loadConfig returned %s", err)
+	}
+
+	if cfg.port != 8000 {
+		t.Errorf("port = %d; want the flag value 8000", cfg.port)
+	}
+	if cfg.slowlogMaxLen != 10 {
+		t.Errorf("slowlog-max-len = %d; want the file value 10", cfg.slowlogMaxLen)
+	}
+}
+
+func TestLoadConfigNegativeSlowlogMaxLen(t *testing.T) {
+	if _, err := loadConfig([]string{"-slowlog-max-len", "-1"}); err == nil {
+		t.Errorf("loadConfig with -slowlog-max-len -1 returned no error")
+	}
+}
+
+func TestBindAddresses(t *testing.T) {
+	cfg := &config{bind: "127.0.0.1 ::1"}
+
+	addresses := cfg.bindAddresses(6379)
+	if strings.Join(addresses, " ") != "127.0.0.1:6379 [::1]:6379" {
+		t.Errorf("bindAddresses(6379) = %v; want [127.0.0.1:6379 [::1]:6379]", addresses)
+	}
+}
+
+func TestDatabasesConfig(t *testing.T) {
+	cfg, err := loadConfig([]string{"-databases", "4"})
+	if err != nil {
+		t.Fatalf("loadConfig returned %s", err)
+	}
+
+	server := newTestServer(func(c *config) { c.databases = cfg.databases })
+	ctx := &commandContext{Context: context.Background(), server: server}
+
+	if len(server.databases) != 4 {
+		t.Errorf("len(databases) = %d; want 4", len(server.databases))
+	}
+
+	result := selectCommand(ctx, []string{"3"})
+	if result != okReply {
+		t.Errorf("selectCommand([]string{\"3\"}) = %s; want +OK\\r\\n", result)
+	}
+
+	result = selectCommand(ctx, []string{"4"})
+	if result != "-ERR value is out of range or invalid DB index\r\n" {
+		t.Errorf("selectCommand([]string{\"4\"}) = %s; want -ERR value is out of range or invalid DB index\\r\\n", result)
+	}
+
+	result = moveCommand(ctx, []string{"key", "4"})
+	if result != "-ERR value is out of range or invalid DB index\r\n" {
+		t.Errorf("moveCommand([]string{\"key\", \"4\"}) = %s; want -ERR value is out of range or invalid DB index\\r\\n", result)
+	}
+
+	// Test with no database
+	if _, err := loadConfig([]string{"-databases", "0"}); err == nil {
+		t.Errorf("loadConfig([]string{\"-databases\", \"0\"}) returned no error; want databases must be at least 1")
+	}
+}
@@ -1,35 +1,207 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
+	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// A Database is a Redis database.
-// It contains two maps: StringKeys and ExpireKeys.
-// StringKeys stores the string values.
+// shardCount is the number of shards the keyspace of a database is split into.
+const shardCount = 256
+
+// A shard is a stripe of the keyspace, with its own lock,
+// so that commands on unrelated keys do not contend for the same lock.
+// It contains three maps: StringKeys, StreamKeys and ExpireKeys.
+// StringKeys stores the string values, and StreamKeys the streams.
+// A key is in at most one of them.
 // ExpireKeys stores the expiration times of the keys.
-// It also contains a stopSignal channel and a mutex.
-// The stopSignal channel is used to stop the ExpireChecker.
+// RawKeys marks the string keys modified in place by APPEND or SETRANGE,
+// which Redis keeps raw-encoded whatever their contents, until they are overwritten.
+// When lock-free reads are enabled, view holds a copy of the string keys, republished after every write,
+// which readers load without taking the lock.
+// clock is the clock of the server, which the expire times are checked against.
+type shard struct {
+	StringKeys map[string]string
+	StreamKeys map[string]*stream
+	ExpireKeys map[string]time.Time
+	RawKeys    map[string]bool
+	clock      Clock
+	mutex      sync.RWMutex
+	view       atomic.Pointer[shardView]
+}
+
+// A shardView is a read-only copy of the string keys of a shard and of the expire times,
+// along with the names of the stream keys, so that reads can tell them apart from missing keys.
+// It is replaced as a whole, never modified.
+type shardView struct {
+	strings map[string]string
+	streams map[string]bool
+	expires map[string]time.Time
+}
+
+// publish replaces the view of the shard with a copy of its current content.
+// The caller must hold the write lock of the shard.
+func (s *shard) publish() {
+	view := &shardView{
+		strings: make(map[string]string, len(s.StringKeys)),
+		streams: make(map[string]bool, len(s.StreamKeys)),
+		expires: make(map[string]time.Time, len(s.ExpireKeys)),
+	}
+
+	for key, value := range s.StringKeys {
+		view.strings[key] = value
+	}
+
+	for key := range s.StreamKeys {
+		view.streams[key] = true
+	}
+
+	for key, expire := range s.ExpireKeys {
+		view.expires[key] = expire
+	}
+
+	s.view.Store(view)
+}
+
+// unlock releases the write lock of the shard,
+// after publishing its new view if lock-free reads are enabled.
+func (s *shard) unlock() {
+	if s.view.Load() != nil {
+		s.publish()
+	}
+
+	s.mutex.Unlock()
+}
+
+// A Database is a Redis database.
+// Its keys are spread over shards, selected by a hash of the key.
+// Commands spanning multiple shards lock them in the order of their index to avoid deadlocks.
+// It also contains a stopSignal channel, used to stop the ExpireChecker.
+// The server it belongs to provides the logger, the stats and the active expiry setting.
+// With the lock-free-reads config, reads load a copy-on-write view of each shard instead of taking its read lock,
+// which makes reads cheaper under heavy concurrency and every write copy the whole shard.
+// streamWaiters holds, for each key, the channels of the clients blocked until an entry is added to its stream.
+// changes counts the modifications of the keys, and never decreases, so that it can tell whether the database
+// was written to between two points in time. savedChanges is its value when the database was last saved or loaded,
+// and savedAt the Unix time of that save or load in nanoseconds, or of the creation of the database.
+// saveMutex serializes the saves, so that a SAVE and a save point never write the same file at once.
 type Database struct {
+	server     *RedisServer
 	id         int
+	shards     [shardCount]*shard
+	stopSignal chan bool
+
+	streamWaiters map[string]map[chan struct{}]bool
+	waitersMutex  sync.Mutex
+
+	changes      atomic.Int64
+	savedChanges atomic.Int64
+	savedAt      atomic.Int64
+	saveMutex    sync.Mutex
+}
+
+// A snapshot is the content of a database, as saved on disk.
+// Its fields keep the names the database used to have, so that older dumps can still be loaded.
+type snapshot struct {
 	StringKeys map[string]string
+	StreamKeys map[string]stream
 	ExpireKeys map[string]time.Time
-	stopSignal chan bool
-	mutex      sync.RWMutex
 }
 
-// NewDatabase returns a pointer to a new database.
-func NewDatabase(id int) *Database {
-	return &Database{
-		id:         id,
-		StringKeys: make(map[string]string),
-		ExpireKeys: make(map[string]time.Time),
-		stopSignal: make(chan bool),
+// NewDatabase returns a pointer to a new database of the server.
+func NewDatabase(server *RedisServer, id int) *Database {
+	db := &Database{
+		server:        server,
+		id:            id,
+		stopSignal:    make(chan bool),
+		streamWaiters: make(map[string]map[chan struct{}]bool),
+	}
+
+	db.savedAt.Store(time.Now().UnixNano())
+
+	for i := range db.shards {
+		db.shards[i] = &shard{
+			StringKeys: make(map[string]string),
+			StreamKeys: make(map[string]*stream),
+			ExpireKeys: make(map[string]time.Time),
+			RawKeys:    make(map[string]bool),
+			clock:      server.clock,
+		}
+
+		if server.config.lockFreeReads {
+			db.shards[i].publish()
+		}
+	}
+
+	return db
+}
+
+// shardIndex returns the index of the shard holding the key, using the FNV-1a hash of the key.
+func shardIndex(key string) int {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+
+	return int(hash % shardCount)
+}
+
+// shardOf returns the shard holding the key.
+func (db *Database) shardOf(key string) *shard {
+	return db.shards[shardIndex(key)]
+}
+
+// lockKeys locks the shards holding the keys, for writing if write is true, and returns the function unlocking them.
+// Each shard is locked once, in the order of its index.
+func (db *Database) lockKeys(write bool, keys ...string) func() {
+	var locked [shardCount]bool
+	for _, key := range keys {
+		locked[shardIndex(key)] = true
+	}
+
+	return db.lockShards(write, func(i int) bool { return locked[i] })
+}
+
+// lockAll locks every shard, for writing if write is true, and returns the function unlocking them.
+func (db *Database) lockAll(write bool) func() {
+	return db.lockShards(write, func(int) bool { return true })
+}
+
+// lockShards locks the selected shards in the order of their index,
+// and returns the function unlocking them.
+func (db *Database) lockShards(write bool, selected func(i int) bool) func() {
+	var shards []*shard
+
+	for i, s := range db.shards {
+		if !selected(i) {
+			continue
+		}
+
+		if write {
+			s.mutex.Lock()
+		} else {
+			s.mutex.RLock()
+		}
+
+		shards = append(shards, s)
+	}
+
+	return func() {
+		for _, s := range shards {
+			if write {
+				s.unlock()
+			} else {
+				s.mutex.RUnlock()
+			}
+		}
 	}
 }
 
@@ -46,11 +218,92 @@ func (db *Database) Init(fileName string) {
 
 // Flush deletes all the keys in the database.
 func (db *Database) Flush() {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
+	defer db.lockAll(true)()
+
+	db.clear()
+}
+
+// clear deletes all the keys along with everything kept about them, such as their expire times,
+// and counts a change per deleted key.
+// The view of each shard is republished empty when the locks are released.
+// The caller must hold the write lock of every shard.
+func (db *Database) clear() {
+	for _, s := range db.shards {
+		db.touch(len(s.StringKeys) + len(s.StreamKeys))
+
+		s.StringKeys = make(map[string]string)
+		s.StreamKeys = make(map[string]*stream)
+		s.ExpireKeys = make(map[string]time.Time)
+		s.RawKeys = make(map[string]bool)
+	}
+}
+
+// swapContents swaps the keys of the databases, along with everything kept about them, such as their expire times,
+// and counts a change in each database. The shards stay in place, only their maps are exchanged.
+// It returns the keys of the streams of both databases, whose blocked readers must be woken up to look again.
+// The caller must hold the write lock of every shard of both databases.
+func (db *Database) swapContents(other *Database) []string {
+	var streams []string
+
+	for i, s := range db.shards {
+		o := other.shards[i]
+
+		s.StringKeys, o.StringKeys = o.StringKeys, s.StringKeys
+		s.StreamKeys, o.StreamKeys = o.StreamKeys, s.StreamKeys
+		s.ExpireKeys, o.ExpireKeys = o.ExpireKeys, s.ExpireKeys
+		s.RawKeys, o.RawKeys = o.RawKeys, s.RawKeys
+
+		for key := range s.StreamKeys {
+			streams = append(streams, key)
+		}
+
+		for key := range o.StreamKeys {
+			streams = append(streams, key)
+		}
+	}
+
+	db.touch(1)
+	other.touch(1)
+
+	return streams
+}
+
+// touch counts n modifications of the keys of the database.
+func (db *Database) touch(n int) {
+	if n > 0 {
+		db.changes.Add(int64(n))
+	}
+}
+
+// Changes returns the number of modifications of the keys since the database was created.
+// It only grows, so comparing two values tells whether any write happened in between.
+func (db *Database) Changes() int64 {
+	return db.changes.Load()
+}
+
+// ChangesSinceSave returns the number of modifications of the keys since the database was last saved or loaded.
+func (db *Database) ChangesSinceSave() int64 {
+	return db.changes.Load() - db.savedChanges.Load()
+}
+
+// reachedSavePoint reports whether one of the save points is reached at the given time:
+// the database was modified at least as many times as the save point requires since it was last saved,
+// and at least as many seconds went by.
+func (db *Database) reachedSavePoint(points []savePoint, now time.Time) bool {
+	changes := db.ChangesSinceSave()
+	if changes == 0 {
+		return false
+	}
+
+	elapsed := now.Sub(time.Unix(0, db.savedAt.Load()))
+
+	for _, point := range points {
+		if changes >= point.changes && elapsed >= time.Duration(point.seconds)*time.Second {
+			return true
+		}
+	}
 
-	db.StringKeys = make(map[string]string)
-	db.ExpireKeys = make(map[string]time.Time)
+	return false
 }
 
 // Close stops the ExpireChecker and saves the database.
@@ -58,55 +311,144 @@ func (db *Database) Close() {
 	db.StopExpireChecker()
 }
 
-// Save saves the database to a file.
-// The file name is "database_" + id + "_dump" + ".db".
-func (db *Database) Save() {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
+// takeSnapshot copies the content of the database.
+// Every shard is locked for reading while the maps are copied, so the copy is consistent,
+// but only for as long as the copy takes.
+// Streams are copied by value: their entries are never modified once added, so they can be shared.
+func (db *Database) takeSnapshot() snapshot {
+	defer db.lockAll(false)()
+
+	content := snapshot{
+		StringKeys: make(map[string]string),
+		StreamKeys: make(map[string]stream),
+		ExpireKeys: make(map[string]time.Time),
+	}
+
+	for _, s := range db.shards {
+		for key, value := range s.StringKeys {
+			content.StringKeys[key] = value
+		}
+
+		for key, value := range s.StreamKeys {
+			content.StreamKeys[key] = *value
+		}
+
+		for key, expire := range s.ExpireKeys {
+			content.ExpireKeys[key] = expire
+		}
+	}
+
+	return content
+}
+
+// dumpPath returns the path of the file the database is saved to: "database_" + id + "_dump" + ".db",
+// in the dir of the configuration.
+func (db *Database) dumpPath() string {
+	return filepath.Join(db.server.config.dumpDir(), "database_"+strconv.Itoa(db.id)+"_dump"+".db")
+}
+
+// Save saves the database to its dump file, in the dir of the configuration.
+// The snapshot is encoded without holding any lock, so clients keep being served while it is written.
+// Errors are logged and returned.
+func (db *Database) Save() error {
+	db.saveMutex.Lock()
+	defer db.saveMutex.Unlock()
+
+	now := time.Now()
+	changes := db.changes.Load()
 
-	file, err := os.Create("database_" + strconv.Itoa(db.id) + "_dump" + ".db")
+	file, err := os.Create(db.dumpPath())
 	if err != nil {
-		redis.logger.Println(err)
-		return
+		db.server.logger.Errorf("Error saving database %d: %s", db.id, err)
+		return err
 	}
 	defer file.Close()
 
-	encoder := gob.NewEncoder(file)
-
-	err = encoder.Encode(db)
+	err = db.writeSnapshot(file)
 	if err != nil {
-		redis.logger.Println(err)
+		db.server.logger.Errorf("Error saving database %d: %s", db.id, err)
+		return err
 	}
+
+	db.savedChanges.Store(changes)
+	db.savedAt.Store(now.UnixNano())
+
+	return nil
 }
 
-// Load loads the database from a file.
-// The file name is "database_" + id + "_dump" + ".db".
-func (db *Database) Load(fileName string) {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
+// writeSnapshot takes a snapshot of the database and encodes it to w.
+// The shards are only locked while the snapshot is taken, not while it is written,
+// so that a slow write does not block the clients.
+func (db *Database) writeSnapshot(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(db.takeSnapshot())
+}
+
+// Load loads the database from a file, its dump file if fileName is empty.
+// Errors are logged and returned.
+func (db *Database) Load(fileName string) error {
+	content, err := db.readSnapshot(fileName)
+	if err != nil {
+		return err
+	}
+
+	defer db.lockAll(true)()
+
+	db.restore(content)
+
+	return nil
+}
 
+// readSnapshot decodes the snapshot saved in the file, without touching the database.
+// If fileName is empty, the file is the one the database is saved to.
+// Errors are logged and returned.
+func (db *Database) readSnapshot(fileName string) (snapshot, error) {
 	if fileName == "" {
-		fileName = "database_" + strconv.Itoa(db.id) + "_dump" + ".db"
+		fileName = db.dumpPath()
 	}
 
+	var content snapshot
+
 	file, err := os.Open(fileName)
 	if err != nil {
-		redis.logger.Println(err)
-		return
+		db.server.logger.Warningf("Error loading database %d: %s", db.id, err)
+		return content, err
 	}
 	defer file.Close()
 
-	decoder := gob.NewDecoder(file)
+	if err := gob.NewDecoder(file).Decode(&content); err != nil {
+		db.server.logger.Warningf("Error loading database %d: %s", db.id, err)
+		return snapshot{}, err
+	}
 
-	err = decoder.Decode(db)
-	if err != nil {
-		redis.logger.Println(err)
-		return
+	return content, nil
+}
+
+// restore adds the keys of the snapshot to the database, and marks it as saved.
+// The caller must hold the write lock of every shard.
+func (db *Database) restore(content snapshot) {
+	for key, value := range content.StringKeys {
+		s := db.shardOf(key)
+		s.StringKeys[key] = value
+		delete(s.RawKeys, key)
 	}
+
+	for key, value := range content.StreamKeys {
+		value := value
+		db.shardOf(key).StreamKeys[key] = &value
+	}
+
+	for key, expire := range content.ExpireKeys {
+		db.shardOf(key).ExpireKeys[key] = expire
+	}
+
+	// The keys changed, but they are now the same as on disk
+	db.touch(1)
+	db.savedChanges.Store(db.changes.Load())
+	db.savedAt.Store(time.Now().UnixNano())
 }
 
 // startExpireChecker starts the ExpireChecker.
-// It checks if a key has expired every second.
+// It checks if a key has expired every second, unless active expiry is disabled.
 func (db *Database) startExpireChecker() {
 	db.stopSignal = make(chan bool)
 
@@ -116,7 +458,7 @@ func (db *Database) startExpireChecker() {
 		for {
 			select {
 			case <-ticker.C:
-				db.checkAndRemoveExpiredKeys()
+				db.activeExpireCycle()
 			case <-db.stopSignal:
 				ticker.Stop()
 				return
@@ -125,41 +467,96 @@ func (db *Database) startExpireChecker() {
 	}()
 }
 
-// checkAndRemoveExpiredKeys checks if a key has expired.
-// If a key has expired, it removes the key.
+// activeExpireCycle is what the ExpireChecker runs every second:
+// it removes the expired keys, unless active expire was disabled with DEBUG SET-ACTIVE-EXPIRE.
+func (db *Database) activeExpireCycle() {
+	if db.server.activeExpire.Load() {
+		db.checkAndRemoveExpiredKeys()
+	}
+}
+
+// checkAndRemoveExpiredKeys removes the expired keys, one shard at a time, and counts them in the stats.
+// The expired keys are collected under the read lock, so that other clients are not stalled
+// while the shard is scanned, then removed in a short write-locked section.
 func (db *Database) checkAndRemoveExpiredKeys() {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
+	for _, s := range db.shards {
+		var expired []string
+
+		s.mutex.RLock()
+		now := db.server.clock.Now()
+		for key, expireTime := range s.ExpireKeys {
+			if now.After(expireTime) {
+				expired = append(expired, key)
+			}
+		}
+		s.mutex.RUnlock()
+
+		if len(expired) == 0 {
+			continue
+		}
 
-	for key, expireTime := range db.ExpireKeys {
-		if time.Now().After(expireTime) {
-			delete(db.StringKeys, key)
-			delete(db.ExpireKeys, key)
+		s.mutex.Lock()
+		for _, key := range expired {
+			// The key may have been set again since it was collected
+			if !s.exists(key) {
+				s.remove(key)
+				db.server.stats.expiredKeys.Add(1)
+			}
 		}
+		s.unlock()
 	}
 }
 
 // checkAndRemoveExpiredKey checks if a key has expired.
-// If a key has expired, it removes the key.
+// If a key has expired, it removes the key and counts it in the stats.
 func (db *Database) checkAndRemoveExpiredKey(key string) bool {
-	db.mutex.RLock()
-	expire, ok := db.ExpireKeys[key]
-	db.mutex.RUnlock()
+	s := db.shardOf(key)
 
-	if !ok {
+	s.mutex.RLock()
+	expire, ok := s.ExpireKeys[key]
+	s.mutex.RUnlock()
+
+	if !ok || !db.server.clock.Now().After(expire) {
 		return false
 	}
 
-	if time.Now().After(expire) {
-		db.mutex.Lock()
-		delete(db.StringKeys, key)
-		delete(db.ExpireKeys, key)
-		db.mutex.Unlock()
+	s.mutex.Lock()
+	defer s.unlock()
 
-		return true
+	// The key may have been set again since its expire time was read
+	if s.exists(key) {
+		return false
 	}
 
-	return false
+	s.remove(key)
+	db.server.stats.expiredKeys.Add(1)
+
+	return true
+}
+
+// exists reports whether the given key holds a value that has not expired.
+// It is the single place deciding whether a key exists, whatever the type of its value.
+// The caller must hold at least the read lock of the shard.
+func (s *shard) exists(key string) bool {
+	_, isString := s.StringKeys[key]
+	_, isStream := s.StreamKeys[key]
+
+	if !isString && !isStream {
+		return false
+	}
+
+	expire, ok := s.ExpireKeys[key]
+
+	return !ok || !s.clock.Now().After(expire)
+}
+
+// remove deletes the given key along with its expire time, whatever the type of its value.
+// The caller must hold the write lock of the shard.
+func (s *shard) remove(key string) {
+	delete(s.StringKeys, key)
+	delete(s.StreamKeys, key)
+	delete(s.ExpireKeys, key)
+	delete(s.RawKeys, key)
 }
 
 // StopExpireChecker stops the ExpireChecker.
@@ -168,9 +565,15 @@ func (db *Database) StopExpireChecker() {
 }
 
 func (db *Database) GetExpire(key string) time.Time {
-	db.mutex.RLock()
-	expire, ok := db.ExpireKeys[key]
-	db.mutex.RUnlock()
+	s := db.shardOf(key)
+
+	if view := s.view.Load(); view != nil {
+		return view.expires[key]
+	}
+
+	s.mutex.RLock()
+	expire, ok := s.ExpireKeys[key]
+	s.mutex.RUnlock()
 
 	if !ok {
 		return time.Time{}
@@ -180,121 +583,340 @@ func (db *Database) GetExpire(key string) time.Time {
 }
 
 // Get returns the value of the given key.
-// If the key does not exist, it returns an empty string.
-// If the key has expired, it returns an empty string.
+// If the key does not exist, has expired or does not hold a string, it returns an empty string.
 func (db *Database) Get(key string) string {
-	db.mutex.RLock()
-	storage, ok := db.StringKeys[key]
-	db.mutex.RUnlock()
-	if !ok {
-		return ""
+	value, _, _ := db.Lookup(key)
+
+	return value
+}
+
+// Lookup returns the value of the given key and whether the key exists.
+// Unlike Get, it tells a missing key apart from a key holding an empty string.
+// If the key has expired, it is removed and reported as missing.
+// It returns errWrongType if the key holds another type.
+func (db *Database) Lookup(key string) (string, bool, error) {
+	s := db.shardOf(key)
+
+	var storage string
+	var isString, isStream bool
+
+	if view := s.view.Load(); view != nil {
+		storage, isString = view.strings[key]
+		isStream = view.streams[key]
+
+		if expire, ok := view.expires[key]; ok && db.server.clock.Now().After(expire) {
+			db.checkAndRemoveExpiredKey(key)
+			return "", false, nil
+		}
+	} else {
+		s.mutex.RLock()
+		storage, isString = s.StringKeys[key]
+		_, isStream = s.StreamKeys[key]
+		s.mutex.RUnlock()
+
+		if (isString || isStream) && db.checkAndRemoveExpiredKey(key) {
+			return "", false, nil
+		}
 	}
 
-	if db.checkAndRemoveExpiredKey(key) {
-		return ""
+	if isStream {
+		return "", false, errWrongType
 	}
 
-	return storage
+	return storage, isString, nil
 }
 
 // Set sets the value of the given key.
 func (db *Database) Set(key string, value string) {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
+	s := db.shardOf(key)
+
+	s.mutex.Lock()
+	defer s.unlock()
 
-	db.StringKeys[key] = value
+	delete(s.StreamKeys, key)
+	delete(s.RawKeys, key)
+	s.StringKeys[key] = value
+	db.touch(1)
 }
 
-// Del deletes the given keys.
+// A keyUpdate tells Update what to do with a key once its value has been read.
+type keyUpdate int
+
+const (
+	// keepValue leaves the key untouched.
+	keepValue keyUpdate = iota
+	// setValue stores the new value, keeping the expire time of the key.
+	setValue
+	// replaceValue stores the new value and discards the expire time of the key.
+	replaceValue
+	// setRawValue stores the new value, keeping the expire time of the key,
+	// and marks it raw-encoded as a value modified in place by APPEND or SETRANGE.
+	setRawValue
+	// deleteKey deletes the key along with its expire time.
+	deleteKey
+)
+
+// Update reads the value of the given key and applies the mutation under the write lock of its shard,
+// so that no other client can change the key in between.
+// mutate receives the current value and whether the key exists, telling a missing key apart from an empty value,
+// and returns the new value along with what to do with it, or an error, which leaves the key untouched and is returned.
+// An expired key is reported as missing, and removed unless a new value is stored.
+// If the key holds another type, mutate is not called and errWrongType is returned, leaving the key untouched.
+func (db *Database) Update(key string, mutate func(value string, found bool) (string, keyUpdate, error)) error {
+	s := db.shardOf(key)
+
+	s.mutex.Lock()
+	defer s.unlock()
+
+	if !s.exists(key) {
+		s.remove(key)
+	}
+
+	if _, isStream := s.StreamKeys[key]; isStream {
+		return errWrongType
+	}
+
+	value, found := s.StringKeys[key]
+	value, update, err := mutate(value, found)
+	if err != nil {
+		return err
+	}
+
+	switch update {
+	case setValue:
+		delete(s.StreamKeys, key)
+		delete(s.RawKeys, key)
+		s.StringKeys[key] = value
+		db.touch(1)
+	case replaceValue:
+		delete(s.StreamKeys, key)
+		delete(s.RawKeys, key)
+		s.StringKeys[key] = value
+		delete(s.ExpireKeys, key)
+		db.touch(1)
+	case setRawValue:
+		delete(s.StreamKeys, key)
+		s.StringKeys[key] = value
+		s.RawKeys[key] = true
+		db.touch(1)
+	case deleteKey:
+		if found {
+			db.touch(1)
+		}
+
+		s.remove(key)
+	}
+
+	return nil
+}
+
+// Del deletes the given keys along with their expire times.
+// It returns the number of keys that existed and were deleted.
+// Expired keys are removed but not counted, and a key given twice is only counted once, since it is gone the second time.
 func (db *Database) Del(keys ...string) int {
+	defer db.lockKeys(true, keys...)()
+
 	numberOfKeysDeleted := 0
 
 	for _, key := range keys {
-		value := db.Get(key)
-		if value != "" {
-			db.mutex.Lock()
-			delete(db.StringKeys, key)
-			db.mutex.Unlock()
+		s := db.shardOf(key)
+		if s.exists(key) {
 			numberOfKeysDeleted++
 		}
+
+		s.remove(key)
 	}
 
+	db.touch(numberOfKeysDeleted)
+
 	return numberOfKeysDeleted
 }
 
-// GetSet sets the value of the given key and returns the old value.
-// If the key does not exist, it creates a new key.
+// GetSet sets the value of the given key and returns the old value, and whether the key existed.
 // If the key has expired, it creates a new key.
-func (db *Database) GetSet(key string, value string) string {
-	storage := db.Get(key)
+// Like SET, it discards any expire time associated with the key.
+// It returns errWrongType if the key holds another type.
+func (db *Database) GetSet(key string, value string) (string, bool, error) {
+	var oldValue string
+	var existed bool
+
+	err := db.Update(key, func(current string, found bool) (string, keyUpdate, error) {
+		oldValue, existed = current, found
+		return value, replaceValue, nil
+	})
+
+	return oldValue, existed, err
+}
 
-	if storage == "" {
-		db.StringKeys[key] = value
-		return ""
-	}
+// GetDel deletes the given key and returns its value, and whether the key existed.
+// If the key has expired, it is reported as missing.
+// It returns errWrongType, without deleting the key, if the key holds another type.
+func (db *Database) GetDel(key string) (string, bool, error) {
+	var value string
+	var existed bool
 
-	if db.checkAndRemoveExpiredKey(key) {
-		db.Set(key, value)
-		return ""
-	}
+	err := db.Update(key, func(current string, found bool) (string, keyUpdate, error) {
+		value, existed = current, found
+		return "", deleteKey, nil
+	})
 
-	oldValue := storage
-	db.Set(key, value)
+	return value, existed, err
+}
+
+// Append appends the value to the value of the given key, creating the key if it does not exist.
+// It returns the length of the new value, or errStringTooLong, leaving the key untouched,
+// if the new value would be longer than maxLength bytes, and errWrongType if the key holds another type.
+func (db *Database) Append(key string, value string, maxLength int64) (int, error) {
+	var length int
 
-	return oldValue
+	err := db.Update(key, func(current string, found bool) (string, keyUpdate, error) {
+		if int64(len(current))+int64(len(value)) > maxLength {
+			return current, keepValue, errStringTooLong
+		}
+
+		current += value
+		length = len(current)
+
+		return current, setRawValue, nil
+	})
+
+	return length, err
 }
 
-// GetDel returns the value of the given key and deletes the key.
-// If the key does not exist, it returns an empty string.
-// If the key has expired, it returns an empty string.
-func (db *Database) GetDel(key string) string {
-	storage := db.Get(key)
-	if storage == "" {
-		return ""
-	}
+// SetRange overwrites the value of the given key from offset on with the value,
+// padding it with zero bytes if it is shorter than offset.
+// A missing key is created, unless the value is empty.
+// It returns the length of the new value, or errStringTooLong, leaving the key untouched,
+// if the new value would be longer than maxLength bytes, and errWrongType if the key holds another type.
+func (db *Database) SetRange(key string, offset int, value string, maxLength int64) (int, error) {
+	var length int
+
+	err := db.Update(key, func(current string, found bool) (string, keyUpdate, error) {
+		if value == "" {
+			length = len(current)
+			return current, keepValue, nil
+		}
 
-	db.Del(key)
+		if int64(offset)+int64(len(value)) > maxLength {
+			return current, keepValue, errStringTooLong
+		}
+
+		buffer := []byte(current)
+		if end := offset + len(value); end > len(buffer) {
+			buffer = append(buffer, make([]byte, end-len(buffer))...)
+		}
+
+		copy(buffer[offset:], value)
+		length = len(buffer)
+
+		return string(buffer), setRawValue, nil
+	})
+
+	return length, err
+}
+
+// IsRaw reports whether the string value of the given key was modified in place by APPEND or SETRANGE,
+// and is thus raw-encoded whatever its contents.
+func (db *Database) IsRaw(key string) bool {
+	s := db.shardOf(key)
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.RawKeys[key]
+}
 
-	return storage
+// SetBytes sets the key to the value without copying it.
+// The database takes ownership of the value, which must not be modified afterwards.
+func (db *Database) SetBytes(key string, value []byte) {
+	db.Set(key, bytesToString(value))
+}
+
+// GetBytes returns the value of the key without copying it, and whether the key exists.
+// It returns errWrongType if the key holds another type.
+// The returned bytes must not be modified.
+func (db *Database) GetBytes(key string) ([]byte, bool, error) {
+	value, ok, err := db.Lookup(key)
+
+	return stringToBytes(value), ok, err
 }
 
 // Setpx sets the value of the given key with the given milliseconds.
 func (db *Database) Setpx(key string, milliseconds int, value string) {
-	db.Set(key, value)
-	db.mutex.Lock()
-	db.ExpireKeys[key] = time.Now().Add(time.Millisecond * time.Duration(milliseconds))
-	db.mutex.Unlock()
+	db.SetExpireAt(key, value, db.server.clock.Now().Add(time.Millisecond*time.Duration(milliseconds)))
 }
 
-// MSet sets the values of the given keys.
+// SetExpireAt sets the value of the given key along with its expire time, under the write lock of its shard.
+func (db *Database) SetExpireAt(key string, value string, expire time.Time) {
+	s := db.shardOf(key)
+
+	s.mutex.Lock()
+	defer s.unlock()
+
+	delete(s.StreamKeys, key)
+	delete(s.RawKeys, key)
+	s.StringKeys[key] = value
+	s.ExpireKeys[key] = expire
+	db.touch(1)
+}
+
+// MSet sets the values of the given keys, replacing the values of any type they hold.
+// The keys are locked together, so that no other client can see some of them set and not the others.
 func (db *Database) MSet(args ...string) {
+	keys := make([]string, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		keys = append(keys, args[i])
+	}
+
+	defer db.lockKeys(true, keys...)()
+
 	for i := 0; i < len(args); i += 2 {
-		db.Set(args[i], args[i+1])
+		s := db.shardOf(args[i])
+		delete(s.StreamKeys, args[i])
+		delete(s.RawKeys, args[i])
+		s.StringKeys[args[i]] = args[i+1]
 	}
+
+	db.touch(len(keys))
 }
 
-// MSetNX sets the values of the given keys if the keys do not exist.
+// MSetNX sets the values of the given keys if none of the keys exist, whatever the type of their values.
+// The keys are locked together, so that no other client can create one of them in between.
 func (db *Database) MSetNX(args ...string) bool {
+	keys := make([]string, 0, len(args)/2)
 	for i := 0; i < len(args); i += 2 {
-		if storage := db.Get(args[i]); storage != "" {
+		keys = append(keys, args[i])
+	}
+
+	defer db.lockKeys(true, keys...)()
+
+	for _, key := range keys {
+		if db.shardOf(key).exists(key) {
 			return false
 		}
 	}
 
 	for i := 0; i < len(args); i += 2 {
-		db.Set(args[i], args[i+1])
+		s := db.shardOf(args[i])
+		s.remove(args[i])
+		s.StringKeys[args[i]] = args[i+1]
 	}
 
+	db.touch(len(keys))
+
 	return true
 }
 
 // MGet returns the values of the given keys.
-func (db *Database) MGet(args ...string) []string {
-	argsLen := len(args)
-	values := make([]string, argsLen)
-
-	for i := 0; i < argsLen; i++ {
-		values[i] = db.Get(args[i])
+// Keys that do not exist or do not hold a string are returned as nil, so that they are replied as nulls,
+// while keys holding an empty string are returned as such.
+func (db *Database) MGet(args ...string) []*string {
+	values := make([]*string, len(args))
+
+	for i, key := range args {
+		if value, found, _ := db.Lookup(key); found {
+			values[i] = &value
+		}
 	}
 
 	return values
@@ -302,115 +924,81 @@ func (db *Database) MGet(args ...string) []string {
 
 // Incr increments the value of the given key by 1.
 // If the key does not exist, it creates a new key with the value 1.
-// If value of the key is not an integer, it returns 0.
-func (db *Database) Incr(key string) int {
-	storage := db.Get(key)
-	if storage == "" {
-		db.Set(key, "1")
-		return 1
-	}
-
-	if db.checkAndRemoveExpiredKey(key) {
-		return 0
-	}
-
-	value, err := strconv.Atoi(storage)
-	if err != nil {
-		return 0
-	}
-
-	value++
-	db.Set(key, strconv.Itoa(value))
-
-	return value
+// It returns errNotInteger if the value of the key is not an integer or would overflow.
+func (db *Database) Incr(key string) (int, error) {
+	return db.IncrBy(key, 1)
 }
 
 // Incrby increments the value of the given key by the given increment.
 // If the key does not exist, it creates a new key with the value increment.
-// If value of the key is not an integer, it returns 0.
-func (db *Database) IncrBy(key string, increment int) int {
-	storage := db.Get(key)
-	if storage == "" {
-		db.Set(key, strconv.Itoa(increment))
-		return increment
-	}
+// The expire time of the key is kept.
+// It returns errNotInteger, leaving the key untouched, if the value of the key is not an integer
+// or the result would overflow a 64-bit integer, and errWrongType if the key holds another type.
+func (db *Database) IncrBy(key string, increment int) (int, error) {
+	var result int
+
+	err := db.Update(key, func(current string, found bool) (string, keyUpdate, error) {
+		if !found {
+			result = increment
+			return strconv.Itoa(result), setValue, nil
+		}
 
-	if db.checkAndRemoveExpiredKey(key) {
-		return 0
-	}
+		value, ok := integerValue(current)
+		if !ok {
+			return current, keepValue, errNotInteger
+		}
 
-	value, err := strconv.Atoi(storage)
-	if err != nil {
-		return 0
-	}
+		if (increment > 0 && value > math.MaxInt64-int64(increment)) || (increment < 0 && value < math.MinInt64-int64(increment)) {
+			return current, keepValue, errNotInteger
+		}
 
-	value += increment
-	db.Set(key, strconv.Itoa(value))
+		result = int(value) + increment
 
-	return value
+		return strconv.Itoa(result), setValue, nil
+	})
+
+	return result, err
 }
 
 // Decr decrements the value of the given key by 1.
 // If the key does not exist, it creates a new key with the value -1.
-// If value of the key is not an integer, it returns 0.
-func (db *Database) Decr(key string) int {
-	storage := db.Get(key)
-	if storage == "" {
-		db.Set(key, "-1")
-		return -1
-	}
-
-	if db.checkAndRemoveExpiredKey(key) {
-		return 0
-	}
-
-	value, err := strconv.Atoi(storage)
-	if err != nil {
-		return 0
-	}
-
-	value--
-	db.Set(key, strconv.Itoa(value))
-
-	return value
+// It returns errNotInteger if the value of the key is not an integer or would overflow.
+func (db *Database) Decr(key string) (int, error) {
+	return db.IncrBy(key, -1)
 }
 
 // Decrby decrements the value of the given key by the given decrement.
 // If the key does not exist, it creates a new key with the value -decrement.
-// If value of the key is not an integer, it returns 0.
-func (db *Database) DecrBy(key string, decrement int) int {
-	storage := db.Get(key)
-	if storage == "" {
-		db.Set(key, strconv.Itoa(-decrement))
-		return -decrement
-	}
-
-	if db.checkAndRemoveExpiredKey(key) {
-		return 0
+// It returns errNotInteger if the value of the key is not an integer or would overflow.
+func (db *Database) DecrBy(key string, decrement int) (int, error) {
+	// The decrement cannot be negated
+	if decrement == math.MinInt {
+		return 0, errNotInteger
 	}
 
-	value, err := strconv.Atoi(storage)
-	if err != nil {
-		return 0
-	}
-
-	value -= decrement
-	db.Set(key, strconv.Itoa(value))
-
-	return value
+	return db.IncrBy(key, -decrement)
 }
 
-// Expire sets the expire time of the given key.
+// Expire sets the expire time of the given key, whatever the type of its value.
 // If the key does not exist, it returns false.
 func (db *Database) Expire(key string, seconds int) bool {
-	storage := db.Get(key)
-	if storage == "" {
+	return db.ExpireAt(key, db.server.clock.Now().Add(time.Second*time.Duration(seconds)))
+}
+
+// ExpireAt sets the expire time of the given key to the given time, whatever the type of its value.
+// If the key does not exist, it returns false.
+func (db *Database) ExpireAt(key string, expire time.Time) bool {
+	s := db.shardOf(key)
+
+	s.mutex.Lock()
+	defer s.unlock()
+
+	if !s.exists(key) {
 		return false
 	}
 
-	db.mutex.Lock()
-	db.ExpireKeys[key] = time.Now().Add(time.Second * time.Duration(seconds))
-	db.mutex.Unlock()
+	s.ExpireKeys[key] = expire
+	db.touch(1)
 
 	return true
 }
@@ -419,50 +1007,55 @@ func (db *Database) Expire(key string, seconds int) bool {
 // If the key does not exist, it returns -2.
 // If the key exists but has no associated expire, it returns -1.
 func (db *Database) TTL(key string) int {
-	// db.mutex.Lock()
-	// _, ok := db.StringKeys[key]
-	// db.mutex.Unlock()
-	storage := db.Get(key)
-	if storage == "" {
+	s := db.shardOf(key)
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if !s.exists(key) {
 		return -2
 	}
 
-	expire := db.GetExpire(key)
-	if expire == (time.Time{}) {
+	expire, ok := s.ExpireKeys[key]
+	if !ok {
 		return -1
 	}
 
-	return int(time.Until(expire).Seconds())
+	return int(expire.Sub(s.clock.Now()).Seconds())
 }
 
 // Persist removes the expire time of the given key.
 // If the key exists but has no associated expire, it returns false.
 // If the key does not exist, it returns false.
 func (db *Database) Persist(key string) bool {
-	storage := db.Get(key)
-	if storage == "" {
+	s := db.shardOf(key)
+
+	s.mutex.Lock()
+	defer s.unlock()
+
+	if !s.exists(key) {
 		return false
 	}
 
-	expire := db.GetExpire(key)
-	if expire == (time.Time{}) {
+	if _, ok := s.ExpireKeys[key]; !ok {
 		return false
 	}
 
-	db.mutex.Lock()
-	delete(db.ExpireKeys, key)
-	db.mutex.Unlock()
+	delete(s.ExpireKeys, key)
+	db.touch(1)
 
 	return true
 }
 
-// Exists returns true if the given key exists.
-func (db *Database) Exists(key ...string) int {
+// Exists returns the number of the given keys that exist.
+// A key given several times is counted each time, as in Redis.
+func (db *Database) Exists(keys ...string) int {
+	defer db.lockKeys(false, keys...)()
+
 	numberOfKeysExisting := 0
 
-	for _, key := range key {
-		storage := db.Get(key)
-		if storage != "" {
+	for _, key := range keys {
+		if db.shardOf(key).exists(key) {
 			numberOfKeysExisting++
 		}
 	}
@@ -470,18 +1063,203 @@ func (db *Database) Exists(key ...string) int {
 	return numberOfKeysExisting
 }
 
-// Keys returns all keys matching the given pattern.
-func (db *Database) Keys(pattern string) []string {
-	keys := make([]string, 0, len(db.StringKeys))
+// Keys returns all keys matching the given pattern, skipping the expired ones.
+// It only takes the read locks, one shard at a time: expired keys are left to the ExpireChecker or to lazy deletion.
+// Once the context is canceled, it stops before the next shard and returns the error of the context.
+func (db *Database) Keys(ctx context.Context, pattern string) ([]string, error) {
+	keys := []string{}
+
+	for _, s := range db.shards {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 
-	db.mutex.RLock()
-	for key := range db.StringKeys {
-		match, _ := filepath.Match(pattern, key)
-		if match {
+		keys = s.appendMatchingKeys(keys, pattern)
+	}
+
+	return keys, nil
+}
+
+// appendMatchingKeys appends the existing keys of the shard matching the glob-style pattern to keys.
+func (s *shard) appendMatchingKeys(keys []string, pattern string) []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	match := func(key string) {
+		if s.exists(key) && stringMatch(pattern, key, false) {
 			keys = append(keys, key)
 		}
 	}
-	db.mutex.RUnlock()
+
+	for key := range s.StringKeys {
+		match(key)
+	}
+
+	for key := range s.StreamKeys {
+		match(key)
+	}
 
 	return keys
 }
+
+// Size returns the number of keys in the database, skipping the expired ones.
+func (db *Database) Size() int {
+	defer db.lockAll(false)()
+
+	size := 0
+	now := db.server.clock.Now()
+
+	for _, s := range db.shards {
+		size += len(s.StringKeys) + len(s.StreamKeys)
+
+		for _, expireTime := range s.ExpireKeys {
+			if now.After(expireTime) {
+				size--
+			}
+		}
+	}
+
+	return size
+}
+
+// lockKeyPair locks for writing the shard holding key in db and the shard holding otherKey in other,
+// in the order of the database ids then of the shard indexes to avoid deadlocks, and a shard only once.
+// It returns both shards and the function unlocking them.
+func (db *Database) lockKeyPair(key string, other *Database, otherKey string) (*shard, *shard, func()) {
+	source, target := db.shardOf(key), other.shardOf(otherKey)
+	if source == target {
+		source.mutex.Lock()
+		return source, target, source.unlock
+	}
+
+	first, second := source, target
+	if other.id < db.id || other.id == db.id && shardIndex(otherKey) < shardIndex(key) {
+		first, second = target, source
+	}
+
+	first.mutex.Lock()
+	second.mutex.Lock()
+
+	return source, target, func() {
+		second.unlock()
+		first.unlock()
+	}
+}
+
+// copyKey copies the value of key, whatever its type, along with its expire time, to newKey in target,
+// replacing whatever newKey held. Streams are copied, so that both keys can be added to independently.
+// The caller must hold the write locks of both shards.
+func (s *shard) copyKey(key string, target *shard, newKey string) {
+	target.remove(newKey)
+
+	if value, ok := s.StringKeys[key]; ok {
+		target.StringKeys[newKey] = value
+		if s.RawKeys[key] {
+			target.RawKeys[newKey] = true
+		}
+	} else {
+		st := *s.StreamKeys[key]
+		st.Entries = append([]streamEntry(nil), st.Entries...)
+		target.StreamKeys[newKey] = &st
+	}
+
+	if expire, ok := s.ExpireKeys[key]; ok {
+		target.ExpireKeys[newKey] = expire
+	}
+}
+
+// Move moves the given key, along with its expire time, to the destination database.
+// If the key does not exist, or already exists in the destination, it returns false.
+func (db *Database) Move(key string, destination *Database) bool {
+	source, target, unlock := db.lockKeyPair(key, destination, key)
+	defer unlock()
+
+	if !source.exists(key) || target.exists(key) {
+		return false
+	}
+
+	source.copyKey(key, target, key)
+	source.remove(key)
+
+	db.touch(1)
+	destination.touch(1)
+
+	return true
+}
+
+// Rename renames key to newKey, whatever the type of its value, keeping its expire time.
+// If newKey exists, it is overwritten. If key does not exist, it returns false.
+func (db *Database) Rename(key string, newKey string) bool {
+	source, target, unlock := db.lockKeyPair(key, db, newKey)
+	defer unlock()
+
+	if !source.exists(key) {
+		source.remove(key)
+		return false
+	}
+
+	db.touch(1)
+
+	if key == newKey {
+		return true
+	}
+
+	source.copyKey(key, target, newKey)
+	source.remove(key)
+
+	return true
+}
+
+// Copy copies the value of key, whatever its type, along with its expire time, to newKey in the destination database.
+// If newKey already exists, it is only overwritten if replace is true.
+// It returns false if key does not exist, or if newKey exists and is not replaced.
+func (db *Database) Copy(key string, destination *Database, newKey string, replace bool) bool {
+	source, target, unlock := db.lockKeyPair(key, destination, newKey)
+	defer unlock()
+
+	if !source.exists(key) || target.exists(newKey) && !replace {
+		return false
+	}
+
+	source.copyKey(key, target, newKey)
+	destination.touch(1)
+
+	return true
+}
+
+// DatasetBytes returns an approximation of the number of bytes taken by the keys of the database and their values,
+// summing what MEMORY USAGE reports for each of them.
+func (db *Database) DatasetBytes() int64 {
+	defer db.lockAll(false)()
+
+	var bytes int64
+
+	for _, s := range db.shards {
+		for key, value := range s.StringKeys {
+			_, volatile := s.ExpireKeys[key]
+			bytes += int64(memoryUsage(key, value, volatile))
+		}
+
+		for key, st := range s.StreamKeys {
+			_, volatile := s.ExpireKeys[key]
+			bytes += int64(streamMemoryUsage(key, st, volatile))
+		}
+	}
+
+	return bytes
+}
+
+// KeyCount returns the number of keys in the database
+// and the number of keys with an expire time.
+func (db *Database) KeyCount() (int, int) {
+	defer db.lockAll(false)()
+
+	keys, expires := 0, 0
+
+	for _, s := range db.shards {
+		keys += len(s.StringKeys) + len(s.StreamKeys)
+		expires += len(s.ExpireKeys)
+	}
+
+	return keys, expires
+}
@@ -0,0 +1,168 @@
+package main
+
+// maxGlobNesting bounds the recursion of the matcher on patterns with many stars.
+const maxGlobNesting = 1000
+
+// A globMatcher matches strings against Redis glob-style patterns:
+// * matches any sequence, ? any character, [abc], [^abc] and [a-z] a character in (or out of) a set,
+// and \ escapes the next character.
+// It counts its steps, and gives up on longer matches for a star once the rest of the pattern
+// matched nowhere in the rest of the string, so that patterns like *a*a*a*b take linear-ish time.
+type globMatcher struct {
+	nocase            bool
+	skipLongerMatches bool
+	steps             int
+}
+
+// stringMatch reports whether s matches the glob-style pattern, ignoring case if nocase is true.
+func stringMatch(pattern string, s string, nocase bool) bool {
+	matched, _ := stringMatchSteps(pattern, s, nocase)
+
+	return matched
+}
+
+// stringMatchSteps reports whether s matches the glob-style pattern,
+// along with the number of steps the matcher took.
+func stringMatchSteps(pattern string, s string, nocase bool) (bool, int) {
+	m := &globMatcher{nocase: nocase}
+	matched := m.match(pattern, s, 0)
+
+	return matched, m.steps
+}
+
+func (m *globMatcher) equal(a byte, b byte) bool {
+	if m.nocase {
+		return toLower(a) == toLower(b)
+	}
+
+	return a == b
+}
+
+func (m *globMatcher) match(pattern string, s string, nesting int) bool {
+	if nesting > maxGlobNesting {
+		return false
+	}
+
+	for len(pattern) > 0 && len(s) > 0 {
+		m.steps++
+
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+
+			if len(pattern) == 1 {
+				return true
+			}
+
+			for len(s) > 0 {
+				if m.match(pattern[1:], s, nesting+1) {
+					return true
+				}
+
+				if m.skipLongerMatches {
+					return false
+				}
+
+				s = s[1:]
+			}
+
+			// The rest of the pattern matched nowhere in the rest of the string,
+			// so a longer match for any earlier star cannot help either.
+			m.skipLongerMatches = true
+
+			return false
+		case '?':
+			s = s[1:]
+		case '[':
+			pattern = pattern[1:]
+
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+
+			matched := false
+
+			for {
+				if len(pattern) == 0 {
+					// An unterminated set ends with the pattern: put back a character for the final skip
+					pattern = " "
+					break
+				}
+
+				if pattern[0] == '\\' && len(pattern) >= 2 {
+					pattern = pattern[1:]
+					if pattern[0] == s[0] {
+						matched = true
+					}
+				} else if pattern[0] == ']' {
+					break
+				} else if len(pattern) >= 3 && pattern[1] == '-' {
+					start, end, c := pattern[0], pattern[2], s[0]
+					if start > end {
+						start, end = end, start
+					}
+
+					if m.nocase {
+						start, end, c = toLower(start), toLower(end), toLower(c)
+					}
+
+					pattern = pattern[2:]
+
+					if c >= start && c <= end {
+						matched = true
+					}
+				} else if m.equal(pattern[0], s[0]) {
+					matched = true
+				}
+
+				pattern = pattern[1:]
+			}
+
+			if not {
+				matched = !matched
+			}
+
+			if !matched {
+				return false
+			}
+
+			s = s[1:]
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+
+			fallthrough
+		default:
+			if !m.equal(pattern[0], s[0]) {
+				return false
+			}
+
+			s = s[1:]
+		}
+
+		pattern = pattern[1:]
+
+		if len(s) == 0 {
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+
+			break
+		}
+	}
+
+	return len(pattern) == 0 && len(s) == 0
+}
+
+// toLower returns the lower case of an ASCII letter, and any other byte unchanged.
+func toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+
+	return c
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStringMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		nocase  bool
+		want    bool
+	}{
+		{"h?llo", "hello", false, true},
+		{"h?llo", "hallo", false, true},
+		{"h?llo", "hllo", false, false},
+		{"h*llo", "hllo", false, true},
+		{"h*llo", "heeeello", false, true},
+		{"h[ae]llo", "hello", false, true},
+		{"h[ae]llo", "hillo", false, false},
+		{"h[^e]llo", "hallo", false, true},
+		{"h[^e]llo", "hello", false, false},
+		{"h[a-b]llo", "hbllo", false, true},
+		{"h[a-b]llo", "hcllo", false, false},
+		{"h[b-a]llo", "hallo", false, true},
+		{"h\\*llo", "h*llo", false, true},
+		{"h\\*llo", "hello", false, false},
+		{"h[\\]]llo", "h]llo", false, true},
+		{"[abc", "a", false, true},
+		{"user:*", "user:1000", false, true},
+		{"user:*", "session:1000", false, false},
+		{"*:*", "user:1000", false, true},
+		{"a*", "a", false, true},
+		{"HELLO", "hello", false, false},
+		{"HELLO", "hello", true, true},
+		{"H[A-Z]LLO", "hello", true, true},
+		{"*", "", false, false},
+	}
+
+	for _, test := range tests {
+		if got := stringMatch(test.pattern, test.s, test.nocase); got != test.want {
+			t.Errorf("stringMatch(%q, %q, %t) = %t; want %t", test.pattern, test.s, test.nocase, got, test.want)
+		}
+	}
+}
+
+func TestStringMatchStarHeavyPattern(t *testing.T) {
+	pattern := strings.Repeat("*a", 16) + "*b"
+
+	for _, length := range []int{100, 1000, 10000} {
+		matched, steps := stringMatchSteps(pattern, strings.Repeat("a", length), false)
+		if matched {
+			t.Errorf("stringMatchSteps(%q, %d a's) matched; want no match", pattern, length)
+		}
+
+		if steps > 2*length {
+			t.Errorf("stringMatchSteps(%q, %d a's) took %d steps; want at most %d", pattern, length, steps, 2*length)
+		}
+	}
+}
+
+func TestDebugStringMatchLen(t *testing.T) {
+	result := debugCommand(testContext, []string{"STRINGMATCH-LEN", "h*llo", "hello"})
+	if !strings.HasPrefix(result, "*2\r\n:1\r\n:") {
+		t.Errorf("debugCommand([]string{\"STRINGMATCH-LEN\", \"h*llo\", \"hello\"}) = %q; want a match and its steps", result)
+	}
+
+	result = debugCommand(testContext, []string{"STRINGMATCH-LEN", "h?llo", "hllo"})
+	if !strings.HasPrefix(result, "*2\r\n:0\r\n") {
+		t.Errorf("debugCommand([]string{\"STRINGMATCH-LEN\", \"h?llo\", \"hllo\"}) = %q; want no match", result)
+	}
+
+	result = debugCommand(testContext, []string{"STRINGMATCH-LEN", "h*llo"})
+	if result != "-ERR wrong number of arguments for 'DEBUG STRINGMATCH-LEN' command\r\n" {
+		t.Errorf("debugCommand([]string{\"STRINGMATCH-LEN\", \"h*llo\"}) = %s; want a wrong number of arguments error", result)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLogs are stored as strings using the dense layout of Redis:
+// a 16 bytes header starting with "HYLL", followed by 16384 registers of 6 bits each.
+const (
+	hllMagic     = "HYLL"
+	hllHeader    = 16
+	hllP         = 14
+	hllQ         = 64 - hllP
+	hllRegisters = 1 << hllP
+	hllBits      = 6
+	hllMaxValue  = 1<<hllBits - 1
+	hllSize      = hllHeader + (hllRegisters*hllBits+7)/8
+
+	// hllSeed is the seed of the hash function, the same as Redis uses.
+	hllSeed = 0xadc83b19
+)
+
+// notHyperLogLogReply is the error replied when a key holds a string that is not a HyperLogLog.
+const notHyperLogLogReply = "-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n"
+
+// A hyperLogLog estimates the number of distinct elements added to it.
+type hyperLogLog []byte
+
+// newHyperLogLog returns an empty hyperLogLog.
+func newHyperLogLog() hyperLogLog {
+	hll := make(hyperLogLog, hllSize)
+	copy(hll, hllMagic)
+
+	return hll
+}
+
+// parseHyperLogLog returns a copy of the hyperLogLog stored in value,
+// and false if value is not one.
+func parseHyperLogLog(value string) (hyperLogLog, bool) {
+	if len(value) != hllSize || value[:len(hllMagic)] != hllMagic {
+		return nil, false
+	}
+
+	return hyperLogLog(value), true
+}
+
+// register returns the value of the register at index.
+func (hll hyperLogLog) register(index int) uint8 {
+	registers := hll[hllHeader:]
+	bit := index * hllBits
+	b0 := uint(registers[bit/8])
+
+	var b1 uint
+	if bit/8+1 < len(registers) {
+		b1 = uint(registers[bit/8+1])
+	}
+
+	shift := uint(bit % 8)
+
+	return uint8((b0>>shift | b1<<(8-shift)) & hllMaxValue)
+}
+
+// setRegister sets the register at index to value.
+func (hll hyperLogLog) setRegister(index int, value uint8) {
+	registers := hll[hllHeader:]
+	bit := index * hllBits
+	shift := uint(bit % 8)
+	v := uint(value)
+
+	registers[bit/8] &^= byte(hllMaxValue << shift)
+	registers[bit/8] |= byte(v << shift)
+
+	if bit/8+1 < len(registers) {
+		registers[bit/8+1] &^= byte(hllMaxValue >> (8 - shift))
+		registers[bit/8+1] |= byte(v >> (8 - shift))
+	}
+}
+
+// add adds the element, and returns true if a register changed,
+// meaning that the estimated cardinality may have changed.
+func (hll hyperLogLog) add(element string) bool {
+	hash := murmurHash64A([]byte(element), hllSeed)
+	index := int(hash & (hllRegisters - 1))
+
+	// The run of zeros is counted on the remaining bits, and capped at hllQ + 1.
+	hash >>= hllP
+	hash |= 1 << hllQ
+	count := uint8(bits.TrailingZeros64(hash) + 1)
+
+	if hll.register(index) >= count {
+		return false
+	}
+
+	hll.setRegister(index, count)
+
+	return true
+}
+
+// merge sets every register to the largest of its value and the one in other.
+func (hll hyperLogLog) merge(other hyperLogLog) {
+	for i := 0; i < hllRegisters; i++ {
+		if value := other.register(i); value > hll.register(i) {
+			hll.setRegister(i, value)
+		}
+	}
+}
+
+// count returns the estimated cardinality,
+// using the estimator from "New cardinality estimation algorithms for HyperLogLog sketches" by Otmar Ertl.
+func (hll hyperLogLog) count() int {
+	var histogram [hllQ + 2]int
+	for i := 0; i < hllRegisters; i++ {
+		histogram[hll.register(i)]++
+	}
+
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+
+	z += m * hllSigma(float64(histogram[0])/m)
+
+	return int(math.Round(0.5 / math.Ln2 * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+
+	y := 1.0
+	z := x
+
+	for {
+		x *= x
+		previous := z
+		z += x * y
+		y += y
+
+		if previous == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+
+	y := 1.0
+	z := 1 - x
+
+	for {
+		x = math.Sqrt(x)
+		previous := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+
+		if previous == z {
+			return z / 3
+		}
+	}
+}
+
+// murmurHash64A is the 64 bits MurmurHash2 by Austin Appleby.
+func murmurHash64A(data []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+
+	h := seed ^ uint64(len(data))*m
+
+	for len(data) >= 8 {
+		k := binary.LittleEndian.Uint64(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+
+		h ^= k
+		h *= m
+
+		data = data[8:]
+	}
+
+	if len(data) > 0 {
+		var tail [8]byte
+		copy(tail[:], data)
+		h ^= binary.LittleEndian.Uint64(tail[:])
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+
+	return h
+}
+
+func init() {
+	registerCommand("PFADD", CommandSpec{handler: pfaddCommand, arity: -2, flags: []string{"write", "fast"}, keys: keySpec{1, 1, 1, []string{"RW"}}})
+	registerCommand("PFCOUNT", CommandSpec{handler: pfcountCommand, arity: -2, flags: []string{"readonly"}, keys: keySpec{1, -1, 1, []string{"RO"}}})
+	registerCommand("PFMERGE", CommandSpec{handler: pfmergeCommand, arity: -2, flags: []string{"write"}, keys: keySpec{1, -1, 1, []string{"RW"}}})
+}
+
+// pfaddCommand adds the elements to the HyperLogLog at key, creating it if needed.
+// It returns 1 if the estimated cardinality changed, 0 otherwise.
+func pfaddCommand(ctx *commandContext, args []string) string {
+	changed, valid := false, true
+
+	err := ctx.db().Update(args[0], func(value string, found bool) (string, keyUpdate, error) {
+		hll := newHyperLogLog()
+		changed = !found
+
+		if found {
+			if hll, valid = parseHyperLogLog(value); !valid {
+				return "", keepValue, nil
+			}
+		}
+
+		for _, element := range args[1:] {
+			if hll.add(element) {
+				changed = true
+			}
+		}
+
+		if !changed {
+			return "", keepValue, nil
+		}
+
+		return string(hll), setValue, nil
+	})
+
+	if err != nil {
+		return returnKeyError(err)
+	}
+
+	if !valid {
+		return notHyperLogLogReply
+	}
+
+	if changed {
+		return returnInteger(1)
+	}
+
+	return returnInteger(0)
+}
+
+// pfcountCommand returns the estimated cardinality of the union of the HyperLogLogs at the given keys.
+// Missing keys count as empty HyperLogLogs.
+func pfcountCommand(ctx *commandContext, args []string) string {
+	union := newHyperLogLog()
+
+	for _, key := range args {
+		value, found, err := ctx.db().Lookup(key)
+		if err != nil {
+			return returnKeyError(err)
+		}
+
+		if !found {
+			continue
+		}
+
+		hll, ok := parseHyperLogLog(value)
+		if !ok {
+			return notHyperLogLogReply
+		}
+
+		if len(args) == 1 {
+			return returnInteger(hll.count())
+		}
+
+		union.merge(hll)
+	}
+
+	return returnInteger(union.count())
+}
+
+// pfmergeCommand stores at the destination key the union of the HyperLogLogs at the source keys and at destination.
+func pfmergeCommand(ctx *commandContext, args []string) string {
+	union := newHyperLogLog()
+
+	for _, key := range args[1:] {
+		value, found, err := ctx.db().Lookup(key)
+		if err != nil {
+			return returnKeyError(err)
+		}
+
+		if !found {
+			continue
+		}
+
+		hll, ok := parseHyperLogLog(value)
+		if !ok {
+			return notHyperLogLogReply
+		}
+
+		union.merge(hll)
+	}
+
+	valid := true
+
+	err := ctx.db().Update(args[0], func(value string, found bool) (string, keyUpdate, error) {
+		if found {
+			var hll hyperLogLog
+			if hll, valid = parseHyperLogLog(value); !valid {
+				return "", keepValue, nil
+			}
+
+			union.merge(hll)
+		}
+
+		return string(union), setValue, nil
+	})
+
+	if err != nil {
+		return returnKeyError(err)
+	}
+
+	if !valid {
+		return notHyperLogLogReply
+	}
+
+	return returnSimpleString("OK")
+}
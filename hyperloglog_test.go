@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// pfcount returns the result of PFCOUNT on the keys as an integer.
+func pfcount(t *testing.T, keys ...string) int {
+	t.Helper()
+
+	result := pfcountCommand(testContext, keys)
+
+	count, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(result, ":"), "\r\n"))
+	if err != nil {
+		t.Fatalf("pfcountCommand(%q) = %s; want an integer", keys, result)
+	}
+
+	return count
+}
+
+// assertWithinTolerance fails the test if count is more than 2% away from want.
+func assertWithinTolerance(t *testing.T, count int, want int) {
+	t.Helper()
+
+	if diff := count - want; diff*50 > want || -diff*50 > want {
+		t.Errorf("PFCOUNT = %d; want %d within 2%%", count, want)
+	}
+}
+
+func TestPfaddCommand(t *testing.T) {
+	defer teardown()
+
+	// Test with a new key
+	result := pfaddCommand(testContext, []string{"hll", "a", "b", "c"})
+	if result != ":1\r\n" {
+		t.Errorf("pfaddCommand([]string{\"hll\", \"a\", \"b\", \"c\"}) = %s; want :1\\r\\n", result)
+	}
+
+	// Test with elements already added
+	result = pfaddCommand(testContext, []string{"hll", "a", "b"})
+	if result != ":0\r\n" {
+		t.Errorf("pfaddCommand([]string{\"hll\", \"a\", \"b\"}) = %s; want :0\\r\\n", result)
+	}
+
+	// Test with no elements, on a new key then on an existing one
+	result = pfaddCommand(testContext, []string{"empty"})
+	if result != ":1\r\n" {
+		t.Errorf("pfaddCommand([]string{\"empty\"}) = %s; want :1\\r\\n", result)
+	}
+
+	result = pfaddCommand(testContext, []string{"empty"})
+	if result != ":0\r\n" {
+		t.Errorf("pfaddCommand([]string{\"empty\"}) = %s; want :0\\r\\n", result)
+	}
+
+	if count := pfcount(t, "hll"); count != 3 {
+		t.Errorf("PFCOUNT hll = %d; want 3", count)
+	}
+
+	// Test with a key holding a plain string
+	setCommand(testContext, []string{"string", "value"})
+
+	result = pfaddCommand(testContext, []string{"string", "a"})
+	if result != notHyperLogLogReply {
+		t.Errorf("pfaddCommand([]string{\"string\", \"a\"}) = %s; want %s", result, notHyperLogLogReply)
+	}
+
+	result = pfcountCommand(testContext, []string{"string"})
+	if result != notHyperLogLogReply {
+		t.Errorf("pfcountCommand([]string{\"string\"}) = %s; want %s", result, notHyperLogLogReply)
+	}
+
+	// Test that the HyperLogLog is a plain string value
+	if value := getCommand(testContext, []string{"hll"}); !strings.Contains(value, "HYLL") {
+		t.Errorf("getCommand([]string{\"hll\"}) = %q; want a HYLL string", value)
+	}
+}
+
+func TestPfcountCommandAccuracy(t *testing.T) {
+	defer teardown()
+
+	const elements = 100000
+
+	args := []string{"hll"}
+	for i := 0; i < elements; i++ {
+		args = append(args, "element:"+strconv.Itoa(i))
+
+		if len(args) == 1001 {
+			pfaddCommand(testContext, args)
+			args = args[:1]
+		}
+	}
+
+	assertWithinTolerance(t, pfcount(t, "hll"), elements)
+
+	if count := pfcount(t, "missing"); count != 0 {
+		t.Errorf("PFCOUNT missing = %d; want 0", count)
+	}
+}
+
+func TestPfmergeCommand(t *testing.T) {
+	defer teardown()
+
+	// Two overlapping sets: 0 to 5999 and 4000 to 9999
+	for i := 0; i < 10000; i++ {
+		element := strconv.Itoa(i)
+
+		if i < 6000 {
+			pfaddCommand(testContext, []string{"first", element})
+		}
+
+		if i >= 4000 {
+			pfaddCommand(testContext, []string{"second", element})
+		}
+	}
+
+	assertWithinTolerance(t, pfcount(t, "first", "second"), 10000)
+
+	result := pfmergeCommand(testContext, []string{"union", "first", "second", "missing"})
+	if result != okReply {
+		t.Errorf("pfmergeCommand([]string{\"union\", \"first\", \"second\", \"missing\"}) = %s; want +OK\\r\\n", result)
+	}
+
+	assertWithinTolerance(t, pfcount(t, "union"), 10000)
+
+	// Test that the destination is part of the union
+	pfaddCommand(testContext, []string{"third", "extra"})
+	pfmergeCommand(testContext, []string{"third", "first"})
+
+	assertWithinTolerance(t, pfcount(t, "third"), 6001)
+
+	// Test with a source holding a plain string
+	setCommand(testContext, []string{"string", "value"})
+
+	result = pfmergeCommand(testContext, []string{"union", "string"})
+	if result != notHyperLogLogReply {
+		t.Errorf("pfmergeCommand([]string{\"union\", \"string\"}) = %s; want %s", result, notHyperLogLogReply)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyHistoryLen is the maximum number of samples kept per latency event.
+const latencyHistoryLen = 160
+
+// A latencySample is a latency spike, in milliseconds, observed at a Unix timestamp.
+type latencySample struct {
+	timestamp int64
+	latency   int64
+}
+
+// A latencyEvent holds the latency spikes of an event, oldest first, along with the highest one.
+type latencyEvent struct {
+	samples []latencySample
+	max     int64
+}
+
+// A latencyMonitor records the latency spikes of the server into named events,
+// e.g. "command" for the commands exceeding the latency-monitor-threshold.
+type latencyMonitor struct {
+	events map[string]*latencyEvent
+	mutex  sync.Mutex
+}
+
+// Record adds a sample to the event if the duration reaches the threshold in milliseconds.
+// A threshold of zero or less disables the latency monitor.
+// Spikes happening in the same second are merged, keeping the highest latency.
+func (lm *latencyMonitor) Record(event string, duration time.Duration, threshold int) {
+	latency := duration.Milliseconds()
+	if threshold <= 0 || latency < int64(threshold) {
+		return
+	}
+
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
+	if lm.events == nil {
+		lm.events = make(map[string]*latencyEvent)
+	}
+
+	e, ok := lm.events[event]
+	if !ok {
+		e = &latencyEvent{}
+		lm.events[event] = e
+	}
+
+	if latency > e.max {
+		e.max = latency
+	}
+
+	now := time.Now().Unix()
+
+	if last := len(e.samples) - 1; last >= 0 && e.samples[last].timestamp == now {
+		if latency > e.samples[last].latency {
+			e.samples[last].latency = latency
+		}
+
+		return
+	}
+
+	e.samples = append(e.samples, latencySample{timestamp: now, latency: latency})
+	if len(e.samples) > latencyHistoryLen {
+		e.samples = e.samples[len(e.samples)-latencyHistoryLen:]
+	}
+}
+
+// A latestLatency is the latest spike of an event along with its highest latency.
+type latestLatency struct {
+	event  string
+	sample latencySample
+	max    int64
+}
+
+// Latest returns the latest spike of every recorded event, sorted by event name.
+// It is taken in one go, so that a concurrent Reset cannot leave an event without samples.
+func (lm *latencyMonitor) Latest() []latestLatency {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
+	latest := make([]latestLatency, 0, len(lm.events))
+	for name, e := range lm.events {
+		if len(e.samples) == 0 {
+			continue
+		}
+
+		latest = append(latest, latestLatency{event: name, sample: e.samples[len(e.samples)-1], max: e.max})
+	}
+
+	sort.Slice(latest, func(i, j int) bool { return latest[i].event < latest[j].event })
+
+	return latest
+}
+
+// History returns a copy of the samples of the event, oldest first, and its highest latency.
+func (lm *latencyMonitor) History(event string) ([]latencySample, int64) {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
+	e, ok := lm.events[event]
+	if !ok {
+		return nil, 0
+	}
+
+	samples := make([]latencySample, len(e.samples))
+	copy(samples, e.samples)
+
+	return samples, e.max
+}
+
+// Reset deletes the given events, or every event if none is given,
+// and returns the number of events deleted.
+func (lm *latencyMonitor) Reset(events ...string) int {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
+	if len(events) == 0 {
+		count := len(lm.events)
+		lm.events = nil
+
+		return count
+	}
+
+	count := 0
+
+	for _, event := range events {
+		if _, ok := lm.events[event]; ok {
+			delete(lm.events, event)
+			count++
+		}
+	}
+
+	return count
+}
+
+// commandLatencyWindow is the number of latest executions of each command used to compute its percentiles.
+const commandLatencyWindow = 1024
+
+// A latencyHistogram holds the durations of the latest executions of a command in a ring,
+// along with the total number of executions.
+type latencyHistogram struct {
+	samples []time.Duration
+	next    int
+	calls   int64
+}
+
+// A commandLatencySummary describes the latency of a command over its latest executions.
+type commandLatencySummary struct {
+	command string
+	calls   int64
+	p50     time.Duration
+	p99     time.Duration
+	max     time.Duration
+}
+
+// commandLatencies records the execution time of every command,
+// to report their latency percentiles over a rolling window.
+type commandLatencies struct {
+	histograms map[string]*latencyHistogram
+	mutex      sync.Mutex
+}
+
+// Record adds the duration of an execution of the command,
+// replacing the oldest one once the window is full.
+func (cl *commandLatencies) Record(command string, duration time.Duration) {
+	command = strings.ToLower(command)
+
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	if cl.histograms == nil {
+		cl.histograms = make(map[string]*latencyHistogram)
+	}
+
+	h, ok := cl.histograms[command]
+	if !ok {
+		h = &latencyHistogram{}
+		cl.histograms[command] = h
+	}
+
+	h.calls++
+
+	if len(h.samples) < commandLatencyWindow {
+		h.samples = append(h.samples, duration)
+		return
+	}
+
+	h.samples[h.next] = duration
+	h.next = (h.next + 1) % commandLatencyWindow
+}
+
+// Summaries returns the latency percentiles of every recorded command, sorted by name.
+func (cl *commandLatencies) Summaries() []commandLatencySummary {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	summaries := make([]commandLatencySummary, 0, len(cl.histograms))
+
+	for command, h := range cl.histograms {
+		sorted := make([]time.Duration, len(h.samples))
+		copy(sorted, h.samples)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		summaries = append(summaries, commandLatencySummary{
+			command: command,
+			calls:   h.calls,
+			p50:     percentile(sorted, 50),
+			p99:     percentile(sorted, 99),
+			max:     sorted[len(sorted)-1],
+		})
+	}
+
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].command < summaries[j].command })
+
+	return summaries
+}
+
+// percentile returns the nearest-rank percentile of the sorted durations, which must not be empty.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+// latencyDoctorReport describes the latency of every command over its latest executions, in microseconds.
+func latencyDoctorReport(summaries []commandLatencySummary) string {
+	if len(summaries) == 0 {
+		return "No command was executed yet, so there is no latency to report.\n"
+	}
+
+	var report strings.Builder
+
+	report.WriteString("Latency of the last " + strconv.Itoa(commandLatencyWindow) + " executions of each command, in microseconds:\n\n")
+
+	for _, summary := range summaries {
+		report.WriteString(summary.command +
+			": calls=" + strconv.FormatInt(summary.calls, 10) +
+			" p50=" + strconv.FormatInt(summary.p50.Microseconds(), 10) +
+			" p99=" + strconv.FormatInt(summary.p99.Microseconds(), 10) +
+			" max=" + strconv.FormatInt(summary.max.Microseconds(), 10) + "\n")
+	}
+
+	return report.String()
+}
+
+func init() {
+	registerCommand("LATENCY", CommandSpec{handler: latencyCommand, arity: -2, flags: []string{"admin", "loading"}})
+}
+
+// latencyCommand reports the latency spikes recorded by the latency monitor.
+// LATEST returns the event name, timestamp, latency and highest latency of the latest spike of each event.
+// HISTORY returns the timestamp and latency of every spike of the event.
+// RESET deletes the given events, or all of them, and returns how many were deleted.
+// DOCTOR reports the p50 and p99 latency of every command over its latest executions.
+func latencyCommand(ctx *commandContext, args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "LATEST":
+		latest := ctx.server.latency.Latest()
+		reply := "*" + strconv.Itoa(len(latest)) + "\r\n"
+
+		for _, event := range latest {
+			reply += "*4\r\n" +
+				returnBulkString(event.event) +
+				returnInteger(int(event.sample.timestamp)) +
+				returnInteger(int(event.sample.latency)) +
+				returnInteger(int(event.max))
+		}
+
+		return reply
+	case "HISTORY":
+		if len(args) != 2 {
+			return returnWrongNumberOfArgumentsError("LATENCY HISTORY")
+		}
+
+		samples, _ := ctx.server.latency.History(args[1])
+		reply := "*" + strconv.Itoa(len(samples)) + "\r\n"
+
+		for _, sample := range samples {
+			reply += "*2\r\n" +
+				returnInteger(int(sample.timestamp)) +
+				returnInteger(int(sample.latency))
+		}
+
+		return reply
+	case "RESET":
+		return returnInteger(ctx.server.latency.Reset(args[1:]...))
+	case "DOCTOR":
+		if len(args) != 1 {
+			return returnWrongNumberOfArgumentsError("LATENCY DOCTOR")
+		}
+
+		return returnBulkString(latencyDoctorReport(ctx.server.commandLatency.Summaries()))
+	case "HELP":
+		return returnHelp("LATENCY",
+			"LATEST",
+			"    Return the latest latency samples for all events.",
+			"HISTORY <event>",
+			"    Return time-latency samples for the <event> class.",
+			"RESET [<event> ...]",
+			"    Reset latency data of one or more <event> classes (default: reset all data for all event classes).",
+			"DOCTOR",
+			"    Return a human readable latency analysis report.",
+		)
+	default:
+		return returnUnknownSubcommandError("LATENCY", args[0])
+	}
+}
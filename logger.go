@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// A logLevel is the severity of a log message.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarning
+	levelError
+)
+
+// logLevelNames holds the names of the log levels, as written in the config.
+var logLevelNames = []string{"debug", "info", "warning", "error"}
+
+// String returns the name of the log level.
+func (level logLevel) String() string {
+	return logLevelNames[level]
+}
+
+// parseLogLevel returns the log level with the given name.
+func parseLogLevel(name string) (logLevel, error) {
+	for level, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return logLevel(level), nil
+		}
+	}
+
+	return 0, errors.New("argument must be one of debug, info, warning or error")
+}
+
+// A leveledLogger writes the messages at or above the loglevel of the config,
+// which can be changed at runtime.
+type leveledLogger struct {
+	logger *log.Logger
+	config *config
+}
+
+// newLogger returns a logger writing to w, filtered by the loglevel of the config.
+func newLogger(w io.Writer, cfg *config) *leveledLogger {
+	return &leveledLogger{
+		logger: log.New(w, "", log.Ldate|log.Ltime),
+		config: cfg,
+	}
+}
+
+// logf writes the message if its level is enabled.
+func (l *leveledLogger) logf(level logLevel, format string, v ...any) {
+	if level < l.config.currentLogLevel() {
+		return
+	}
+
+	l.logger.Printf("["+strings.ToUpper(level.String())+"] "+format, v...)
+}
+
+// Debugf writes a debug message, e.g. a client disconnecting abruptly.
+func (l *leveledLogger) Debugf(format string, v ...any) {
+	l.logf(levelDebug, format, v...)
+}
+
+// Infof writes an informational message, e.g. the server starting to listen.
+func (l *leveledLogger) Infof(format string, v ...any) {
+	l.logf(levelInfo, format, v...)
+}
+
+// Warningf writes a warning, e.g. a client sending an invalid request.
+func (l *leveledLogger) Warningf(format string, v ...any) {
+	l.logf(levelWarning, format, v...)
+}
+
+// Errorf writes an error, e.g. a database failing to be saved.
+func (l *leveledLogger) Errorf(format string, v ...any) {
+	l.logf(levelError, format, v...)
+}
+
+// Fatal writes the error whatever the log level, and exits the process.
+func (l *leveledLogger) Fatal(v ...any) {
+	l.logger.Fatal("[FATAL] " + fmt.Sprint(v...))
+}
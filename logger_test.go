@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLoggerLevel(t *testing.T) {
+	var output bytes.Buffer
+
+	cfg := &config{logLevel: levelInfo}
+	logger := newLogger(&output, cfg)
+
+	// Test with the default level
+	logger.Debugf("debug message")
+	logger.Infof("info message")
+
+	if strings.Contains(output.String(), "debug message") {
+		t.Errorf("log = %q; want debug messages to be suppressed", output.String())
+	}
+	if !strings.Contains(output.String(), "[INFO] info message") {
+		t.Errorf("log = %q; want the info message", output.String())
+	}
+
+	// Test with the WARNING level
+	output.Reset()
+	if err := logLevelParameter().set(cfg, "WARNING"); err != nil {
+		t.Fatalf("setting loglevel to WARNING returned %s", err)
+	}
+
+	logger.Infof("info message")
+	logger.Warningf("warning message")
+	logger.Errorf("error message")
+
+	if strings.Contains(output.String(), "info message") {
+		t.Errorf("log = %q; want info messages to be suppressed", output.String())
+	}
+	if !strings.Contains(output.String(), "[WARNING] warning message") || !strings.Contains(output.String(), "[ERROR] error message") {
+		t.Errorf("log = %q; want the warning and error messages", output.String())
+	}
+
+	// Test with an unknown level
+	if err := logLevelParameter().set(cfg, "verbose"); err == nil {
+		t.Errorf("setting loglevel to verbose returned no error")
+	}
+}
+
+func TestLoadConfigLogLevel(t *testing.T) {
+	cfg, err := loadConfig([]string{"-loglevel", "error", "-logfile", "testServer.log"})
+	if err != nil {
+		t.Fatalf("loadConfig returned %s", err)
+	}
+
+	if cfg.logLevel != levelError {
+		t.Errorf("loglevel = %s; want error", cfg.logLevel)
+	}
+	if cfg.logFile != "testServer.log" {
+		t.Errorf("logfile = %s; want testServer.log", cfg.logFile)
+	}
+}
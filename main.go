@@ -7,25 +7,40 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"io"
 	"log"
 	"os"
 )
 
-var redis *RedisServer
-
 func main() {
-	var cfg config
+	cfg, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	flag.IntVar(&cfg.port, "port", 6379, "REDIS server port")
-	flag.StringVar(&cfg.fileName, "load", "", "Load DB from a file")
-	flag.Parse()
+	output := io.Writer(os.Stdout)
+
+	if cfg.logFile != "" {
+		file, err := os.OpenFile(cfg.logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+
+		output = file
+	}
 
-	redis = &RedisServer{
-		config: &cfg,
-		logger: log.New(os.Stdout, "", log.Ldate|log.Ltime),
+	server := &RedisServer{
+		config: cfg,
+		logger: newLogger(output, cfg),
 	}
 
-	redis.Init()
-	redis.Run()
+	server.Init()
+	server.Run()
 }
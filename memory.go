@@ -0,0 +1,265 @@
+package main
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const (
+	// dictEntryOverhead is the size of a hash table entry: the key, value and next pointers.
+	dictEntryOverhead = 24
+
+	// objectOverhead is the size of the object header wrapping each value.
+	objectOverhead = 16
+
+	// streamIDSize is the size of the ID of a stream entry: its milliseconds and sequence number.
+	streamIDSize = 16
+)
+
+// stringSize returns the number of bytes taken by a string allocated the way Redis does,
+// that is its contents, a null terminator, and a header that grows with its length.
+func stringSize(s string) int {
+	switch {
+	case len(s) < 1<<5:
+		return 1 + len(s) + 1
+	case len(s) < 1<<8:
+		return 3 + len(s) + 1
+	case len(s) < 1<<16:
+		return 5 + len(s) + 1
+	default:
+		return 9 + len(s) + 1
+	}
+}
+
+// memoryUsage returns an approximation of the number of bytes taken by the key and its value:
+// the hash table entry, the key name, and the value along with its object header.
+// Integers are stored in the object header itself, and the shared ones take no object at all.
+// Keys with an expire time take an extra entry in the expires table.
+func memoryUsage(key string, value string, volatile bool) int {
+	usage := dictEntryOverhead + stringSize(key)
+
+	_, integer := integerValue(value)
+
+	switch {
+	case isSharedInteger(value):
+		// Shared integers are referenced from the entry, not allocated
+	case integer:
+		usage += objectOverhead
+	default:
+		usage += objectOverhead + stringSize(value)
+	}
+
+	if volatile {
+		usage += dictEntryOverhead
+	}
+
+	return usage
+}
+
+// streamMemoryUsage returns an approximation of the number of bytes taken by the key and its stream:
+// the hash table entry, the key name, the stream object, and for each entry its ID and fields.
+// Keys with an expire time take an extra entry in the expires table.
+func streamMemoryUsage(key string, st *stream, volatile bool) int {
+	usage := dictEntryOverhead + stringSize(key) + objectOverhead
+
+	for _, entry := range st.Entries {
+		usage += streamIDSize
+		for _, field := range entry.Fields {
+			usage += stringSize(field)
+		}
+	}
+
+	if volatile {
+		usage += dictEntryOverhead
+	}
+
+	return usage
+}
+
+// A memoryReport gathers the memory metrics reported by MEMORY STATS and MEMORY DOCTOR.
+// allocated is the memory in use by the Go heap, and dataset the part of it estimated to hold the keys and values.
+type memoryReport struct {
+	allocated int64
+	dataset   int64
+	maxMemory int64
+	keys      []int
+	expires   []int
+}
+
+// totalKeys returns the number of keys in every database.
+func (report memoryReport) totalKeys() int {
+	total := 0
+	for _, keys := range report.keys {
+		total += keys
+	}
+
+	return total
+}
+
+// overhead returns the memory that is not taken by the dataset.
+func (report memoryReport) overhead() int64 {
+	if report.allocated < report.dataset {
+		return 0
+	}
+
+	return report.allocated - report.dataset
+}
+
+// datasetPercentage returns the share of the allocated memory taken by the dataset.
+func (report memoryReport) datasetPercentage() float64 {
+	if report.allocated == 0 {
+		return 0
+	}
+
+	percentage := float64(report.dataset) * 100 / float64(report.allocated)
+	if percentage > 100 {
+		return 100
+	}
+
+	return percentage
+}
+
+// memoryReportOf gathers the memory metrics of the server.
+func memoryReportOf(server *RedisServer) memoryReport {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	report := memoryReport{
+		allocated: int64(stats.HeapAlloc),
+		maxMemory: server.config.maxMemoryLimit(),
+	}
+
+	for _, db := range server.databases {
+		keys, expires := db.KeyCount()
+		report.keys = append(report.keys, keys)
+		report.expires = append(report.expires, expires)
+		report.dataset += db.DatasetBytes()
+	}
+
+	return report
+}
+
+// memoryStats returns the metrics of the report as a flat array of names and values,
+// followed by the number of keys and expires of each non-empty database.
+func memoryStats(report memoryReport) Value {
+	keys := report.totalKeys()
+
+	bytesPerKey := int64(0)
+	if keys > 0 {
+		bytesPerKey = report.overhead() / int64(keys)
+	}
+
+	stats := []Value{
+		NewBulkString("total.allocated"), NewInteger(report.allocated),
+		NewBulkString("overhead.total"), NewInteger(report.overhead()),
+		NewBulkString("keys.count"), NewInteger(int64(keys)),
+		NewBulkString("keys.bytes-per-key"), NewInteger(bytesPerKey),
+		NewBulkString("dataset.bytes"), NewInteger(report.dataset),
+		NewBulkString("dataset.percentage"), NewBulkString(strconv.FormatFloat(report.datasetPercentage(), 'f', -1, 64)),
+	}
+
+	for i := range report.keys {
+		if report.keys[i] == 0 {
+			continue
+		}
+
+		stats = append(stats,
+			NewBulkString("db."+strconv.Itoa(i)),
+			NewArray(
+				NewBulkString("keys"), NewInteger(int64(report.keys[i])),
+				NewBulkString("expires"), NewInteger(int64(report.expires[i])),
+			),
+		)
+	}
+
+	return NewArray(stats...)
+}
+
+// memoryDoctor returns advice on the memory usage described by the report.
+func memoryDoctor(report memoryReport) string {
+	if report.totalKeys() == 0 {
+		return "This instance is empty, so there is nothing to diagnose yet. Come back once it holds some data.\n"
+	}
+
+	var issues []string
+
+	if report.maxMemory > 0 && report.allocated > report.maxMemory*9/10 {
+		issues = append(issues, "The allocated memory is above 90% of maxmemory. Consider raising maxmemory or removing unused keys.")
+	}
+
+	if report.datasetPercentage() < 50 {
+		issues = append(issues, "Less than half of the allocated memory holds the dataset. "+
+			"This is expected for small datasets, while a large one may be made of many small keys, which could be grouped.")
+	}
+
+	if len(issues) == 0 {
+		return "No memory issue was found in this instance.\n"
+	}
+
+	return "The following memory issues were found:\n\n* " + strings.Join(issues, "\n\n* ") + "\n"
+}
+
+func init() {
+	registerCommand("MEMORY", CommandSpec{handler: memoryCommand, arity: -2, flags: []string{"readonly"}, keys: keySpec{2, 2, 1, []string{"RO"}}})
+}
+
+// memoryCommand reports the memory used by the server.
+// STATS returns the memory metrics of the server, DOCTOR advice on its memory usage.
+// USAGE returns the approximate number of bytes taken by the key and its value, or null if the key does not exist.
+// SAMPLES bounds how many elements of an aggregate value are measured;
+// string values are always measured as a whole.
+func memoryCommand(ctx *commandContext, args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "USAGE":
+		if len(args) != 2 && len(args) != 4 {
+			return returnError("syntax error")
+		}
+
+		if len(args) == 4 {
+			if strings.ToUpper(args[2]) != "SAMPLES" {
+				return returnError("syntax error")
+			}
+
+			if samples, err := strconv.Atoi(args[3]); err != nil || samples < 0 {
+				return returnError("value is not an integer or out of range")
+			}
+		}
+
+		db := ctx.db()
+
+		value, ok, err := db.Lookup(args[1])
+		if err != nil {
+			return returnKeyError(err)
+		}
+
+		if !ok {
+			return returnNullBulkString()
+		}
+
+		return returnInteger(memoryUsage(args[1], value, !db.GetExpire(args[1]).IsZero()))
+	case "STATS":
+		if len(args) != 1 {
+			return returnWrongNumberOfArgumentsError("MEMORY STATS")
+		}
+
+		return string(memoryStats(memoryReportOf(ctx.server)).Encode())
+	case "DOCTOR":
+		if len(args) != 1 {
+			return returnWrongNumberOfArgumentsError("MEMORY DOCTOR")
+		}
+
+		return returnBulkString(memoryDoctor(memoryReportOf(ctx.server)))
+	case "HELP":
+		return returnHelp("MEMORY",
+			"USAGE <key> [SAMPLES <count>]",
+			"    Return memory in bytes used by <key> and its value.",
+			"STATS",
+			"    Show memory usage details.",
+			"DOCTOR",
+			"    Return memory problems reports.",
+		)
+	default:
+		return returnUnknownSubcommandError("MEMORY", args[0])
+	}
+}
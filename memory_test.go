@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMemoryUsageCommand(t *testing.T) {
+	defer teardown()
+
+	usage := func(key string) int {
+		result := memoryCommand(testContext, []string{"USAGE", key})
+
+		number, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(result, ":"), "\r\n"))
+		if err != nil {
+			t.Fatalf("memoryCommand([]string{\"USAGE\", %q}) = %s; want an integer", key, result)
+		}
+
+		return number
+	}
+
+	// Test with values of different lengths
+	setCommand(testContext, []string{"short", strings.Repeat("a", 10)})
+	setCommand(testContext, []string{"long", strings.Repeat("a", 1000)})
+
+	if short, long := usage("short"), usage("long"); long-short < 990 {
+		t.Errorf("MEMORY USAGE = %d for 10 bytes and %d for 1000 bytes; want a difference of at least 990", short, long)
+	}
+
+	// Test with an expire time
+	setCommand(testContext, []string{"volatile", strings.Repeat("a", 10), "EX", "100"})
+	if volatile, short := usage("volatile"), usage("short"); volatile <= short {
+		t.Errorf("MEMORY USAGE = %d with an expire time; want more than %d", volatile, short)
+	}
+
+	// Test with integers, stored in the object header, or shared for the small ones
+	setCommand(testContext, []string{"shared", "42"})
+	setCommand(testContext, []string{"number", "123456789"})
+	setCommand(testContext, []string{"digits", "0123456789"})
+
+	if shared, number, digits := usage("shared"), usage("number"), usage("digits"); shared >= number || number >= digits {
+		t.Errorf("MEMORY USAGE = %d for a shared integer, %d for an integer and %d for a string of digits; want increasing usages", shared, number, digits)
+	}
+
+	// Test with SAMPLES
+	result := memoryCommand(testContext, []string{"USAGE", "short", "SAMPLES", "5"})
+	if result != returnInteger(usage("short")) {
+		t.Errorf("memoryCommand([]string{\"USAGE\", \"short\", \"SAMPLES\", \"5\"}) = %s; want %d", result, usage("short"))
+	}
+
+	result = memoryCommand(testContext, []string{"USAGE", "short", "SAMPLES", "many"})
+	if result != "-ERR value is not an integer or out of range\r\n" {
+		t.Errorf("memoryCommand([]string{\"USAGE\", \"short\", \"SAMPLES\", \"many\"}) = %s; want -ERR value is not an integer or out of range\\r\\n", result)
+	}
+
+	// Test with a missing key
+	result = memoryCommand(testContext, []string{"USAGE", "non-existing-key"})
+	if result != nullReply {
+		t.Errorf("memoryCommand([]string{\"USAGE\", \"non-existing-key\"}) = %s; want $-1\\r\\n", result)
+	}
+}
+
+// memoryStatsField returns the value of the field in the reply of MEMORY STATS.
+func memoryStatsField(t *testing.T, field string) Value {
+	t.Helper()
+
+	result := memoryCommand(testContext, []string{"STATS"})
+
+	reply, err := DecodeRESP(bufio.NewReader(strings.NewReader(result)))
+	if err != nil {
+		t.Fatalf("DecodeRESP(MEMORY STATS) failed: %v", err)
+	}
+
+	stats := reply.Array()
+	for i := 0; i+1 < len(stats); i += 2 {
+		if stats[i].String() == field {
+			return stats[i+1]
+		}
+	}
+
+	t.Fatalf("MEMORY STATS = %q; want a %s field", result, field)
+
+	return Value{}
+}
+
+func TestMemoryStatsCommand(t *testing.T) {
+	defer teardown()
+
+	before, err := memoryStatsField(t, "dataset.bytes").Int()
+	if err != nil {
+		t.Fatalf("MEMORY STATS dataset.bytes is not an integer: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		setCommand(testContext, []string{"key" + strconv.Itoa(i), strings.Repeat("a", 1000)})
+	}
+
+	after, _ := memoryStatsField(t, "dataset.bytes").Int()
+	if after-before < 100*1000 {
+		t.Errorf("MEMORY STATS dataset.bytes = %d before and %d after storing 100kb; want it to grow by at least 100000", before, after)
+	}
+
+	if keys, _ := memoryStatsField(t, "keys.count").Int(); keys < 100 {
+		t.Errorf("MEMORY STATS keys.count = %d; want at least 100", keys)
+	}
+
+	db := memoryStatsField(t, "db."+strconv.Itoa(testServer.selectedDB)).Array()
+	if len(db) != 4 || db[0].String() != "keys" {
+		t.Fatalf("MEMORY STATS db.%d = %v; want its keys and expires", testServer.selectedDB, db)
+	}
+
+	if keys, _ := db[1].Int(); keys != 100 {
+		t.Errorf("MEMORY STATS db.%d keys = %d; want 100", testServer.selectedDB, keys)
+	}
+
+	result := memoryCommand(testContext, []string{"STATS", "extra"})
+	if result != "-ERR wrong number of arguments for 'MEMORY STATS' command\r\n" {
+		t.Errorf("memoryCommand([]string{\"STATS\", \"extra\"}) = %s; want a wrong number of arguments error", result)
+	}
+}
+
+func TestMemoryDoctorCommand(t *testing.T) {
+	defer teardown()
+
+	setCommand(testContext, []string{"key", "value"})
+
+	result := memoryCommand(testContext, []string{"DOCTOR"})
+	if !strings.HasPrefix(result, "$") || len(result) <= len("$0\r\n\r\n") {
+		t.Errorf("memoryCommand([]string{\"DOCTOR\"}) = %q; want a non-empty bulk string", result)
+	}
+
+	report := memoryReport{allocated: 1000, dataset: 100, maxMemory: 1000, keys: []int{10}, expires: []int{0}}
+	if advice := memoryDoctor(report); !strings.Contains(advice, "maxmemory") || !strings.Contains(advice, "half") {
+		t.Errorf("memoryDoctor(%+v) = %q; want advice on maxmemory and on the dataset share", report, advice)
+	}
+
+	report = memoryReport{allocated: 1000, dataset: 800, keys: []int{10}, expires: []int{0}}
+	if advice := memoryDoctor(report); !strings.HasPrefix(advice, "No memory issue") {
+		t.Errorf("memoryDoctor(%+v) = %q; want no issue", report, advice)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// sensitiveConfigParameters are the parameters whose values CONFIG SET must not leak to the monitors and the slowlog.
+var sensitiveConfigParameters = map[string]bool{"requirepass": true}
+
+// redactArgs returns the command followed by its arguments, as fed to the monitors and the slowlog,
+// with the secrets replaced by "(redacted)": the password of AUTH and the value of a sensitive CONFIG SET parameter.
+func redactArgs(command string, args []string) []string {
+	redacted := append([]string{command}, args...)
+
+	switch {
+	case command == "AUTH":
+		for i := 1; i < len(redacted); i++ {
+			redacted[i] = "(redacted)"
+		}
+	case command == "CONFIG" && len(args) == 3 && strings.EqualFold(args[0], "SET") && sensitiveConfigParameters[strings.ToLower(args[1])]:
+		redacted[3] = "(redacted)"
+	}
+
+	return redacted
+}
+
+// A Handler executes a command issued by a client and returns its reply.
+type Handler func(c *client, command string, args []string) string
+
+// A Middleware wraps a Handler to run code around the execution of every known command.
+// It may return a reply without calling next to short-circuit the command.
+type Middleware func(next Handler) Handler
+
+// Use appends the middleware to the chain wrapping every command.
+// Middlewares run in the order they were added, and apply to connections opened afterwards.
+func (server *RedisServer) Use(middleware Middleware) {
+	server.middlewares = append(server.middlewares, middleware)
+}
+
+// chain wraps the handler with the server middlewares.
+func (server *RedisServer) chain(handler Handler) Handler {
+	for i := len(server.middlewares) - 1; i >= 0; i-- {
+		handler = server.middlewares[i](handler)
+	}
+
+	return handler
+}
+
+// statsMiddleware counts the processed commands.
+func (server *RedisServer) statsMiddleware(next Handler) Handler {
+	return func(c *client, command string, args []string) string {
+		server.stats.totalCommandsProcessed.Add(1)
+
+		return next(c, command, args)
+	}
+}
+
+// monitorMiddleware feeds the commands to the monitors, with their secrets redacted.
+// Connection commands, such as MONITOR itself, are not fed.
+func (server *RedisServer) monitorMiddleware(next Handler) Handler {
+	return func(c *client, command string, args []string) string {
+		if spec, _ := lookupCommand(command); spec.connectionHandler == nil {
+			server.feedMonitors(c, redactArgs(command, args))
+		}
+
+		return next(c, command, args)
+	}
+}
+
+// slowlogMiddleware times the commands and records the slow ones in the slowlog, with their secrets redacted,
+// their latency spikes in the latency monitor, and every duration in the per-command latency histograms.
+func (server *RedisServer) slowlogMiddleware(next Handler) Handler {
+	return func(c *client, command string, args []string) string {
+		start := time.Now()
+		response := next(c, command, args)
+		duration := time.Since(start)
+
+		threshold, maxLen := server.config.slowlogLimits()
+		server.slowlog.Record(redactArgs(command, args), duration, threshold, maxLen)
+
+		event := "command"
+		if spec, _ := lookupCommand(command); spec.hasFlag("fast") {
+			event = "fast-command"
+		}
+
+		server.latency.Record(event, duration, server.config.latencyThreshold())
+		server.commandLatency.Record(command, duration)
+
+		return response
+	}
+}
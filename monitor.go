@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerCommand("MONITOR", CommandSpec{connectionHandler: monitorCommand, arity: 1, flags: []string{"admin"}})
+}
+
+// monitorCommand registers the client to receive every command processed by the server.
+func monitorCommand(c *client, _ []string) string {
+	c.server.addMonitor(c)
+
+	return returnSimpleString("OK")
+}
+
+// addMonitor registers the client to receive every command processed by the server.
+func (server *RedisServer) addMonitor(c *client) {
+	server.monitorsMutex.Lock()
+	defer server.monitorsMutex.Unlock()
+
+	if server.monitors == nil {
+		server.monitors = make(map[*client]bool)
+	}
+
+	server.monitors[c] = true
+}
+
+// removeMonitor unregisters the client from the monitors.
+func (server *RedisServer) removeMonitor(c *client) {
+	server.monitorsMutex.Lock()
+	defer server.monitorsMutex.Unlock()
+
+	delete(server.monitors, c)
+}
+
+// feedMonitors sends the command issued by the given client to every other monitor.
+// Only known commands are fed, so the arguments of unknown commands never leak.
+func (server *RedisServer) feedMonitors(source *client, args []string) {
+	server.monitorsMutex.RLock()
+	defer server.monitorsMutex.RUnlock()
+
+	if len(server.monitors) == 0 {
+		return
+	}
+
+	line := formatMonitorLine(time.Now(), server.commandDB(source).id, source.address(), args)
+
+	for monitor := range server.monitors {
+		if monitor == source {
+			continue
+		}
+
+		if err := monitor.write(line); err != nil {
+			server.logger.Debugf("Error writing to monitor: %s", err)
+		}
+	}
+}
+
+// formatMonitorLine returns the RESP simple string describing a processed command,
+// in the form: +timestamp [db address] "command" "arg" ...
+func formatMonitorLine(now time.Time, db int, address string, args []string) string {
+	quotedArgs := make([]string, 0, len(args))
+	for _, arg := range args {
+		quotedArgs = append(quotedArgs, strconv.Quote(arg))
+	}
+
+	timestamp := strconv.FormatFloat(float64(now.UnixMicro())/1e6, 'f', 6, 64)
+
+	return returnSimpleString(timestamp + " [" + strconv.Itoa(db) + " " + address + "] " + strings.Join(quotedArgs, " "))
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	errOutputBufferOverflow = errors.New("output buffer limit reached")
+	errClientClosed         = errors.New("client closed")
+)
+
+// write writes the response to the client connection.
+// The response is written without copying it, since connections never modify what they write.
+// Once the writer of the client is started, the response is queued instead.
+func (c *client) write(response string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.outboxReady != nil {
+		return c.enqueue(response)
+	}
+
+	_, err := c.conn.Write(stringToBytes(response))
+
+	return err
+}
+
+// enqueue queues the response in the client outbox without blocking,
+// and disconnects the client if its pending output goes over the output buffer limit of its class.
+// It must be called with the client mutex held.
+func (c *client) enqueue(response string) error {
+	if c.outboxClosed {
+		return errClientClosed
+	}
+
+	c.outbox = append(c.outbox, response)
+	c.pendingOutput += int64(len(response))
+
+	if c.server.config.outputBufferLimit(c.class()).exceeded(c.pendingOutput, &c.softLimitSince, time.Now()) {
+		c.server.logger.Warningf("Disconnecting client %s: %s", c.address(), errOutputBufferOverflow)
+		c.closeOutbox()
+		c.conn.Close()
+
+		return errOutputBufferOverflow
+	}
+
+	c.outboxReady.Signal()
+
+	return nil
+}
+
+// class returns the class of the client for the output buffer limits: pubsub or normal.
+// It must be called with the client mutex held.
+func (c *client) class() string {
+	if c.pubsub {
+		return "pubsub"
+	}
+
+	return "normal"
+}
+
+// setPubsub records whether the client is subscribed to any channel.
+func (c *client) setPubsub(pubsub bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.pubsub = pubsub
+}
+
+// startWriter routes the writes of the client through an outbox drained by a dedicated goroutine.
+// It does nothing if the writer is already started, or if the client has no connection.
+func (c *client) startWriter() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.outboxReady != nil || c.conn == nil {
+		return
+	}
+
+	c.outboxReady = sync.NewCond(&c.mutex)
+	c.writerDone = make(chan struct{})
+
+	go c.drain()
+}
+
+// drain writes the queued responses to the connection in order, until the outbox is closed and empty.
+// Once a write fails, the connection and the outbox are closed.
+func (c *client) drain() {
+	defer close(c.writerDone)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for {
+		for len(c.outbox) == 0 && !c.outboxClosed {
+			c.outboxReady.Wait()
+		}
+
+		if len(c.outbox) == 0 {
+			return
+		}
+
+		response := c.outbox[0]
+		c.outbox[0] = ""
+		c.outbox = c.outbox[1:]
+
+		c.mutex.Unlock()
+		_, err := c.conn.Write(stringToBytes(response))
+		c.mutex.Lock()
+
+		c.pendingOutput -= int64(len(response))
+
+		if err != nil {
+			c.server.logger.Debugf("Error writing to connection: %s", err)
+			c.closeOutbox()
+			c.conn.Close()
+		}
+	}
+}
+
+// closeOutbox stops queuing responses, and discards those still pending.
+// It must be called with the client mutex held.
+func (c *client) closeOutbox() {
+	for _, response := range c.outbox {
+		c.pendingOutput -= int64(len(response))
+	}
+
+	c.outboxClosed = true
+	c.outbox = nil
+	c.outboxReady.Signal()
+}
+
+// stopWriter stops queuing responses and waits for the queued ones to be written.
+func (c *client) stopWriter() {
+	c.mutex.Lock()
+
+	done := c.writerDone
+	if c.outboxReady != nil {
+		c.outboxClosed = true
+		c.outboxReady.Signal()
+	}
+
+	c.mutex.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setOutputBufferLimit changes the output buffer limits of the test server until the end of the test.
+func setOutputBufferLimit(t *testing.T, value string) {
+	t.Helper()
+
+	cfg := testServer.config
+
+	cfg.mutex.RLock()
+	normal, pubsub := cfg.normalOutputBufferLimit, cfg.pubsubOutputBufferLimit
+	cfg.mutex.RUnlock()
+
+	if result := configCommand(testContext, []string{"SET", "client-output-buffer-limit", value}); result != okReply {
+		t.Fatalf("CONFIG SET client-output-buffer-limit %q = %q; want +OK\r\n", value, result)
+	}
+
+	t.Cleanup(func() {
+		cfg.mutex.Lock()
+		defer cfg.mutex.Unlock()
+
+		cfg.normalOutputBufferLimit, cfg.pubsubOutputBufferLimit = normal, pubsub
+	})
+}
+
+func TestOutputBufferLimitExceeded(t *testing.T) {
+	limit := outputBufferLimit{hard: 100, soft: 50, softSeconds: 10}
+	now := time.Now()
+
+	var softSince time.Time
+
+	if limit.exceeded(40, &softSince, now) {
+		t.Errorf("exceeded(40) = true; want false under the soft limit")
+	}
+
+	if !limit.exceeded(101, &softSince, now) {
+		t.Errorf("exceeded(101) = false; want true over the hard limit")
+	}
+
+	if limit.exceeded(60, &softSince, now) {
+		t.Errorf("exceeded(60) = true; want false when just going over the soft limit")
+	}
+
+	if limit.exceeded(60, &softSince, now.Add(10*time.Second)) {
+		t.Errorf("exceeded(60) after 10s = true; want false until the soft seconds are elapsed")
+	}
+
+	if !limit.exceeded(60, &softSince, now.Add(11*time.Second)) {
+		t.Errorf("exceeded(60) after 11s = false; want true once over the soft limit for too long")
+	}
+
+	// Going back under the soft limit resets the timer
+	limit.exceeded(40, &softSince, now.Add(12*time.Second))
+	if limit.exceeded(60, &softSince, now.Add(13*time.Second)) {
+		t.Errorf("exceeded(60) after going back under the soft limit = true; want false")
+	}
+
+	if (outputBufferLimit{}).exceeded(1<<40, &softSince, now) {
+		t.Errorf("exceeded without limit = true; want false")
+	}
+}
+
+func TestOutputBufferLimitConfig(t *testing.T) {
+	setOutputBufferLimit(t, "normal 1mb 512kb 30")
+
+	result := configCommand(testContext, []string{"GET", "client-output-buffer-limit"})
+	if want := returnArray([]string{"client-output-buffer-limit", "normal 1048576 524288 30 pubsub 33554432 8388608 60"}); result != want {
+		t.Errorf("CONFIG GET client-output-buffer-limit = %q; want %q", result, want)
+	}
+
+	errorTests := []struct {
+		value string
+		want  string
+	}{
+		{"normal 1mb 512kb", "Wrong number of arguments in buffer limit configuration."},
+		{"replica 1mb 512kb 30", "Invalid client class specified in buffer limit configuration."},
+		{"normal 1mb soft 30", "Error in hard, soft or soft_seconds setting in buffer limit configuration."},
+		{"pubsub 0 0 0 normal 1mb 512kb -1", "Error in hard, soft or soft_seconds setting in buffer limit configuration."},
+	}
+
+	for _, test := range errorTests {
+		result := configCommand(testContext, []string{"SET", "client-output-buffer-limit", test.value})
+		if want := "-ERR CONFIG SET failed (possibly related to argument 'client-output-buffer-limit') - " + test.want + "\r\n"; result != want {
+			t.Errorf("CONFIG SET client-output-buffer-limit %q = %q; want %q", test.value, result, want)
+		}
+	}
+
+	// A failed CONFIG SET leaves every class unchanged
+	if result, want := configCommand(testContext, []string{"GET", "client-output-buffer-limit"}), returnArray([]string{"client-output-buffer-limit", "normal 1048576 524288 30 pubsub 33554432 8388608 60"}); result != want {
+		t.Errorf("CONFIG GET client-output-buffer-limit = %q; want %q", result, want)
+	}
+}
+
+func TestClientDisconnectedOverOutputBufferLimit(t *testing.T) {
+	testContext.db().Set("big-reply", strings.Repeat("x", 4096))
+	defer teardown()
+
+	setOutputBufferLimit(t, "normal 1kb 0 0")
+
+	// The client never reads the reply, which is over the hard limit as soon as it is queued
+	reader := newTestClient(t)
+	if _, err := reader.conn.Write([]byte("*2\r\n$3\r\nGET\r\n$9\r\nbig-reply\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	reader.conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	if reply, err := readReply(reader.reader); !errors.Is(err, io.EOF) {
+		t.Errorf("reading the reply = %q, %v; want %v", reply, err, io.EOF)
+	}
+
+	// Replies under the limit are still written
+	client := newTestClient(t)
+	if result := client.send(t, "PING"); result != "+PONG\r\n" {
+		t.Errorf("PING = %q; want +PONG\\r\\n", result)
+	}
+}
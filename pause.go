@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A clientPause holds the commands of the clients until its deadline, as set with CLIENT PAUSE.
+// If all is false, only the write commands are held.
+// changed is closed, and replaced, whenever the pause is extended or lifted, to wake the held clients.
+type clientPause struct {
+	deadline time.Time
+	all      bool
+	changed  chan struct{}
+	mutex    sync.Mutex
+}
+
+// Pause holds the commands until the deadline, or only the write ones if all is false.
+// A pause in progress is never shortened nor relaxed: the latest deadline and the strictest mode win.
+func (p *clientPause) Pause(deadline time.Time, all bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if time.Now().Before(p.deadline) {
+		if p.deadline.After(deadline) {
+			deadline = p.deadline
+		}
+
+		all = all || p.all
+	}
+
+	p.deadline, p.all = deadline, all
+	p.notify()
+}
+
+// Unpause lifts the pause, and releases the held commands.
+func (p *clientPause) Unpause() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.deadline, p.all = time.Time{}, false
+	p.notify()
+}
+
+// notify wakes up the clients waiting for the pause to change.
+// It must be called with the mutex held.
+func (p *clientPause) notify() {
+	if p.changed != nil {
+		close(p.changed)
+	}
+
+	p.changed = make(chan struct{})
+}
+
+// Wait blocks while the pause holds commands of the kind given by write.
+// It returns false if the context is canceled meanwhile.
+func (p *clientPause) Wait(ctx context.Context, write bool) bool {
+	for {
+		p.mutex.Lock()
+		remaining := time.Until(p.deadline)
+		held := remaining > 0 && (write || p.all)
+		changed := p.changed
+		p.mutex.Unlock()
+
+		if !held {
+			return true
+		}
+
+		timer := time.NewTimer(remaining)
+
+		select {
+		case <-timer.C:
+		case <-changed:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		}
+	}
+}
+
+// isWriteRequest reports whether the request may write to the databases:
+// either a write command, or an EXEC whose transaction holds one.
+func isWriteRequest(c *client, command string) bool {
+	if command == "EXEC" {
+		for _, queued := range c.transaction {
+			if spec, _ := lookupCommand(queued.command); spec.hasFlag("write") {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	spec, _ := lookupCommand(command)
+
+	return spec.hasFlag("write")
+}
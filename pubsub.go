@@ -0,0 +1,179 @@
+package main
+
+import "sort"
+
+func init() {
+	registerCommand("SUBSCRIBE", CommandSpec{connectionHandler: subscribeCommand, arity: -2, flags: []string{"pubsub", "loading"}})
+	registerCommand("UNSUBSCRIBE", CommandSpec{connectionHandler: unsubscribeCommand, arity: -1, flags: []string{"pubsub", "loading"}})
+	registerCommand("PUBLISH", CommandSpec{handler: publishCommand, arity: 3, flags: []string{"pubsub", "fast", "loading"}})
+}
+
+// allowedInSubscribeMode reports whether the command can be issued by a client subscribed to a channel.
+func allowedInSubscribeMode(command string) bool {
+	switch command {
+	case "SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE", "PING", "QUIT", "RESET":
+		return true
+	}
+
+	return false
+}
+
+// pubsubFrame returns a RESP array made of the kind of the frame, the channel and the count.
+// An empty channel is encoded as a null bulk string.
+func pubsubFrame(kind string, channel string, count int) string {
+	frame := "*3\r\n" + returnBulkString(kind)
+
+	if channel == "" {
+		frame += returnNullBulkString()
+	} else {
+		frame += returnBulkString(channel)
+	}
+
+	return frame + returnInteger(count)
+}
+
+// messageFrame returns the RESP array delivering the message published on the channel.
+func messageFrame(channel string, message string) string {
+	return "*3\r\n" + returnBulkString("message") + returnBulkString(channel) + returnBulkString(message)
+}
+
+// subscribe registers the client as a subscriber of the channel.
+// It returns the number of channels the client is subscribed to.
+// If confirm is true, the subscribe confirmation is queued before releasing the registry,
+// so that it always reaches the client ahead of the messages published on the channel.
+func (server *RedisServer) subscribe(c *client, channel string, confirm bool) int {
+	server.pubsubMutex.Lock()
+	defer server.pubsubMutex.Unlock()
+
+	if server.channels == nil {
+		server.channels = make(map[string]map[*client]bool)
+	}
+
+	if server.channels[channel] == nil {
+		server.channels[channel] = make(map[*client]bool)
+	}
+
+	if c.channels == nil {
+		c.channels = make(map[string]bool)
+	}
+
+	server.channels[channel][c] = true
+	c.channels[channel] = true
+	c.setPubsub(true)
+
+	if confirm {
+		if err := c.write(pubsubFrame("subscribe", channel, len(c.channels))); err != nil {
+			server.logger.Debugf("Error writing to subscriber: %s", err)
+		}
+	}
+
+	return len(c.channels)
+}
+
+// unsubscribe unregisters the client as a subscriber of the channel.
+// It returns the number of channels the client is still subscribed to.
+func (server *RedisServer) unsubscribe(c *client, channel string) int {
+	server.pubsubMutex.Lock()
+	defer server.pubsubMutex.Unlock()
+
+	delete(server.channels[channel], c)
+	if len(server.channels[channel]) == 0 {
+		delete(server.channels, channel)
+	}
+
+	delete(c.channels, channel)
+	c.setPubsub(len(c.channels) > 0)
+
+	return len(c.channels)
+}
+
+// subscribedChannels returns the channels the client is subscribed to, sorted by name.
+func (server *RedisServer) subscribedChannels(c *client) []string {
+	server.pubsubMutex.RLock()
+	defer server.pubsubMutex.RUnlock()
+
+	channels := make([]string, 0, len(c.channels))
+	for channel := range c.channels {
+		channels = append(channels, channel)
+	}
+
+	sort.Strings(channels)
+
+	return channels
+}
+
+// unsubscribeAll unregisters the client from every channel.
+func (server *RedisServer) unsubscribeAll(c *client) {
+	for _, channel := range server.subscribedChannels(c) {
+		server.unsubscribe(c, channel)
+	}
+}
+
+// publish queues the message in the outbox of every subscriber of the channel, without blocking.
+// A subscriber whose outbox is full is disconnected instead.
+// It returns the number of subscribers that received the message.
+func (server *RedisServer) publish(channel string, message string) int {
+	server.pubsubMutex.RLock()
+	defer server.pubsubMutex.RUnlock()
+
+	frame := messageFrame(channel, message)
+	receivers := 0
+
+	for subscriber := range server.channels[channel] {
+		if err := subscriber.write(frame); err != nil {
+			server.logger.Debugf("Error writing to subscriber: %s", err)
+			continue
+		}
+
+		receivers++
+	}
+
+	return receivers
+}
+
+// subscribeCommand subscribes the client to the given channels.
+// It replies with a subscribe confirmation per channel, carrying the number of subscribed channels.
+// Outside of a transaction, the confirmations are written by the subscription itself,
+// so that no message can overtake them.
+func subscribeCommand(c *client, args []string) string {
+	confirm := !c.inTransaction && c.conn != nil
+	reply := ""
+
+	for _, channel := range args {
+		count := c.server.subscribe(c, channel, confirm)
+		if !confirm {
+			reply += pubsubFrame("subscribe", channel, count)
+		}
+	}
+
+	return reply
+}
+
+// unsubscribeCommand unsubscribes the client from the given channels, or from all of them if none is given.
+// It replies with an unsubscribe confirmation per channel, carrying the number of channels left.
+// If the client is not subscribed to any channel, it replies with a single confirmation without channel.
+func unsubscribeCommand(c *client, args []string) string {
+	channels := args
+	if len(channels) == 0 {
+		channels = c.server.subscribedChannels(c)
+	}
+
+	if len(channels) == 0 {
+		return pubsubFrame("unsubscribe", "", 0)
+	}
+
+	reply := ""
+
+	for _, channel := range channels {
+		count := c.server.unsubscribe(c, channel)
+		reply += pubsubFrame("unsubscribe", channel, count)
+	}
+
+	return reply
+}
+
+// publishCommand posts the message to the channel.
+// It returns the number of clients that received the message.
+func publishCommand(ctx *commandContext, args []string) string {
+	return returnInteger(ctx.server.publish(args[0], args[1]))
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	subscriber := newTestClient(t)
+
+	result := subscriber.send(t, "SUBSCRIBE", "first", "second")
+	if want := pubsubFrame("subscribe", "first", 1); result != want {
+		t.Errorf("SUBSCRIBE first second = %q; want %q", result, want)
+	}
+
+	result = subscriber.receive(t)
+	if want := "*3\r\n$9\r\nsubscribe\r\n$6\r\nsecond\r\n:2\r\n"; result != want {
+		t.Errorf("SUBSCRIBE first second = %q; want %q", result, want)
+	}
+
+	// Subscribing twice to the same channel does not change the count
+	result = subscriber.send(t, "SUBSCRIBE", "first")
+	if want := pubsubFrame("subscribe", "first", 2); result != want {
+		t.Errorf("SUBSCRIBE first = %q; want %q", result, want)
+	}
+
+	result = subscriber.send(t, "UNSUBSCRIBE", "first")
+	if want := "*3\r\n$11\r\nunsubscribe\r\n$5\r\nfirst\r\n:1\r\n"; result != want {
+		t.Errorf("UNSUBSCRIBE first = %q; want %q", result, want)
+	}
+
+	result = subscriber.send(t, "UNSUBSCRIBE")
+	if want := pubsubFrame("unsubscribe", "second", 0); result != want {
+		t.Errorf("UNSUBSCRIBE = %q; want %q", result, want)
+	}
+
+	// Unsubscribing from all channels without any subscription replies with a null channel
+	result = subscriber.send(t, "UNSUBSCRIBE")
+	if want := "*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n"; result != want {
+		t.Errorf("UNSUBSCRIBE = %q; want %q", result, want)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	subscriber := newTestClient(t)
+	subscriber.send(t, "SUBSCRIBE", "channel")
+
+	messages := make(chan string)
+	go func() {
+		reply, err := readReply(subscriber.reader)
+		if err != nil {
+			t.Errorf("error reading message: %s", err)
+		}
+
+		messages <- reply
+	}()
+
+	publisher := newTestClient(t)
+
+	result := publisher.send(t, "PUBLISH", "channel", "hello")
+	if result != oneReply {
+		t.Errorf("PUBLISH channel hello = %q; want :1\\r\\n", result)
+	}
+
+	if message, want := <-messages, messageFrame("channel", "hello"); message != want {
+		t.Errorf("message = %q; want %q", message, want)
+	}
+
+	result = publisher.send(t, "PUBLISH", "other-channel", "hello")
+	if result != zeroReply {
+		t.Errorf("PUBLISH other-channel hello = %q; want :0\\r\\n", result)
+	}
+}
+
+func TestUnsubscribeOnClose(t *testing.T) {
+	subscriber := newTestClient(t)
+	subscriber.send(t, "SUBSCRIBE", "closing-channel")
+	subscriber.conn.Close()
+
+	subscribers := func() int {
+		testServer.pubsubMutex.RLock()
+		defer testServer.pubsubMutex.RUnlock()
+
+		return len(testServer.channels["closing-channel"])
+	}
+
+	if !waitFor(func() bool { return subscribers() == 0 }) {
+		t.Errorf("subscribers of closing-channel = %d after close; want 0", subscribers())
+	}
+}
+
+func TestSubscribeModeRestriction(t *testing.T) {
+	subscriber := newTestClient(t)
+	subscriber.send(t, "SUBSCRIBE", "channel")
+
+	result := subscriber.send(t, "GET", "key")
+	want := "-ERR Can't execute 'GET': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context\r\n"
+	if result != want {
+		t.Errorf("GET key = %q; want %q", result, want)
+	}
+
+	result = subscriber.send(t, "PING")
+	if result != "+PONG\r\n" {
+		t.Errorf("PING = %q; want +PONG\\r\\n", result)
+	}
+
+	// Leaving subscribe mode allows every command again
+	subscriber.send(t, "UNSUBSCRIBE")
+
+	result = subscriber.send(t, "GET", "key")
+	if result != nullReply {
+		t.Errorf("GET key = %q; want $-1\\r\\n", result)
+	}
+}
+
+func TestSlowSubscriberDisconnected(t *testing.T) {
+	slow := newTestClient(t)
+	slow.send(t, "SUBSCRIBE", "busy-channel")
+
+	fast := newTestClient(t)
+	fast.send(t, "SUBSCRIBE", "busy-channel")
+
+	publisher := newTestClient(t)
+
+	setOutputBufferLimit(t, "pubsub 1kb 0 0")
+
+	// The slow subscriber never reads: once its pending output goes over the limit, it is disconnected
+	// while the fast subscriber keeps receiving every message, in order.
+	for i := 0; i < 100; i++ {
+		message := strconv.Itoa(i)
+		publisher.send(t, "PUBLISH", "busy-channel", message)
+
+		if result, want := fast.receive(t), messageFrame("busy-channel", message); result != want {
+			t.Fatalf("message %d = %q; want %q", i, result, want)
+		}
+	}
+
+	result := publisher.send(t, "PUBLISH", "busy-channel", "after")
+	if result != oneReply {
+		t.Errorf("PUBLISH busy-channel after = %q; want :1\\r\\n", result)
+	}
+
+	if result, want := fast.receive(t), messageFrame("busy-channel", "after"); result != want {
+		t.Errorf("message = %q; want %q", result, want)
+	}
+
+	// The messages written before the disconnection can still be read, then the connection is closed.
+	slow.conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	for {
+		_, err := readReply(slow.reader)
+		if err == nil {
+			continue
+		}
+
+		if !errors.Is(err, io.EOF) {
+			t.Errorf("reading from the slow subscriber = %v; want %v", err, io.EOF)
+		}
+
+		break
+	}
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
+	"unsafe"
 )
 
 // A Type represents a Value type.
@@ -12,15 +15,98 @@ type Type byte
 
 const (
 	SimpleString Type = '+'
+	Error        Type = '-'
+	Integer      Type = ':'
 	BulkString   Type = '$'
 	Array        Type = '*'
 )
 
 // A Value represents the data of a valid RESP type.
+// null reports whether the Value is a null bulk string or a null array.
 type Value struct {
 	typ   Type
 	bytes []byte
 	array []Value
+	null  bool
+}
+
+// NewSimpleString returns a simple string Value, which must not contain CR or LF.
+func NewSimpleString(s string) Value {
+	return Value{typ: SimpleString, bytes: []byte(s)}
+}
+
+// NewError returns an error Value carrying the message, such as "ERR syntax error".
+func NewError(message string) Value {
+	return Value{typ: Error, bytes: []byte(message)}
+}
+
+// NewInteger returns an integer Value.
+func NewInteger(i int64) Value {
+	return Value{typ: Integer, bytes: strconv.AppendInt(nil, i, 10)}
+}
+
+// NewBulkString returns a bulk string Value, which may hold any bytes.
+func NewBulkString(s string) Value {
+	return Value{typ: BulkString, bytes: []byte(s)}
+}
+
+// NewArray returns an array Value made of the given values.
+func NewArray(values ...Value) Value {
+	if values == nil {
+		values = []Value{}
+	}
+
+	return Value{typ: Array, array: values}
+}
+
+// NewNull returns a null bulk string Value, the usual reply for a missing key.
+func NewNull() Value {
+	return Value{typ: BulkString, null: true}
+}
+
+// NewNullArray returns a null array Value.
+func NewNullArray() Value {
+	return Value{typ: Array, null: true}
+}
+
+// DecoderLimits bounds the sizes accepted by the decoder,
+// so that a client cannot make the server allocate unbounded memory.
+// MaxBulkLength is the maximum length of a bulk string,
+// MaxMultibulkLength the maximum number of elements of an array,
+// and MaxLineLength the maximum length of a line, such as a simple string or a length header.
+type DecoderLimits struct {
+	MaxBulkLength      int64
+	MaxMultibulkLength int
+	MaxLineLength      int
+}
+
+// defaultMaxBulkLength, defaultMaxMultibulkLength and defaultMaxLineLength are the default limits of the decoder, as in Redis.
+const (
+	defaultMaxBulkLength      = 512 * 1024 * 1024
+	defaultMaxMultibulkLength = 1024 * 1024
+	defaultMaxLineLength      = 64 * 1024
+)
+
+// DefaultDecoderLimits are the limits used by DecodeRESP.
+var DefaultDecoderLimits = DecoderLimits{
+	MaxBulkLength:      defaultMaxBulkLength,
+	MaxMultibulkLength: defaultMaxMultibulkLength,
+	MaxLineLength:      defaultMaxLineLength,
+}
+
+// A protocolError is an error in the RESP data sent by a client,
+// which is reported to the client before closing its connection.
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "Protocol error: " + string(e)
+}
+
+// isProtocolError reports whether the error is a protocolError.
+func isProtocolError(err error) bool {
+	var target protocolError
+
+	return errors.As(err, &target)
 }
 
 // String converts Value to a string.
@@ -33,6 +119,31 @@ func (v Value) String() string {
 	return ""
 }
 
+// Int converts Value to an integer.
+// If Value is not an integer, an error is returned.
+func (v Value) Int() (int64, error) {
+	if v.typ != Integer {
+		return 0, errors.New("value is not an integer")
+	}
+
+	return strconv.ParseInt(string(v.bytes), 10, 64)
+}
+
+// Err converts Value to an error, whose message is the one sent by the peer.
+// If Value is not an error, nil is returned.
+func (v Value) Err() error {
+	if v.typ != Error {
+		return nil
+	}
+
+	return errors.New(string(v.bytes))
+}
+
+// IsNull reports whether Value is a null bulk string or a null array.
+func (v Value) IsNull() bool {
+	return v.null
+}
+
 // Array converts Value to an array.
 // If Value cannot be converted, an empty array is returned.
 func (v Value) Array() []Value {
@@ -43,6 +154,46 @@ func (v Value) Array() []Value {
 	return []Value{}
 }
 
+// Bytes returns the bytes of Value without copying them.
+// If Value cannot be converted, nil is returned.
+func (v Value) Bytes() []byte {
+	if v.typ == BulkString || v.typ == SimpleString {
+		return v.bytes
+	}
+
+	return nil
+}
+
+// Args converts Value to the arguments of a command.
+// Unlike StringArray, the strings share the bytes read by the decoder instead of copying them,
+// which is safe since the decoder allocates them for each value and never reuses them.
+func (v Value) Args() []string {
+	result := make([]string, 0, len(v.array))
+	for _, value := range v.Array() {
+		result = append(result, bytesToString(value.Bytes()))
+	}
+
+	return result
+}
+
+// bytesToString returns a string sharing the bytes, which must not be modified afterwards.
+func bytesToString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+
+	return unsafe.String(&b[0], len(b))
+}
+
+// stringToBytes returns the bytes of the string without copying them. They must not be modified.
+func stringToBytes(s string) []byte {
+	if s == "" {
+		return nil
+	}
+
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
+
 // StringArray converts Value to a string array.
 // If Value cannot be converted, an empty string slice is returned.
 func (v Value) StringArray() []string {
@@ -54,8 +205,52 @@ func (v Value) StringArray() []string {
 	return result
 }
 
-// DecodeRESP parses a RESP message and returns a RedisValue.
+// Encode serializes Value back to RESP, so that decoding the result gives Value again.
+func (v Value) Encode() []byte {
+	return v.appendEncoded(nil)
+}
+
+// appendEncoded appends the RESP serialization of Value to b and returns the extended slice.
+func (v Value) appendEncoded(b []byte) []byte {
+	b = append(b, byte(v.typ))
+
+	switch v.typ {
+	case BulkString:
+		if v.null {
+			return append(b, "-1\r\n"...)
+		}
+
+		b = strconv.AppendInt(b, int64(len(v.bytes)), 10)
+		b = append(b, "\r\n"...)
+		b = append(b, v.bytes...)
+	case Array:
+		if v.null {
+			return append(b, "-1\r\n"...)
+		}
+
+		b = strconv.AppendInt(b, int64(len(v.array)), 10)
+		b = append(b, "\r\n"...)
+
+		for _, value := range v.array {
+			b = value.appendEncoded(b)
+		}
+
+		return b
+	default:
+		b = append(b, v.bytes...)
+	}
+
+	return append(b, "\r\n"...)
+}
+
+// DecodeRESP parses a RESP message and returns a RedisValue, within the default limits.
 func DecodeRESP(byteStream *bufio.Reader) (Value, error) {
+	return DecodeRESPWithLimits(byteStream, DefaultDecoderLimits)
+}
+
+// DecodeRESPWithLimits parses a RESP message and returns a RedisValue.
+// Lengths exceeding the limits are rejected with a protocol error.
+func DecodeRESPWithLimits(byteStream *bufio.Reader, limits DecoderLimits) (Value, error) {
 	dataTypeByte, err := byteStream.ReadByte()
 	if err != nil {
 		return Value{}, err
@@ -63,19 +258,23 @@ func DecodeRESP(byteStream *bufio.Reader) (Value, error) {
 
 	switch string(dataTypeByte) {
 	case "+":
-		return decodeSimpleString(byteStream)
+		return decodeSimpleString(byteStream, limits)
+	case "-":
+		return decodeError(byteStream, limits)
+	case ":":
+		return decodeInteger(byteStream, limits)
 	case "$":
-		return decodeBulkString(byteStream)
+		return decodeBulkString(byteStream, limits)
 	case "*":
-		return decodeArray(byteStream)
+		return decodeArray(byteStream, limits)
 	}
 
 	return Value{}, fmt.Errorf("invalid RESP data type byte: %s", string(dataTypeByte))
 }
 
 // decodeSimpleString parses a simple string and returns a RedisValue.
-func decodeSimpleString(byteStream *bufio.Reader) (Value, error) {
-	readBytes, err := readUntilCRLF(byteStream)
+func decodeSimpleString(byteStream *bufio.Reader, limits DecoderLimits) (Value, error) {
+	readBytes, err := readUntilCRLF(byteStream, limits)
 	if err != nil {
 		return Value{}, err
 	}
@@ -86,9 +285,40 @@ func decodeSimpleString(byteStream *bufio.Reader) (Value, error) {
 	}, nil
 }
 
+// decodeError parses an error and returns a RedisValue.
+func decodeError(byteStream *bufio.Reader, limits DecoderLimits) (Value, error) {
+	readBytes, err := readUntilCRLF(byteStream, limits)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return Value{
+		typ:   Error,
+		bytes: readBytes,
+	}, nil
+}
+
+// decodeInteger parses an integer and returns a RedisValue.
+func decodeInteger(byteStream *bufio.Reader, limits DecoderLimits) (Value, error) {
+	readBytes, err := readUntilCRLF(byteStream, limits)
+	if err != nil {
+		return Value{}, err
+	}
+
+	if _, err := strconv.ParseInt(string(readBytes), 10, 64); err != nil {
+		return Value{}, protocolError("invalid integer")
+	}
+
+	return Value{
+		typ:   Integer,
+		bytes: readBytes,
+	}, nil
+}
+
 // decodeBulkString parses a bulk string and returns a RedisValue.
-func decodeBulkString(byteStream *bufio.Reader) (Value, error) {
-	readBytesForCount, err := readUntilCRLF(byteStream)
+// A length of -1 denotes the null bulk string.
+func decodeBulkString(byteStream *bufio.Reader, limits DecoderLimits) (Value, error) {
+	readBytesForCount, err := readUntilCRLF(byteStream, limits)
 	if err != nil {
 		return Value{}, fmt.Errorf("failed to read bulk string length: %w", err)
 	}
@@ -98,6 +328,17 @@ func decodeBulkString(byteStream *bufio.Reader) (Value, error) {
 		return Value{}, fmt.Errorf("failed to parse bulk string length: %w", err)
 	}
 
+	if count == -1 {
+		return Value{
+			typ:  BulkString,
+			null: true,
+		}, nil
+	}
+
+	if count < 0 || int64(count) > limits.MaxBulkLength {
+		return Value{}, protocolError("invalid bulk length")
+	}
+
 	readBytes := make([]byte, count+2)
 
 	if _, err := io.ReadFull(byteStream, readBytes); err != nil {
@@ -111,8 +352,9 @@ func decodeBulkString(byteStream *bufio.Reader) (Value, error) {
 }
 
 // decodeArray parses an array and returns a RedisValue.
-func decodeArray(byteStream *bufio.Reader) (Value, error) {
-	readBytesForCount, err := readUntilCRLF(byteStream)
+// A count of -1 denotes the null array, which is distinct from the empty array.
+func decodeArray(byteStream *bufio.Reader, limits DecoderLimits) (Value, error) {
+	readBytesForCount, err := readUntilCRLF(byteStream, limits)
 	if err != nil {
 		return Value{}, fmt.Errorf("failed to read bulk string length: %w", err)
 	}
@@ -122,10 +364,21 @@ func decodeArray(byteStream *bufio.Reader) (Value, error) {
 		return Value{}, fmt.Errorf("failed to parse bulk string length: %w", err)
 	}
 
+	if count == -1 {
+		return Value{
+			typ:  Array,
+			null: true,
+		}, nil
+	}
+
+	if count < 0 || count > limits.MaxMultibulkLength {
+		return Value{}, protocolError("invalid multibulk length")
+	}
+
 	array := []Value{}
 
 	for i := 1; i <= count; i++ {
-		value, err := DecodeRESP(byteStream)
+		value, err := DecodeRESPWithLimits(byteStream, limits)
 		if err != nil {
 			return Value{}, err
 		}
@@ -140,19 +393,31 @@ func decodeArray(byteStream *bufio.Reader) (Value, error) {
 }
 
 // readUntilCRLF reads bytes from a byte stream until it encounters a CRLF.
-func readUntilCRLF(byteStream *bufio.Reader) ([]byte, error) {
-	readBytes := []byte{}
+// Lines longer than the limit, or terminated by a bare LF, are rejected with a protocol error.
+func readUntilCRLF(byteStream *bufio.Reader, limits DecoderLimits) ([]byte, error) {
+	var readBytes []byte
 
 	for {
-		b, err := byteStream.ReadBytes('\n')
+		chunk, err := byteStream.ReadSlice('\n')
+
+		readBytes = append(readBytes, chunk...)
+		if len(readBytes) > limits.MaxLineLength+2 {
+			return nil, protocolError("too big inline request")
+		}
+
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+
 		if err != nil {
 			return nil, err
 		}
 
-		readBytes = append(readBytes, b...)
-		if len(readBytes) >= 2 && readBytes[len(readBytes)-2] == '\r' {
-			break
-		}
+		break
+	}
+
+	if len(readBytes) < 2 || readBytes[len(readBytes)-2] != '\r' {
+		return nil, protocolError("expected CRLF at the end of the line")
 	}
 
 	return readBytes[:len(readBytes)-2], nil
@@ -173,6 +438,11 @@ func returnNullBulkString() string {
 	return "$-1\r\n"
 }
 
+// returnNullArray returns a RESP null array.
+func returnNullArray() string {
+	return "*-1\r\n"
+}
+
 // returnBulkString returns a RESP bulk string.
 func returnBulkString(s string) string {
 	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
@@ -183,17 +453,60 @@ func returnInteger(i int) string {
 	return ":" + strconv.Itoa(i) + "\r\n"
 }
 
-// returnArray returns a RESP array.
+// returnArray returns a RESP array of bulk strings, where empty strings are encoded as nulls.
 func returnArray(a []string) string {
-	s := "*" + strconv.Itoa(len(a)) + "\r\n"
+	values := make([]*string, len(a))
+	for i := range a {
+		if a[i] != "" {
+			values[i] = &a[i]
+		}
+	}
+
+	return returnNullableArray(values)
+}
 
-	for _, v := range a {
-		if v == "" {
-			s += returnNullBulkString()
+// returnNullableArray returns a RESP array of bulk strings, where nil elements are encoded as nulls
+// and empty strings as empty bulk strings.
+// The reply is sized up front and written in a single allocation.
+func returnNullableArray(values []*string) string {
+	size := len("*\r\n") + countDigits(len(values))
+	for _, value := range values {
+		if value == nil {
+			size += len("$-1\r\n")
 		} else {
-			s += returnBulkString(v)
+			size += len("$\r\n\r\n") + countDigits(len(*value)) + len(*value)
 		}
 	}
 
-	return s
+	var builder strings.Builder
+
+	builder.Grow(size)
+	builder.WriteString("*")
+	builder.WriteString(strconv.Itoa(len(values)))
+	builder.WriteString("\r\n")
+
+	for _, value := range values {
+		if value == nil {
+			builder.WriteString("$-1\r\n")
+			continue
+		}
+
+		builder.WriteString("$")
+		builder.WriteString(strconv.Itoa(len(*value)))
+		builder.WriteString("\r\n")
+		builder.WriteString(*value)
+		builder.WriteString("\r\n")
+	}
+
+	return builder.String()
+}
+
+// countDigits returns the number of decimal digits of a non-negative integer.
+func countDigits(n int) int {
+	digits := 1
+	for ; n >= 10; n /= 10 {
+		digits++
+	}
+
+	return digits
 }
@@ -3,6 +3,8 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -93,3 +95,300 @@ func TestDecodeInvalidArray(t *testing.T) {
 		t.Errorf("expected error, got nil")
 	}
 }
+
+func TestDecodeInteger(t *testing.T) {
+	t.Parallel()
+
+	value, err := DecodeRESP(bufio.NewReader(bytes.NewBufferString(":1000\r\n")))
+
+	if err != nil {
+		t.Errorf("error decoding integer: %s", err)
+	}
+
+	if value.typ != Integer {
+		t.Errorf("expected Integer, got %v", value.typ)
+	}
+
+	if i, err := value.Int(); err != nil || i != 1000 {
+		t.Errorf("expected 1000, got %d (%v)", i, err)
+	}
+
+	_, err = DecodeRESP(bufio.NewReader(bytes.NewBufferString(":abc\r\n")))
+
+	if !isProtocolError(err) {
+		t.Errorf("expected protocol error, got %v", err)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	t.Parallel()
+
+	value, err := DecodeRESP(bufio.NewReader(bytes.NewBufferString("-ERR foo\r\n")))
+
+	if err != nil {
+		t.Errorf("error decoding error: %s", err)
+	}
+
+	if value.typ != Error {
+		t.Errorf("expected Error, got %v", value.typ)
+	}
+
+	if value.Err() == nil || value.Err().Error() != "ERR foo" {
+		t.Errorf("expected 'ERR foo', got %v", value.Err())
+	}
+
+	if _, err := value.Int(); err == nil {
+		t.Errorf("expected error converting an Error to an integer, got nil")
+	}
+}
+
+func TestDecodeOversizedBulkString(t *testing.T) {
+	t.Parallel()
+
+	_, err := DecodeRESP(bufio.NewReader(bytes.NewBufferString("$1000000000000\r\n")))
+
+	if !isProtocolError(err) || err.Error() != "Protocol error: invalid bulk length" {
+		t.Errorf("expected invalid bulk length error, got %v", err)
+	}
+
+	_, err = DecodeRESPWithLimits(bufio.NewReader(bytes.NewBufferString("$4\r\nabcd\r\n")), DecoderLimits{MaxBulkLength: 3})
+
+	if !isProtocolError(err) {
+		t.Errorf("expected protocol error, got %v", err)
+	}
+}
+
+func TestDecodeNegativeBulkString(t *testing.T) {
+	t.Parallel()
+
+	_, err := DecodeRESP(bufio.NewReader(bytes.NewBufferString("$-2\r\nab\r\n")))
+
+	if !isProtocolError(err) || err.Error() != "Protocol error: invalid bulk length" {
+		t.Errorf("expected invalid bulk length error, got %v", err)
+	}
+
+	_, err = DecodeRESP(bufio.NewReader(bytes.NewBufferString("*-2\r\n")))
+
+	if !isProtocolError(err) || err.Error() != "Protocol error: invalid multibulk length" {
+		t.Errorf("expected invalid multibulk length error, got %v", err)
+	}
+}
+
+func TestDecodeNullBulkString(t *testing.T) {
+	t.Parallel()
+
+	reader := bufio.NewReader(bytes.NewBufferString("$-1\r\n+next\r\n"))
+
+	value, err := DecodeRESP(reader)
+
+	if err != nil {
+		t.Errorf("error decoding null bulk string: %s", err)
+	}
+
+	if value.typ != BulkString || !value.IsNull() || value.String() != "" {
+		t.Errorf("expected null BulkString, got %+v", value)
+	}
+
+	value, err = DecodeRESP(reader)
+
+	if err != nil || value.String() != "next" {
+		t.Errorf("expected 'next' after the null bulk string, got '%s' (%v)", value.String(), err)
+	}
+}
+
+func TestDecodeOversizedArray(t *testing.T) {
+	t.Parallel()
+
+	_, err := DecodeRESP(bufio.NewReader(bytes.NewBufferString("*2000000000\r\n")))
+
+	if !isProtocolError(err) || err.Error() != "Protocol error: invalid multibulk length" {
+		t.Errorf("expected invalid multibulk length error, got %v", err)
+	}
+}
+
+func TestDecodeNullArray(t *testing.T) {
+	t.Parallel()
+
+	value, err := DecodeRESP(bufio.NewReader(bytes.NewBufferString("*-1\r\n")))
+
+	if err != nil {
+		t.Errorf("error decoding null array: %s", err)
+	}
+
+	if value.typ != Array || !value.IsNull() || len(value.Array()) != 0 {
+		t.Errorf("expected null Array, got %+v", value)
+	}
+}
+
+func TestDecodeEmptyArray(t *testing.T) {
+	t.Parallel()
+
+	value, err := DecodeRESP(bufio.NewReader(bytes.NewBufferString("*0\r\n")))
+
+	if err != nil {
+		t.Errorf("error decoding empty array: %s", err)
+	}
+
+	if value.typ != Array || value.IsNull() || len(value.Array()) != 0 {
+		t.Errorf("expected empty Array, got %+v", value)
+	}
+}
+
+func TestDecodeOverlongLine(t *testing.T) {
+	t.Parallel()
+
+	line := "+" + strings.Repeat("a", defaultMaxLineLength+1) + "\r\n"
+
+	_, err := DecodeRESP(bufio.NewReader(bytes.NewBufferString(line)))
+
+	if !isProtocolError(err) || err.Error() != "Protocol error: too big inline request" {
+		t.Errorf("expected too big inline request error, got %v", err)
+	}
+
+	// Test with a line at the limit
+	line = "+" + strings.Repeat("a", defaultMaxLineLength) + "\r\n"
+
+	value, err := DecodeRESP(bufio.NewReader(bytes.NewBufferString(line)))
+
+	if err != nil || len(value.String()) != defaultMaxLineLength {
+		t.Errorf("expected a %d bytes simple string, got %d bytes (%v)", defaultMaxLineLength, len(value.String()), err)
+	}
+}
+
+func TestDecodeLoneLF(t *testing.T) {
+	t.Parallel()
+
+	_, err := DecodeRESP(bufio.NewReader(bytes.NewBufferString("+foo\nbar\r\n")))
+
+	if !isProtocolError(err) {
+		t.Errorf("expected protocol error, got %v", err)
+	}
+
+	_, err = DecodeRESP(bufio.NewReader(bytes.NewBufferString("*1\n$4\r\nPING\r\n")))
+
+	if !isProtocolError(err) {
+		t.Errorf("expected protocol error, got %v", err)
+	}
+}
+
+func TestValueEncode(t *testing.T) {
+	t.Parallel()
+
+	for _, message := range []string{
+		"+OK\r\n",
+		"-ERR foo\r\n",
+		":-1000\r\n",
+		"$4\r\nabcd\r\n",
+		"$0\r\n\r\n",
+		"$-1\r\n",
+		"*0\r\n",
+		"*-1\r\n",
+		"*3\r\n$3\r\nSET\r\n:1\r\n*2\r\n+foo\r\n$-1\r\n",
+	} {
+		value, err := DecodeRESP(bufio.NewReader(bytes.NewBufferString(message)))
+		if err != nil {
+			t.Errorf("error decoding %q: %s", message, err)
+			continue
+		}
+
+		encoded := value.Encode()
+		if string(encoded) != message {
+			t.Errorf("expected %q once encoded, got %q", message, encoded)
+		}
+
+		decoded, err := DecodeRESP(bufio.NewReader(bytes.NewReader(encoded)))
+		if err != nil || !reflect.DeepEqual(decoded, value) {
+			t.Errorf("expected %+v once decoded again, got %+v (%v)", value, decoded, err)
+		}
+	}
+}
+
+func TestValueConstructors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value Value
+		want  string
+	}{
+		{NewSimpleString("OK"), "+OK\r\n"},
+		{NewError("ERR foo"), "-ERR foo\r\n"},
+		{NewInteger(-1000), ":-1000\r\n"},
+		{NewBulkString("abcd"), "$4\r\nabcd\r\n"},
+		{NewBulkString(""), "$0\r\n\r\n"},
+		{NewBulkString("a\r\nb"), "$4\r\na\r\nb\r\n"},
+		{NewNull(), "$-1\r\n"},
+		{NewArray(), "*0\r\n"},
+		{NewNullArray(), "*-1\r\n"},
+		{
+			NewArray(NewBulkString("SET"), NewInteger(1), NewArray(NewSimpleString("foo"), NewNull())),
+			"*3\r\n$3\r\nSET\r\n:1\r\n*2\r\n+foo\r\n$-1\r\n",
+		},
+	}
+
+	for _, test := range tests {
+		encoded := test.value.Encode()
+		if string(encoded) != test.want {
+			t.Errorf("expected %q once encoded, got %q", test.want, encoded)
+		}
+
+		decoded, err := DecodeRESP(bufio.NewReader(bytes.NewReader(encoded)))
+		if err != nil || string(decoded.Encode()) != test.want {
+			t.Errorf("expected %q once decoded and encoded again, got %q (%v)", test.want, decoded.Encode(), err)
+		}
+	}
+
+	value := NewArray(NewBulkString("GET"), NewBulkString("key"))
+	if args := value.Args(); len(args) != 2 || args[0] != "GET" || args[1] != "key" {
+		t.Errorf("expected [GET key], got %q", args)
+	}
+
+	if i, err := NewInteger(42).Int(); err != nil || i != 42 {
+		t.Errorf("expected 42, got %d (%v)", i, err)
+	}
+
+	if !NewNull().IsNull() || !NewNullArray().IsNull() || NewBulkString("").IsNull() {
+		t.Errorf("expected only the null values to be null")
+	}
+}
+
+func TestReturnNullableArray(t *testing.T) {
+	t.Parallel()
+
+	empty, value, long := "", "value", strings.Repeat("x", 1234)
+
+	tests := []struct {
+		values []*string
+		want   string
+	}{
+		{nil, "*0\r\n"},
+		{[]*string{nil}, "*1\r\n$-1\r\n"},
+		{[]*string{&empty, &value, nil}, "*3\r\n$0\r\n\r\n$5\r\nvalue\r\n$-1\r\n"},
+		{[]*string{&long}, "*1\r\n$1234\r\n" + long + "\r\n"},
+	}
+
+	for _, test := range tests {
+		if got := returnNullableArray(test.values); got != test.want {
+			t.Errorf("returnNullableArray(%d values) = %q; want %q", len(test.values), got, test.want)
+		}
+	}
+
+	if got := returnArray([]string{"", "value"}); got != "*2\r\n$-1\r\n$5\r\nvalue\r\n" {
+		t.Errorf("returnArray([]string{\"\", \"value\"}) = %q; want the empty string as a null", got)
+	}
+}
+
+func TestValueArgs(t *testing.T) {
+	t.Parallel()
+
+	value, err := DecodeRESP(bufio.NewReader(bytes.NewBufferString("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$0\r\n\r\n")))
+
+	if err != nil {
+		t.Errorf("error decoding array: %s", err)
+	}
+
+	args := value.Args()
+
+	if len(args) != 3 || args[0] != "SET" || args[1] != "key" || args[2] != "" {
+		t.Errorf("expected [SET key ], got %q", args)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A replicationState holds the replicas fed by the server, acting as their master.
+// id and offset identify the replication stream, offset counting the bytes propagated so far.
+// db is the database selected on the replicas, -1 forcing the next propagated command to select one.
+// The mutex serializes the write commands with the full resynchronizations,
+// so that each replica receives every write exactly once, after its snapshot.
+type replicationState struct {
+	id       string
+	offset   int64
+	db       int
+	replicas map[*client]bool
+	mutex    sync.RWMutex
+}
+
+func init() {
+	registerCommand("REPLICAOF", CommandSpec{handler: replicaofCommand, arity: 3, flags: []string{"admin"}})
+	registerCommand("SLAVEOF", CommandSpec{handler: replicaofCommand, arity: 3, flags: []string{"admin"}})
+	registerCommand("REPLCONF", CommandSpec{handler: replconfCommand, arity: -2, flags: []string{"admin"}})
+	registerCommand("PSYNC", CommandSpec{connectionHandler: psyncCommand, arity: 3, flags: []string{"admin"}})
+}
+
+// randomID returns a random identifier of 40 hexadecimal characters.
+func randomID() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// replicaofCommand makes the server a replica of another server, or a master with NO ONE.
+// Since replicating another server is not supported, the server always stays a master.
+func replicaofCommand(_ *commandContext, args []string) string {
+	if strings.EqualFold(args[0], "NO") && strings.EqualFold(args[1], "ONE") {
+		return returnSimpleString("OK")
+	}
+
+	return returnError("replication is not supported")
+}
+
+// replconfCommand accepts the configuration sent by a replica before PSYNC.
+// The acknowledgements sent by replicas afterwards are not replied to.
+func replconfCommand(_ *commandContext, args []string) string {
+	if strings.EqualFold(args[0], "ACK") {
+		return ""
+	}
+
+	return returnSimpleString("OK")
+}
+
+// psyncCommand turns the client into a replica.
+// Only full resynchronizations are supported, whatever the replication id and offset asked for:
+// the replica receives a snapshot of every database, then every subsequent write command.
+func psyncCommand(c *client, _ []string) string {
+	if err := c.server.fullResync(c); err != nil {
+		return returnError("Error trying to send the snapshot: " + err.Error())
+	}
+
+	// The reply has already been written, ahead of the propagated commands.
+	return ""
+}
+
+// fullResync sends the client a +FULLRESYNC reply followed by a snapshot of every database,
+// in the form of a bulk string without the final CRLF, and registers it as a replica.
+// No write command runs meanwhile, so the replica misses none of them.
+func (server *RedisServer) fullResync(c *client) error {
+	server.replication.mutex.Lock()
+	defer server.replication.mutex.Unlock()
+
+	snapshots := make([]snapshot, 0, len(server.databases))
+	for _, database := range server.databases {
+		snapshots = append(snapshots, database.takeSnapshot())
+	}
+
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(snapshots); err != nil {
+		return err
+	}
+
+	reply := fmt.Sprintf("+FULLRESYNC %s %d\r\n$%d\r\n", server.replication.id, server.replication.offset, payload.Len())
+	if err := c.write(reply + payload.String()); err != nil {
+		return err
+	}
+
+	if server.replication.replicas == nil {
+		server.replication.replicas = make(map[*client]bool)
+	}
+
+	server.replication.replicas[c] = true
+	server.replication.db = -1
+
+	server.logger.Infof("Replica %s synchronized with %d bytes", c.address(), payload.Len())
+
+	return nil
+}
+
+// removeReplica stops feeding the client, if it is a replica.
+func (server *RedisServer) removeReplica(c *client) {
+	server.replication.mutex.Lock()
+	defer server.replication.mutex.Unlock()
+
+	delete(server.replication.replicas, c)
+}
+
+// commandValue returns the RESP array of a command and its arguments, as sent by clients.
+func commandValue(command string, args ...string) Value {
+	array := make([]Value, 0, len(args)+1)
+	for _, arg := range append([]string{command}, args...) {
+		array = append(array, Value{typ: BulkString, bytes: stringToBytes(arg)})
+	}
+
+	return Value{typ: Array, array: array}
+}
+
+// absoluteExpiry rewrites the commands setting a relative expire time into commands setting it absolutely,
+// EXPIRE into PEXPIREAT, and SET with EX or PX and SETEX into SET with PXAT,
+// so that the key expires at the same time on the replicas, however late they apply the command.
+// The expire time is the one the command gave the key in db.
+func absoluteExpiry(db *Database, command string, args []string) (string, []string) {
+	var key, value string
+
+	switch command {
+	case "EXPIRE":
+		key = args[0]
+	case "SETEX":
+		key, value = args[0], args[2]
+	case "SET":
+		if len(args) != 4 || (!strings.EqualFold(args[2], "EX") && !strings.EqualFold(args[2], "PX")) {
+			return command, args
+		}
+
+		key, value = args[0], args[1]
+	default:
+		return command, args
+	}
+
+	expire := db.GetExpire(key)
+	if expire.IsZero() {
+		return command, args
+	}
+
+	at := strconv.FormatInt(expire.UnixMilli(), 10)
+	if command == "EXPIRE" {
+		return "PEXPIREAT", []string{key, at}
+	}
+
+	return "SET", []string{key, value, "PXAT", at}
+}
+
+// propagate sends the write command to every replica, selecting the database it ran against first if needed.
+// It must be called with the replication mutex locked for writing.
+func (server *RedisServer) propagate(db int, command string, args []string) {
+	var payload []byte
+
+	if db != server.replication.db {
+		payload = commandValue("SELECT", strconv.Itoa(db)).appendEncoded(payload)
+		server.replication.db = db
+	}
+
+	payload = commandValue(command, args...).appendEncoded(payload)
+	server.replication.offset += int64(len(payload))
+
+	for replica := range server.replication.replicas {
+		if err := replica.write(bytesToString(payload)); err != nil {
+			server.logger.Debugf("Error writing to replica: %s", err)
+		}
+	}
+}
+
+// replicationMiddleware propagates the successful write commands to the replicas.
+// While there are replicas, write commands run one at a time, so that they are propagated in order.
+// Administrative commands, such as LOAD, only apply to this server.
+func (server *RedisServer) replicationMiddleware(next Handler) Handler {
+	return func(c *client, command string, args []string) string {
+		if spec, _ := lookupCommand(command); !spec.hasFlag("write") || spec.hasFlag("admin") {
+			return next(c, command, args)
+		}
+
+		server.replication.mutex.RLock()
+		if len(server.replication.replicas) == 0 {
+			defer server.replication.mutex.RUnlock()
+
+			return next(c, command, args)
+		}
+		server.replication.mutex.RUnlock()
+
+		server.replication.mutex.Lock()
+		defer server.replication.mutex.Unlock()
+
+		db := server.commandDB(c)
+
+		response := next(c, command, args)
+		if !strings.HasPrefix(response, "-") {
+			command, args := absoluteExpiry(db, command, args)
+			server.propagate(db.id, command, args)
+		}
+
+		return response
+	}
+}
+
+// replicationInfo returns the fields of the Replication section of INFO.
+func replicationInfo(server *RedisServer) string {
+	server.replication.mutex.RLock()
+	defer server.replication.mutex.RUnlock()
+
+	return fmt.Sprintf(
+		"role:master\r\nconnected_slaves:%d\r\nmaster_replid:%s\r\nmaster_repl_offset:%d\r\n",
+		len(server.replication.replicas),
+		server.replication.id,
+		server.replication.offset,
+	)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplicaofCommand(t *testing.T) {
+	// Test with NO ONE
+	result := replicaofCommand(testContext, []string{"NO", "ONE"})
+	if result != okReply {
+		t.Errorf("replicaofCommand([]string{\"NO\", \"ONE\"}) = %s; want +OK\\r\\n", result)
+	}
+
+	result = replicaofCommand(testContext, []string{"no", "one"})
+	if result != okReply {
+		t.Errorf("replicaofCommand([]string{\"no\", \"one\"}) = %s; want +OK\\r\\n", result)
+	}
+
+	// Test with a master
+	result = replicaofCommand(testContext, []string{"127.0.0.1", "6380"})
+	if result != "-ERR replication is not supported\r\n" {
+		t.Errorf("replicaofCommand([]string{\"127.0.0.1\", \"6380\"}) = %s; want -ERR replication is not supported\\r\\n", result)
+	}
+}
+
+func TestInfoReplication(t *testing.T) {
+	result := infoCommand(testContext, []string{"replication"})
+	if !strings.Contains(result, "# Replication\r\nrole:master\r\nconnected_slaves:0\r\n") {
+		t.Errorf("infoCommand([]string{\"replication\"}) = %s; want role:master and connected_slaves:0", result)
+	}
+
+	if strings.Contains(result, "# Stats") {
+		t.Errorf("infoCommand([]string{\"replication\"}) = %s; want only the Replication section", result)
+	}
+}
+
+func TestPsyncCommand(t *testing.T) {
+	defer teardown()
+
+	setCommand(testContext, []string{"key", "value"})
+
+	replica := newTestClient(t)
+
+	result := replica.send(t, "REPLCONF", "listening-port", "6380")
+	if result != okReply {
+		t.Fatalf("REPLCONF listening-port 6380 = %s; want +OK\\r\\n", result)
+	}
+
+	if _, err := replica.conn.Write([]byte(returnArray([]string{"PSYNC", "?", "-1"}))); err != nil {
+		t.Fatal(err)
+	}
+
+	line, err := replica.reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(line, "+FULLRESYNC ") {
+		t.Fatalf("PSYNC ? -1 = %s; want +FULLRESYNC", line)
+	}
+
+	line, err = replica.reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	length, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+	if err != nil {
+		t.Fatalf("snapshot header = %s; want $<length>\\r\\n", line)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(replica.reader, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	var snapshots []snapshot
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&snapshots); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(snapshots) != len(testServer.databases) || snapshots[0].StringKeys["key"] != "value" {
+		t.Errorf("snapshot = %+v; want every database, with key in the first one", snapshots)
+	}
+
+	if got := infoField(t, "connected_slaves"); got != 1 {
+		t.Errorf("connected_slaves = %d; want 1", got)
+	}
+
+	// Relative expire times are propagated as absolute ones, written <at> here,
+	// and commands run against another database select it without changing the selected one.
+	want := [][]string{
+		{"SELECT", "0"}, {"SET", "key", "new value"}, {"SET", "other", "value"},
+		{"SET", "volatile", "value", "PXAT", "<at>"}, {"PEXPIREAT", "key", "<at>"}, {"SET", "other", "value", "PXAT", "<at>"},
+		{"SELECT", "1"}, {"SET", "key", "one"}, {"SELECT", "0"}, {"SET", "last", "value"},
+	}
+
+	propagated := make(chan []string, len(want))
+	go func() {
+		defer close(propagated)
+
+		for range want {
+			value, err := DecodeRESP(replica.reader)
+			if err != nil {
+				t.Errorf("error reading propagated command: %s", err)
+				return
+			}
+
+			propagated <- value.Args()
+		}
+	}()
+
+	client := newTestClient(t)
+	client.send(t, "SET", "key", "new value")
+	client.send(t, "GET", "key")
+	client.send(t, "SET", "other", "value")
+
+	before := time.Now().Add(100 * time.Second).UnixMilli()
+	client.send(t, "SETEX", "volatile", "100", "value")
+	client.send(t, "EXPIRE", "key", "100")
+	client.send(t, "SET", "other", "value", "PX", "100000")
+	after := time.Now().Add(100 * time.Second).UnixMilli()
+
+	defer testServer.databases[1].Flush()
+	testServer.Execute(1, "SET", "key", "one")
+	client.send(t, "SET", "last", "value")
+
+	for _, command := range want {
+		got := <-propagated
+
+		if last := len(command) - 1; len(got) == len(command) && command[last] == "<at>" {
+			if at, err := strconv.ParseInt(got[last], 10, 64); err == nil && at >= before && at <= after {
+				got[last] = "<at>"
+			}
+		}
+
+		if strings.Join(got, " ") != strings.Join(command, " ") {
+			t.Errorf("propagated command = %q; want %q", got, command)
+		}
+	}
+}
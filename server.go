@@ -2,39 +2,154 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
-	"strconv"
+	"os"
+	"runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// A config represents the server configuration.
-type config struct {
-	port     int
-	fileName string
+// A stats holds the counters reported in the Stats section of INFO.
+type stats struct {
+	connectedClients         atomic.Int64
+	totalConnectionsReceived atomic.Int64
+	totalCommandsProcessed   atomic.Int64
+	keyspaceHits             atomic.Int64
+	keyspaceMisses           atomic.Int64
+	expiredKeys              atomic.Int64
+}
+
+// A client represents a connection to the server.
+// server is the server the client is connected to,
+// and lifetime is canceled once the connection is closed.
+// id identifies the connection, and name is the one set with CLIENT SETNAME.
+// Writes are serialized, since other connections may write to it (e.g. MONITOR).
+// closeAfterReply reports whether the connection is closed once the current reply is written.
+// handler executes the commands of the client through the middlewares.
+// inTransaction, transactionFailed and transaction hold the state of MULTI.
+// channels holds the channels the client is subscribed to, and pubsub whether there is any.
+// The writes to a connection go through outbox, drained by a dedicated writer goroutine
+// which closes writerDone when it exits, so that a slow client never stalls the others.
+// pendingOutput counts the bytes queued but not written yet, and softLimitSince is the time
+// they went over the soft output buffer limit.
+// authenticated reports whether the client gave the password set with requirepass, using AUTH.
+// database, when set, is the database the commands of the client run against,
+// instead of the selected database of the server, as for the commands run with Execute.
+type client struct {
+	server            *RedisServer
+	lifetime          context.Context
+	id                int64
+	name              string
+	conn              net.Conn
+	closeAfterReply   bool
+	handler           Handler
+	inTransaction     bool
+	transactionFailed bool
+	transaction       []queuedCommand
+	channels          map[string]bool
+	pubsub            bool
+	outbox            []string
+	outboxReady       *sync.Cond
+	outboxClosed      bool
+	writerDone        chan struct{}
+	pendingOutput     int64
+	softLimitSince    time.Time
+	authenticated     bool
+	database          *Database
+	mutex             sync.Mutex
+}
+
+// address returns the address of the client, as reported by MONITOR.
+// Clients connected through a Unix socket are identified by the socket path,
+// and commands run with Execute by "in-process".
+func (c *client) address() string {
+	if c.conn == nil {
+		return "in-process"
+	}
+
+	if addr, ok := c.conn.LocalAddr().(*net.UnixAddr); ok {
+		return "unix:" + addr.Name
+	}
+
+	return c.conn.RemoteAddr().String()
 }
 
 // A RedisServer represents a Redis server.
+// activeExpire reports whether the ExpireCheckers remove expired keys in the background.
+// loading reports whether the databases are being loaded from disk.
+// runID identifies the server process, and stays the same until it exits, as does nodeID, reported by CLUSTER MYID.
+// pause holds the commands of the clients during CLIENT PAUSE.
+// stopSaving stops the SaveChecker when the server shuts down.
+// clock tells the time the expire times of the keys are set and checked against, the system time unless set before Init.
+// commandLatency holds the latency of the latest executions of every command, reported by LATENCY DOCTOR.
+// monitors holds the clients receiving every processed command.
+// channels holds the subscribers of each pub/sub channel.
+// listeners accept the client connections, until the server shuts down.
+// databases is built once by Init and never changes afterwards: SWAPDB exchanges the contents of two databases,
+// not their places, so it can be indexed without locking.
+// nextClientID holds the id of the last connected client.
+// replication holds the replicas fed with the write commands.
 type RedisServer struct {
-	config     *config
-	logger     *log.Logger
-	databases  []*Database
-	selectedDB int
-	mu         sync.Mutex
+	config         *config
+	logger         *leveledLogger
+	listeners      []net.Listener
+	databases      []*Database
+	selectedDB     int
+	activeExpire   atomic.Bool
+	loading        atomic.Bool
+	runID          string
+	nodeID         string
+	pause          clientPause
+	stopSaving     chan struct{}
+	clock          Clock
+	stats          stats
+	slowlog        slowlog
+	latency        latencyMonitor
+	commandLatency commandLatencies
+	nextClientID   atomic.Int64
+	replication    replicationState
+	monitors       map[*client]bool
+	monitorsMutex  sync.RWMutex
+	channels       map[string]map[*client]bool
+	pubsubMutex    sync.RWMutex
+	middlewares    []Middleware
+	mu             sync.Mutex
 }
 
 // Init initializes the redis server.
 func (server *RedisServer) Init() {
-	for i := 0; i < 16; i++ {
-		server.databases = append(server.databases, NewDatabase(i))
+	if server.clock == nil {
+		server.clock = realClock{}
+	}
+
+	for i := 0; i < server.config.databases; i++ {
+		server.databases = append(server.databases, NewDatabase(server, i))
 	}
 
 	server.selectedDB = 0
+	server.activeExpire.Store(true)
+	server.runID = randomID()
+	server.nodeID = randomID()
+	server.replication.id = randomID()
+	server.middlewares = []Middleware{
+		server.statsMiddleware,
+		server.monitorMiddleware,
+		server.slowlogMiddleware,
+		server.replicationMiddleware,
+	}
+
+	server.loading.Store(true)
 	server.StartDB(server.config.fileName)
+	server.loading.Store(false)
+
+	server.startSaveChecker()
 }
 
 // StartDB starts the database.
@@ -43,6 +158,16 @@ func (server *RedisServer) StartDB(fileName string) {
 	server.databases[server.selectedDB].Init(fileName)
 }
 
+// commandDB returns the database the commands of the client run against:
+// its own database if it has one, the selected database of the server otherwise.
+func (server *RedisServer) commandDB(c *client) *Database {
+	if c != nil && c.database != nil {
+		return c.database
+	}
+
+	return server.databases[server.selectedDB]
+}
+
 // SelectDB selects the database with the given index.
 // It closes the current database and opens the new one.
 // It also updates the selectedDB field.
@@ -57,66 +182,481 @@ func (server *RedisServer) SelectDB(index int) {
 }
 
 // Run runs the server.
-// It listens for connections and handles them.
+// It listens for connections on the plaintext port and, if configured, on the TLS port,
+// on every bound address, and on the Unix socket.
+// Each listener has its own accept loop, and the connections are handled until the server shuts down.
 func (server *RedisServer) Run() {
-	l, err := net.Listen("tcp", "0.0.0.0:"+strconv.Itoa(server.config.port))
+	var listeners []net.Listener
+
+	if server.config.port != 0 {
+		for _, address := range server.config.bindAddresses(server.config.port) {
+			l, err := net.Listen("tcp", address)
+			if err != nil {
+				server.logger.Fatal(err)
+			}
+
+			server.logger.Infof("Listening on %s", address)
+			listeners = append(listeners, l)
+		}
+	}
+
+	if server.config.tlsPort != 0 {
+		for _, address := range server.config.bindAddresses(server.config.tlsPort) {
+			l, err := listenTLS(address, server.config.tlsCertFile, server.config.tlsKeyFile)
+			if err != nil {
+				server.logger.Fatal(err)
+			}
+
+			server.logger.Infof("Listening on %s with TLS", address)
+			listeners = append(listeners, l)
+		}
+	}
+
+	if server.config.unixSocket != "" {
+		l, err := listenUnix(server.config.unixSocket)
+		if err != nil {
+			server.logger.Fatal(err)
+		}
+
+		server.logger.Infof("Listening on Unix socket %s", server.config.unixSocket)
+		listeners = append(listeners, l)
+	}
+
+	server.mu.Lock()
+	server.listeners = listeners
+	server.mu.Unlock()
+
+	var wg sync.WaitGroup
+
+	for _, l := range listeners {
+		wg.Add(1)
+
+		go func(l net.Listener) {
+			defer wg.Done()
+			server.serve(l)
+		}(l)
+	}
+
+	wg.Wait()
+}
+
+// listenTLS listens for TLS connections on the address, using the certificate and key files.
+func listenTLS(address string, certFile string, keyFile string) (net.Listener, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
 	if err != nil {
-		server.logger.Fatal(err)
+		return nil, err
 	}
 
-	defer l.Close()
+	return tls.Listen("tcp", address, &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	})
+}
 
-	server.logger.Printf("Listening on port %d\n", server.config.port)
+// listenUnix listens for connections on the Unix socket at the path.
+// A socket file left over by a previous run is removed first,
+// and the socket file is removed again when the listener is closed.
+func listenUnix(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return net.Listen("unix", path)
+}
+
+// serve accepts the connections of the listener and handles them, until the listener is closed.
+func (server *RedisServer) serve(l net.Listener) {
+	defer l.Close()
 
 	for {
 		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+
 		if err != nil {
-			server.logger.Fatal("Error accepting connection: ", err.Error())
+			server.logger.Fatal("Error accepting connection: ", err)
 		}
 
 		go server.handleRequest(conn)
 	}
 }
 
-// handleRequest handles a client request.
-// It reads the request, parses it and sends the response.
-func (server *RedisServer) handleRequest(conn net.Conn) {
-	defer conn.Close()
+// SaveAll saves every database on disk, and returns the first error encountered.
+func (server *RedisServer) SaveAll() error {
+	var firstErr error
 
-	reader := bufio.NewReader(conn)
+	for _, database := range server.databases {
+		if err := database.Save(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
 
-	commandMap := getCommandMap()
+	return firstErr
+}
 
-	for {
-		value, err := DecodeRESP(reader)
-		if errors.Is(err, io.EOF) {
-			break
+// saveCheckInterval is how often the SaveChecker looks for a reached save point.
+const saveCheckInterval = 100 * time.Millisecond
+
+// saveRetryDelay is how long the SaveChecker waits before saving a database again after a failed save.
+const saveRetryDelay = 5 * time.Second
+
+// startSaveChecker starts the SaveChecker, which saves in the background
+// every database that reached one of the save points of the save parameter.
+// The clients are not held up meanwhile, since a save only locks the database to copy it.
+func (server *RedisServer) startSaveChecker() {
+	server.stopSaving = make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(saveCheckInterval)
+		defer ticker.Stop()
+
+		failedAt := make(map[int]time.Time)
+
+		for {
+			select {
+			case <-ticker.C:
+				points := server.config.savePoints()
+				now := time.Now()
+
+				for _, database := range server.databases {
+					if !database.reachedSavePoint(points, now) || now.Sub(failedAt[database.id]) < saveRetryDelay {
+						continue
+					}
+
+					server.logger.Infof("Save point reached for database %d, saving...", database.id)
+
+					if err := database.Save(); err != nil {
+						failedAt[database.id] = now
+					}
+				}
+			case <-server.stopSaving:
+				return
+			}
+		}
+	}()
+}
+
+// FlushAll deletes all the keys of every database at once:
+// every shard of every database is locked, in the order of the database ids, before any key is deleted,
+// so that no client sees some databases flushed and others not.
+func (server *RedisServer) FlushAll() {
+	unlocks := make([]func(), 0, len(server.databases))
+	for _, database := range server.databases {
+		unlocks = append(unlocks, database.lockAll(true))
+	}
+
+	defer func() {
+		for _, unlock := range unlocks {
+			unlock()
 		}
+	}()
+
+	for _, database := range server.databases {
+		database.clear()
+	}
+}
+
+// SwapDB swaps the contents of the databases with the given indexes,
+// so that the clients of each one see the keys of the other from then on.
+// The databases themselves stay in place, so a *Database resolved from an index is never stale:
+// only their keys move, under the write locks of every shard of both databases,
+// taken in the order of the database ids as FlushAll does.
+func (server *RedisServer) SwapDB(first int, second int) {
+	if first == second {
+		return
+	}
+
+	if first > second {
+		first, second = second, first
+	}
+
+	a, b := server.databases[first], server.databases[second]
+
+	unlockFirst := a.lockAll(true)
+	unlockSecond := b.lockAll(true)
+	streams := a.swapContents(b)
+	unlockSecond()
+	unlockFirst()
+
+	for _, key := range streams {
+		a.notifyStreamWaiters(key)
+		b.notifyStreamWaiters(key)
+	}
+}
+
+// Reload saves every database on disk, then loads them back into fresh in-memory structures.
+// If saving or loading fails, the databases are left untouched.
+// Meanwhile, the commands that need the dataset are refused with a LOADING error.
+func (server *RedisServer) Reload() error {
+	if err := server.SaveAll(); err != nil {
+		return err
+	}
+
+	server.loading.Store(true)
+	defer server.loading.Store(false)
+
+	return server.loadAll()
+}
 
+// loadAll replaces the content of every database with the content of its dump.
+// Every dump is decoded before any database is replaced, so that if one of them fails to load,
+// the databases are left untouched.
+func (server *RedisServer) loadAll() error {
+	snapshots := make([]snapshot, len(server.databases))
+	for i, database := range server.databases {
+		content, err := database.readSnapshot("")
 		if err != nil {
-			server.logger.Println("Error decoding RESP: ", err.Error())
-			return // Ignore clients that we fail to read from
+			return err
 		}
 
-		comingCommand := strings.ToUpper(value.Array()[0].String())
-		args := value.StringArray()[1:]
+		snapshots[i] = content
+	}
+
+	for i, database := range server.databases {
+		unlock := database.lockAll(true)
+		database.clear()
+		database.restore(snapshots[i])
+		unlock()
+	}
+
+	return nil
+}
+
+// Shutdown stops accepting connections and the SaveChecker, and saves every database on disk if save is true.
+// It returns the exit code of the process, leaving the actual exit to the caller.
+func (server *RedisServer) Shutdown(save bool) int {
+	server.mu.Lock()
+	for _, l := range server.listeners {
+		l.Close()
+	}
+
+	select {
+	case <-server.stopSaving:
+	default:
+		close(server.stopSaving)
+	}
+	server.mu.Unlock()
+
+	if save {
+		server.SaveAll()
+	}
+
+	server.logger.Infof("RedisWhistle is now ready to exit, bye bye...")
+
+	return 0
+}
+
+// recordKeyspaceLookup counts a key lookup as a keyspace hit or miss.
+func (server *RedisServer) recordKeyspaceLookup(found bool) {
+	if found {
+		server.stats.keyspaceHits.Add(1)
+	} else {
+		server.stats.keyspaceMisses.Add(1)
+	}
+}
+
+// releaseClient removes every reference the server holds to the closed client,
+// discards its pending transaction, and flushes its outbox.
+func (server *RedisServer) releaseClient(c *client) {
+	server.removeMonitor(c)
+	server.removeReplica(c)
+	server.unsubscribeAll(c)
+	c.resetTransaction()
+	c.stopWriter()
+}
+
+// Execute runs the command against the database with the given index and returns its raw RESP reply,
+// without going over the network. The command goes through the same checks and middlewares
+// as the commands sent by clients, but commands acting on a connection or on the process, such as MULTI or SHUTDOWN, are refused.
+// The selected database of the server is left untouched.
+func (server *RedisServer) Execute(db int, command string, args ...string) string {
+	command = strings.ToUpper(command)
 
-		// If the command is in the command map, execute it
-		// Otherwise, return an error
-		if command, ok := commandMap[comingCommand]; ok {
-			response := command(args)
+	if spec, ok := lookupCommand(command); ok && spec.connectionHandler != nil {
+		return returnError(fmt.Sprintf("Can't execute '%s' without a connection", command))
+	}
+
+	if db < 0 || db >= len(server.databases) {
+		return returnError("value is out of range or invalid DB index")
+	}
+
+	c := &client{server: server, lifetime: context.Background(), id: server.nextClientID.Add(1), database: server.databases[db], authenticated: true}
+	c.handler = server.chain(server.execute)
+
+	return server.dispatchSafely(c, command, args)
+}
+
+// dispatchSafely dispatches the command through the client handler, recovering from a panic of the command:
+// the panic is logged along with the command, and the client gets an error instead of the process crashing.
+// The locks taken by the commands are released with defer, so none is left held by the panic.
+func (server *RedisServer) dispatchSafely(c *client, command string, args []string) (response string) {
+	defer func() {
+		if r := recover(); r != nil {
+			server.logger.Errorf("Panic executing '%s' for client %s: %v\n%s", command, c.address(), r, debug.Stack())
+			c.failTransaction()
+
+			response = returnError("internal error")
+		}
+	}()
+
+	return dispatch(c.handler, c, command, args)
+}
+
+// readOnlyReply is the error replied to the write commands in read-only mode.
+const readOnlyReply = "-READONLY You can't write against a read only replica.\r\n"
+
+// loadingReply is the error replied to the commands that need the dataset while it is loaded.
+const loadingReply = "-LOADING Redis is loading the dataset in memory\r\n"
+
+// dispatch executes the command through the handler.
+// If the command is not registered, or the number of arguments does not match its arity,
+// it returns an error without running the handler, and marks the client transaction as failed.
+// While the databases are loaded, only the commands flagged as loading, such as PING, are allowed.
+// In read-only mode, the write commands are refused.
+// While the client is subscribed to a channel, only the pub/sub commands, PING and QUIT are allowed.
+// Inside a transaction, valid commands are queued instead of being executed.
+func dispatch(handler Handler, c *client, command string, args []string) string {
+	spec, ok := lookupCommand(command)
+	if !ok {
+		c.failTransaction()
+		return returnError(fmt.Sprintf("Unknown command '%s'", command))
+	}
+
+	if !spec.acceptsArguments(len(args)) {
+		c.failTransaction()
+		return returnWrongNumberOfArgumentsError(command)
+	}
+
+	if c != nil && c.needsAuth(spec) {
+		c.failTransaction()
+		return noAuthReply
+	}
+
+	if c != nil && c.server.loading.Load() && !spec.hasFlag("loading") {
+		c.failTransaction()
+		return loadingReply
+	}
+
+	if spec.hasFlag("write") && c != nil && c.server.config.isReadOnly() {
+		c.failTransaction()
+		return readOnlyReply
+	}
 
-			_, err := conn.Write([]byte(response))
+	if c != nil && len(c.channels) > 0 && !allowedInSubscribeMode(command) {
+		return returnError(fmt.Sprintf("Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", command))
+	}
+
+	if c != nil && c.inTransaction && !isTransactionCommand(command) {
+		return c.queueCommand(command, args)
+	}
+
+	return handler(c, command, args)
+}
+
+// execute runs the registered command function against the server.
+// The command is canceled if the connection of the client is closed meanwhile.
+// It is the innermost handler of the middleware chain.
+func (server *RedisServer) execute(c *client, command string, args []string) string {
+	spec, _ := lookupCommand(command)
+	if spec.connectionHandler != nil {
+		return spec.connectionHandler(c, args)
+	}
+
+	ctx := &commandContext{Context: context.Background(), server: server, client: c}
+	if c != nil {
+		ctx.Context = c.lifetime
+		ctx.database = c.database
+	}
+
+	return spec.handler(ctx, args)
+}
+
+// handleRequest handles a client request.
+// The requests are read and parsed in the background, so that a closed connection
+// is noticed, and cancels the running command, even while a command is running.
+// The commands are executed and replied to in order.
+func (server *RedisServer) handleRequest(conn net.Conn) {
+	defer conn.Close()
+
+	server.stats.totalConnectionsReceived.Add(1)
+	server.stats.connectedClients.Add(1)
+	defer server.stats.connectedClients.Add(-1)
+
+	lifetime, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &client{server: server, lifetime: lifetime, id: server.nextClientID.Add(1), conn: conn}
+	defer server.releaseClient(c)
+
+	c.handler = server.chain(server.execute)
+	c.startWriter()
+
+	requests := make(chan Value)
+
+	var readErr error
+
+	go func() {
+		defer close(requests)
+		defer cancel()
+
+		reader := bufio.NewReader(conn)
+
+		for {
+			value, err := DecodeRESPWithLimits(reader, server.config.decoderLimits())
 			if err != nil {
-				server.logger.Println("Error writing to connection: ", err.Error())
+				readErr = err
 				return
 			}
-		} else {
-			_, err := conn.Write([]byte(returnError(fmt.Sprintf("Unknown command '%s'", comingCommand))))
-			if err != nil {
-				server.logger.Println("Error writing to connection: ", err.Error())
+
+			select {
+			case requests <- value:
+			case <-lifetime.Done():
+				return
+			}
+		}
+	}()
+
+	for value := range requests {
+		// Empty and null arrays carry no command, and are ignored like in Redis.
+		if len(value.Array()) == 0 {
+			continue
+		}
+
+		comingCommand := strings.ToUpper(value.Array()[0].String())
+		args := value.Args()[1:]
+
+		// Commands are held while the clients are paused. Inside MULTI, only EXEC is held, not the queued commands.
+		if !c.inTransaction || isTransactionCommand(comingCommand) {
+			if !server.pause.Wait(lifetime, isWriteRequest(c, comingCommand)) {
 				return
 			}
 		}
+
+		response := server.dispatchSafely(c, comingCommand, args)
+		if response == "" {
+			continue
+		}
+
+		if err := c.write(response); err != nil {
+			server.logger.Debugf("Error writing to connection: %s", err)
+			return
+		}
+
+		if c.closeAfterReply {
+			return
+		}
+	}
+
+	if isProtocolError(readErr) {
+		server.logger.Debugf("Closing client %s: %s", c.address(), readErr)
+		c.write(returnError(readErr.Error()))
+
+		return
+	}
+
+	// A connection closed by the server, e.g. for a slow subscriber, has already been reported.
+	if readErr != nil && !errors.Is(readErr, io.EOF) && !errors.Is(readErr, net.ErrClosed) && !errors.Is(readErr, io.ErrClosedPipe) {
+		server.logger.Warningf("Error decoding RESP: %s", readErr)
 	}
 }
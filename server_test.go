@@ -0,0 +1,1275 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A testClient is a client connected to the server through an in-memory connection.
+type testClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// newTestClient returns a client whose connection is handled by the server.
+func newTestClient(t *testing.T) *testClient {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	go testServer.handleRequest(serverConn)
+
+	t.Cleanup(func() {
+		clientConn.Close()
+	})
+
+	return &testClient{
+		conn:   clientConn,
+		reader: bufio.NewReader(clientConn),
+	}
+}
+
+// send sends a command to the server and returns its raw reply.
+func (client *testClient) send(t *testing.T, args ...string) string {
+	t.Helper()
+
+	request := "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, arg := range args {
+		request += returnBulkString(arg)
+	}
+
+	if _, err := client.conn.Write([]byte(request)); err != nil {
+		t.Fatalf("error writing command %v: %s", args, err)
+	}
+
+	reply, err := readReply(client.reader)
+	if err != nil {
+		t.Fatalf("error reading reply of command %v: %s", args, err)
+	}
+
+	return reply
+}
+
+// receive reads the next raw reply sent by the server.
+func (client *testClient) receive(t *testing.T) string {
+	t.Helper()
+
+	reply, err := readReply(client.reader)
+	if err != nil {
+		t.Fatalf("error reading reply: %s", err)
+	}
+
+	return reply
+}
+
+// readReply reads a single raw RESP reply.
+func readReply(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	switch line[0] {
+	case '$':
+		count, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil || count < 0 {
+			return line, err
+		}
+
+		payload := make([]byte, count+2)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return "", err
+		}
+
+		return line + string(payload), nil
+	case '*':
+		count, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return line, err
+		}
+
+		for i := 0; i < count; i++ {
+			item, err := readReply(reader)
+			if err != nil {
+				return "", err
+			}
+
+			line += item
+		}
+	}
+
+	return line, nil
+}
+
+// infoField returns the integer value of the given INFO field.
+func infoField(t *testing.T, field string) int {
+	t.Helper()
+
+	for _, line := range strings.Split(infoCommand(testContext, []string{}), "\r\n") {
+		if value, found := strings.CutPrefix(line, field+":"); found {
+			number, err := strconv.Atoi(value)
+			if err != nil {
+				t.Fatalf("INFO field %s = %s; want an integer", field, value)
+			}
+
+			return number
+		}
+	}
+
+	t.Fatalf("INFO field %s not found", field)
+
+	return 0
+}
+
+func TestHandleRequest(t *testing.T) {
+	client := newTestClient(t)
+
+	result := client.send(t, "PING")
+	if result != "+PONG\r\n" {
+		t.Errorf("PING = %s; want +PONG\\r\\n", result)
+	}
+
+	result = client.send(t, "FOO")
+	if result != "-ERR Unknown command 'FOO'\r\n" {
+		t.Errorf("FOO = %s; want -ERR Unknown command 'FOO'\\r\\n", result)
+	}
+}
+
+func TestHandleRequestProtocolError(t *testing.T) {
+	client := newTestClient(t)
+
+	if _, err := client.conn.Write([]byte("*1\r\n$1000000000000\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := readReply(client.reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "-ERR Protocol error: invalid bulk length\r\n" {
+		t.Errorf("oversized bulk string = %s; want -ERR Protocol error: invalid bulk length\\r\\n", result)
+	}
+
+	if _, err := readReply(client.reader); err != io.EOF {
+		t.Errorf("reading after the protocol error returned %v; want EOF", err)
+	}
+}
+
+func TestHandleRequestEmptyArray(t *testing.T) {
+	client := newTestClient(t)
+
+	if _, err := client.conn.Write([]byte("*0\r\n*-1\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	result := client.send(t, "PING")
+	if result != "+PONG\r\n" {
+		t.Errorf("PING after empty arrays = %s; want +PONG\\r\\n", result)
+	}
+}
+
+func TestExecute(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer()
+
+	result := server.Execute(0, "SET", "key", "value")
+	if result != okReply {
+		t.Errorf("Execute(0, \"SET\", \"key\", \"value\") = %s; want +OK\\r\\n", result)
+	}
+
+	result = server.Execute(0, "get", "key")
+	if result != "$5\r\nvalue\r\n" {
+		t.Errorf("Execute(0, \"get\", \"key\") = %s; want $5\\r\\nvalue\\r\\n", result)
+	}
+
+	// Test with another database
+	result = server.Execute(1, "GET", "key")
+	if result != nullReply {
+		t.Errorf("Execute(1, \"GET\", \"key\") = %s; want $-1\\r\\n", result)
+	}
+
+	server.Execute(1, "SET", "key", "other")
+
+	if server.selectedDB != 0 {
+		t.Errorf("selectedDB = %d after Execute(1, ...); want 0, the selected database left untouched", server.selectedDB)
+	}
+
+	if result := server.Execute(0, "GET", "key"); result != "$5\r\nvalue\r\n" {
+		t.Errorf("Execute(0, \"GET\", \"key\") = %s after a SET in database 1; want $5\\r\\nvalue\\r\\n", result)
+	}
+
+	// Test with the wrong number of arguments
+	result = server.Execute(0, "GET")
+	if result != "-ERR wrong number of arguments for 'GET' command\r\n" {
+		t.Errorf("Execute(0, \"GET\") = %s; want -ERR wrong number of arguments for 'GET' command\\r\\n", result)
+	}
+
+	// Test with a command acting on the connection
+	result = server.Execute(0, "MULTI")
+	if result != "-ERR Can't execute 'MULTI' without a connection\r\n" {
+		t.Errorf("Execute(0, \"MULTI\") = %s; want -ERR Can't execute 'MULTI' without a connection\\r\\n", result)
+	}
+}
+
+func TestIndependentServers(t *testing.T) {
+	t.Parallel()
+
+	first := newTestServer()
+	second := newTestServer()
+
+	first.Execute(0, "SET", "key", "first")
+	second.Execute(0, "SET", "key", "second")
+	second.Execute(0, "SET", "other", "value")
+
+	result := first.Execute(0, "GET", "key")
+	if result != "$5\r\nfirst\r\n" {
+		t.Errorf("first.Execute(0, \"GET\", \"key\") = %s; want $5\\r\\nfirst\\r\\n", result)
+	}
+
+	result = second.Execute(0, "GET", "key")
+	if result != "$6\r\nsecond\r\n" {
+		t.Errorf("second.Execute(0, \"GET\", \"key\") = %s; want $6\\r\\nsecond\\r\\n", result)
+	}
+
+	result = first.Execute(0, "DBSIZE")
+	if result != oneReply {
+		t.Errorf("first.Execute(0, \"DBSIZE\") = %s; want :1\\r\\n", result)
+	}
+}
+
+func TestInfoStats(t *testing.T) {
+	defer teardown()
+
+	connections := infoField(t, "total_connections_received")
+	commands := infoField(t, "total_commands_processed")
+	hits := infoField(t, "keyspace_hits")
+	misses := infoField(t, "keyspace_misses")
+
+	client := newTestClient(t)
+	client.send(t, "SET", "key", "value")
+	client.send(t, "GET", "key")
+	client.send(t, "GET", "non-existing-key")
+	client.send(t, "MGET", "key", "non-existing-key", "key")
+
+	if got := infoField(t, "total_connections_received") - connections; got != 1 {
+		t.Errorf("total_connections_received increased by %d; want 1", got)
+	}
+	if got := infoField(t, "total_commands_processed") - commands; got != 4 {
+		t.Errorf("total_commands_processed increased by %d; want 4", got)
+	}
+	if got := infoField(t, "keyspace_hits") - hits; got != 3 {
+		t.Errorf("keyspace_hits increased by %d; want 3", got)
+	}
+	if got := infoField(t, "keyspace_misses") - misses; got != 2 {
+		t.Errorf("keyspace_misses increased by %d; want 2", got)
+	}
+}
+
+func TestInfoExpiredKeys(t *testing.T) {
+	defer teardown()
+
+	debugCommand(testContext, []string{"SET-ACTIVE-EXPIRE", "0"})
+	defer debugCommand(testContext, []string{"SET-ACTIVE-EXPIRE", "1"})
+
+	expired := infoField(t, "expired_keys")
+
+	setCommand(testContext, []string{"key", "value", "PX", "10"})
+	time.Sleep(20 * time.Millisecond)
+
+	if got := infoField(t, "expired_keys") - expired; got != 0 {
+		t.Errorf("expired_keys increased by %d before the key was accessed; want 0", got)
+	}
+
+	result := getCommand(testContext, []string{"key"})
+	if result != nullReply {
+		t.Errorf("getCommand([]string{\"key\"}) = %s; want $-1\\r\\n", result)
+	}
+
+	getCommand(testContext, []string{"key"})
+
+	if got := infoField(t, "expired_keys") - expired; got != 1 {
+		t.Errorf("expired_keys increased by %d; want 1", got)
+	}
+}
+
+func TestInfoKeyspace(t *testing.T) {
+	defer teardown()
+
+	setCommand(testContext, []string{"key1", "value1"})
+	setCommand(testContext, []string{"key2", "value2", "EX", "100"})
+
+	result := infoCommand(testContext, []string{"keyspace"})
+	if !strings.Contains(result, "# Keyspace\r\ndb0:keys=2,expires=1\r\n") {
+		t.Errorf("infoCommand([]string{\"keyspace\"}) = %s; want db0:keys=2,expires=1", result)
+	}
+	if strings.Contains(result, "# Stats") {
+		t.Errorf("infoCommand([]string{\"keyspace\"}) = %s; want only the Keyspace section", result)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	defer teardown()
+
+	client := newTestClient(t)
+	client.send(t, "SET", "key", "value")
+
+	result := client.send(t, "CONFIG", "SET", "read-only", "yes")
+	if result != okReply {
+		t.Fatalf("CONFIG SET read-only yes = %q; want +OK\r\n", result)
+	}
+	defer configCommand(testContext, []string{"SET", "read-only", "no"})
+
+	for _, args := range [][]string{{"SET", "key", "other"}, {"DEL", "key"}, {"FLUSHALL"}} {
+		if result := client.send(t, args...); result != readOnlyReply {
+			t.Errorf("%q in read-only mode = %q; want %q", args, result, readOnlyReply)
+		}
+	}
+
+	result = client.send(t, "GET", "key")
+	if result != "$5\r\nvalue\r\n" {
+		t.Errorf("GET key in read-only mode = %q; want $5\\r\\nvalue\\r\\n", result)
+	}
+
+	// A write refused in read-only mode fails the transaction
+	client.send(t, "MULTI")
+	client.send(t, "SET", "key", "other")
+
+	result = client.send(t, "EXEC")
+	if !strings.HasPrefix(result, "-EXECABORT") {
+		t.Errorf("EXEC after a write refused in read-only mode = %q; want -EXECABORT", result)
+	}
+
+	client.send(t, "CONFIG", "SET", "read-only", "no")
+
+	result = client.send(t, "SET", "key", "other")
+	if result != okReply {
+		t.Errorf("SET key other once read-only mode is disabled = %q; want +OK\r\n", result)
+	}
+}
+
+func TestInfoServer(t *testing.T) {
+	runID := func() string {
+		result := infoCommand(testContext, []string{"server"})
+		for _, line := range strings.Split(result, "\r\n") {
+			if value, found := strings.CutPrefix(line, "run_id:"); found {
+				return value
+			}
+		}
+
+		t.Fatalf("infoCommand([]string{\"server\"}) = %s; want a run_id field", result)
+
+		return ""
+	}
+
+	first := runID()
+	if len(first) != 40 || strings.Trim(first, "0123456789abcdef") != "" {
+		t.Errorf("run_id = %q; want 40 hexadecimal characters", first)
+	}
+
+	if second := runID(); second != first {
+		t.Errorf("run_id = %q, then %q; want the same run id", first, second)
+	}
+
+	if got := infoField(t, "process_id"); got != os.Getpid() {
+		t.Errorf("process_id = %d; want %d", got, os.Getpid())
+	}
+
+	if other := newTestServer(); other.runID == testServer.runID {
+		t.Errorf("two servers share the run id %q; want different run ids", other.runID)
+	}
+}
+
+func TestLoading(t *testing.T) {
+	client := newTestClient(t)
+
+	testServer.loading.Store(true)
+	defer testServer.loading.Store(false)
+
+	result := client.send(t, "GET", "key")
+	if result != loadingReply {
+		t.Errorf("GET key while loading = %q; want %q", result, loadingReply)
+	}
+
+	result = client.send(t, "PING")
+	if result != "+PONG\r\n" {
+		t.Errorf("PING while loading = %q; want +PONG\r\n", result)
+	}
+
+	if got := infoField(t, "loading"); got != 1 {
+		t.Errorf("loading = %d while loading; want 1", got)
+	}
+
+	// A command refused while loading fails the transaction
+	client.send(t, "MULTI")
+	client.send(t, "SET", "key", "value")
+
+	result = client.send(t, "EXEC")
+	if !strings.HasPrefix(result, "-EXECABORT") {
+		t.Errorf("EXEC after a command refused while loading = %q; want -EXECABORT", result)
+	}
+
+	testServer.loading.Store(false)
+
+	result = client.send(t, "GET", "key")
+	if result != nullReply {
+		t.Errorf("GET key after loading = %q; want $-1\r\n", result)
+	}
+}
+
+func TestSlowlog(t *testing.T) {
+	defer slowlogCommand(testContext, []string{"RESET"})
+
+	client := newTestClient(t)
+
+	result := client.send(t, "SLOWLOG", "RESET")
+	if result != okReply {
+		t.Errorf("SLOWLOG RESET = %s; want +OK\\r\\n", result)
+	}
+
+	client.send(t, "DEBUG", "SLEEP", "0.02")
+	client.send(t, "PING")
+
+	result = client.send(t, "SLOWLOG", "LEN")
+	if result != oneReply {
+		t.Errorf("SLOWLOG LEN = %s; want :1\\r\\n", result)
+	}
+
+	result = client.send(t, "SLOWLOG", "GET")
+	if !strings.HasPrefix(result, "*1\r\n*4\r\n:") ||
+		!strings.HasSuffix(result, returnArray([]string{"DEBUG", "SLEEP", "0.02"})) {
+		t.Errorf("SLOWLOG GET = %s; want a single DEBUG SLEEP 0.02 entry", result)
+	}
+
+	result = client.send(t, "SLOWLOG", "GET", "0")
+	if result != "*0\r\n" {
+		t.Errorf("SLOWLOG GET 0 = %s; want *0\\r\\n", result)
+	}
+
+	client.send(t, "SLOWLOG", "RESET")
+	result = client.send(t, "SLOWLOG", "LEN")
+	if result != zeroReply {
+		t.Errorf("SLOWLOG LEN = %s; want :0\\r\\n", result)
+	}
+}
+
+func TestCommandLatencyPercentiles(t *testing.T) {
+	var latencies commandLatencies
+
+	for i := 1; i <= 100; i++ {
+		latencies.Record("GET", time.Duration(i)*time.Microsecond)
+	}
+
+	for i := 0; i < commandLatencyWindow; i++ {
+		latencies.Record("set", time.Millisecond)
+	}
+
+	latencies.Record("set", 2*time.Millisecond)
+
+	summaries := latencies.Summaries()
+	if len(summaries) != 2 {
+		t.Fatalf("Summaries() = %v; want get and set", summaries)
+	}
+
+	get := summaries[0]
+	if get.command != "get" || get.calls != 100 || get.p50 != 50*time.Microsecond || get.p99 != 99*time.Microsecond || get.max != 100*time.Microsecond {
+		t.Errorf("Summaries()[0] = %+v; want get with 100 calls, p50 of 50µs, p99 of 99µs and max of 100µs", get)
+	}
+
+	set := summaries[1]
+	if set.command != "set" || set.calls != int64(commandLatencyWindow)+1 || set.p99 != time.Millisecond || set.max != 2*time.Millisecond {
+		t.Errorf("Summaries()[1] = %+v; want set with the oldest sample replaced by the latest", set)
+	}
+}
+
+func TestLatencyDoctor(t *testing.T) {
+	client := newTestClient(t)
+	client.send(t, "SET", "key", "value")
+	client.send(t, "GET", "key")
+	client.send(t, "GET", "key")
+
+	result := client.send(t, "LATENCY", "DOCTOR")
+	if !strings.HasPrefix(result, "$") {
+		t.Fatalf("LATENCY DOCTOR = %q; want a bulk string report", result)
+	}
+
+	for _, command := range []string{"get", "set"} {
+		line := regexp.MustCompile("(?m)^" + command + ": calls=(\\d+) p50=(\\d+) p99=(\\d+) max=(\\d+)$").FindStringSubmatch(result)
+		if line == nil {
+			t.Errorf("LATENCY DOCTOR = %q; want a line for %s", result, command)
+			continue
+		}
+
+		calls, _ := strconv.Atoi(line[1])
+		p50, _ := strconv.Atoi(line[2])
+		p99, _ := strconv.Atoi(line[3])
+		max, _ := strconv.Atoi(line[4])
+
+		if calls < 1 || p50 > p99 || p99 > max {
+			t.Errorf("LATENCY DOCTOR line %q; want at least one call and p50 <= p99 <= max", line[0])
+		}
+	}
+
+	if result := client.send(t, "LATENCY", "DOCTOR", "extra"); result != "-ERR wrong number of arguments for 'LATENCY DOCTOR' command\r\n" {
+		t.Errorf("LATENCY DOCTOR extra = %s; want a wrong number of arguments error", result)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	threshold := testServer.config.latencyThreshold()
+	defer func() {
+		configCommand(testContext, []string{"SET", "latency-monitor-threshold", strconv.Itoa(threshold)})
+		latencyCommand(testContext, []string{"RESET"})
+	}()
+
+	client := newTestClient(t)
+	client.send(t, "LATENCY", "RESET")
+	client.send(t, "CONFIG", "SET", "latency-monitor-threshold", "10")
+	client.send(t, "DEBUG", "SLEEP", "0.02")
+	client.send(t, "PING")
+
+	result := client.send(t, "LATENCY", "LATEST")
+	if !strings.HasPrefix(result, "*1\r\n*4\r\n$7\r\ncommand\r\n:") {
+		t.Errorf("LATENCY LATEST = %s; want a single command event", result)
+	}
+
+	result = client.send(t, "LATENCY", "HISTORY", "command")
+	if !strings.HasPrefix(result, "*1\r\n*2\r\n:") {
+		t.Errorf("LATENCY HISTORY command = %s; want a single sample", result)
+	}
+
+	result = client.send(t, "LATENCY", "HISTORY", "fast-command")
+	if result != "*0\r\n" {
+		t.Errorf("LATENCY HISTORY fast-command = %s; want *0\\r\\n", result)
+	}
+
+	result = client.send(t, "LATENCY", "RESET")
+	if result != oneReply {
+		t.Errorf("LATENCY RESET = %s; want :1\\r\\n", result)
+	}
+
+	result = client.send(t, "LATENCY", "LATEST")
+	if result != "*0\r\n" {
+		t.Errorf("LATENCY LATEST = %s; want *0\\r\\n", result)
+	}
+}
+
+func TestLatencyLatestDuringReset(t *testing.T) {
+	var lm latencyMonitor
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 1000; i++ {
+			lm.Record("command", 5*time.Millisecond, 1)
+			lm.Reset()
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		for _, event := range lm.Latest() {
+			if event.event != "command" || event.sample.latency != 5 || event.max != 5 {
+				t.Fatalf("Latest() returned %+v; want the command event with a 5ms spike", event)
+			}
+		}
+	}
+
+	<-done
+}
+
+func TestMonitor(t *testing.T) {
+	defer teardown()
+
+	monitor := newTestClient(t)
+
+	result := monitor.send(t, "MONITOR")
+	if result != okReply {
+		t.Fatalf("MONITOR = %s; want +OK\\r\\n", result)
+	}
+
+	lines := make(chan string)
+	go func() {
+		line, err := monitor.reader.ReadString('\n')
+		if err != nil {
+			t.Errorf("error reading monitor line: %s", err)
+		}
+
+		lines <- line
+	}()
+
+	client := newTestClient(t)
+	client.send(t, "SET", "key", "value with spaces")
+
+	line := <-lines
+	if !strings.HasPrefix(line, "+") || !strings.HasSuffix(line, ` [0 pipe] "SET" "key" "value with spaces"`+"\r\n") {
+		t.Errorf("monitor line = %s; want the SET command", line)
+	}
+}
+
+func TestQuit(t *testing.T) {
+	client := newTestClient(t)
+
+	result := client.send(t, "QUIT")
+	if result != okReply {
+		t.Errorf("QUIT = %s; want +OK\\r\\n", result)
+	}
+
+	if _, err := client.reader.ReadByte(); !errors.Is(err, io.EOF) {
+		t.Errorf("reading after QUIT returned %v; want EOF", err)
+	}
+}
+
+func TestClientCommand(t *testing.T) {
+	defer teardown()
+
+	client := newTestClient(t)
+	other := newTestClient(t)
+
+	id := client.send(t, "CLIENT", "ID")
+	if !strings.HasPrefix(id, ":") || id == other.send(t, "CLIENT", "ID") {
+		t.Errorf("CLIENT ID = %s; want a distinct integer per connection", id)
+	}
+
+	result := client.send(t, "CLIENT", "GETNAME")
+	if result != "$-1\r\n" {
+		t.Errorf("CLIENT GETNAME = %s; want $-1\\r\\n", result)
+	}
+
+	client.send(t, "CLIENT", "SETNAME", "whistler")
+
+	result = client.send(t, "CLIENT", "GETNAME")
+	if result != "$8\r\nwhistler\r\n" {
+		t.Errorf("CLIENT GETNAME = %s; want $8\\r\\nwhistler\\r\\n", result)
+	}
+
+	result = client.send(t, "CLIENT", "BOGUS")
+	if result != "-ERR Unknown subcommand or wrong number of arguments for 'BOGUS'. Try CLIENT HELP.\r\n" {
+		t.Errorf("CLIENT BOGUS = %s; want -ERR Unknown subcommand or wrong number of arguments for 'BOGUS'. Try CLIENT HELP.\\r\\n", result)
+	}
+}
+
+func TestClientPause(t *testing.T) {
+	defer teardown()
+	defer testServer.pause.Unpause()
+
+	admin := newTestClient(t)
+	writer := newTestClient(t)
+	reader := newTestClient(t)
+
+	reader.send(t, "SET", "key", "before")
+
+	result := admin.send(t, "CLIENT", "PAUSE", "10000", "WRITE")
+	if result != okReply {
+		t.Fatalf("CLIENT PAUSE 10000 WRITE = %q; want +OK\r\n", result)
+	}
+
+	replies := make(chan string)
+	go func() {
+		if _, err := writer.conn.Write([]byte(returnArray([]string{"SET", "key", "after"}))); err != nil {
+			t.Errorf("error writing SET: %s", err)
+		}
+
+		reply, err := readReply(writer.reader)
+		if err != nil {
+			t.Errorf("error reading the reply of SET: %s", err)
+		}
+
+		replies <- reply
+	}()
+
+	select {
+	case reply := <-replies:
+		t.Fatalf("SET key after = %q while writes are paused; want it held", reply)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Reads go on during a write pause
+	result = reader.send(t, "GET", "key")
+	if result != "$6\r\nbefore\r\n" {
+		t.Errorf("GET key during the pause = %q; want $6\\r\\nbefore\\r\\n", result)
+	}
+
+	result = admin.send(t, "CLIENT", "UNPAUSE")
+	if result != okReply {
+		t.Errorf("CLIENT UNPAUSE = %q; want +OK\r\n", result)
+	}
+
+	select {
+	case reply := <-replies:
+		if reply != okReply {
+			t.Errorf("SET key after = %q once unpaused; want +OK\r\n", reply)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("SET key after is still held once unpaused")
+	}
+
+	result = reader.send(t, "GET", "key")
+	if result != "$5\r\nafter\r\n" {
+		t.Errorf("GET key after the pause = %q; want $5\\r\\nafter\\r\\n", result)
+	}
+
+	// A pause ends by itself once its timeout elapses
+	admin.send(t, "CLIENT", "PAUSE", "50")
+
+	start := time.Now()
+	reader.send(t, "GET", "key")
+
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("GET key returned after %s during a pause of all clients; want it held", elapsed)
+	}
+
+	errorTests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"PAUSE", "soon"}, "-ERR timeout is not an integer or out of range\r\n"},
+		{[]string{"PAUSE", "-1"}, "-ERR timeout is negative\r\n"},
+		{[]string{"PAUSE", "10", "READ"}, "-ERR syntax error\r\n"},
+	}
+
+	for _, test := range errorTests {
+		if result := admin.send(t, append([]string{"CLIENT"}, test.args...)...); result != test.want {
+			t.Errorf("CLIENT %q = %q; want %q", test.args, result, test.want)
+		}
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	defer teardown()
+
+	registerCommand("TEST-PANIC", CommandSpec{handler: func(ctx *commandContext, args []string) string {
+		defer ctx.db().lockKeys(true, args[0])()
+
+		panic("test panic")
+	}, arity: 2, flags: []string{"write"}})
+	defer delete(commands, "TEST-PANIC")
+
+	client := newTestClient(t)
+	other := newTestClient(t)
+
+	result := client.send(t, "TEST-PANIC", "key")
+	if result != "-ERR internal error\r\n" {
+		t.Errorf("TEST-PANIC key = %q; want -ERR internal error\\r\\n", result)
+	}
+
+	// The connection is still served, and the lock taken by the command is released
+	result = client.send(t, "PING")
+	if result != "+PONG\r\n" {
+		t.Errorf("PING after a panic = %q; want +PONG\\r\\n", result)
+	}
+
+	result = other.send(t, "SET", "key", "value")
+	if result != okReply {
+		t.Errorf("SET key value after a panic = %q; want +OK\\r\\n", result)
+	}
+
+	result = testServer.Execute(0, "TEST-PANIC", "key")
+	if result != "-ERR internal error\r\n" {
+		t.Errorf("Execute(0, \"TEST-PANIC\", \"key\") = %q; want -ERR internal error\\r\\n", result)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	middlewares := testServer.middlewares
+	defer func() {
+		testServer.middlewares = middlewares
+	}()
+
+	testServer.Use(func(next Handler) Handler {
+		return func(c *client, command string, args []string) string {
+			if command == "ECHO" {
+				return returnError("ECHO is rejected")
+			}
+
+			return next(c, command, args)
+		}
+	})
+
+	client := newTestClient(t)
+
+	result := client.send(t, "ECHO", "hello")
+	if result != "-ERR ECHO is rejected\r\n" {
+		t.Errorf("ECHO hello = %s; want -ERR ECHO is rejected\\r\\n", result)
+	}
+
+	result = client.send(t, "PING")
+	if result != "+PONG\r\n" {
+		t.Errorf("PING = %s; want +PONG\\r\\n", result)
+	}
+}
+
+// waitFor polls the condition until it holds or a second elapses.
+func waitFor(condition func() bool) bool {
+	deadline := time.Now().Add(time.Second)
+
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return condition()
+}
+
+func TestReleaseClientOnClose(t *testing.T) {
+	monitorCount := func() int {
+		testServer.monitorsMutex.RLock()
+		defer testServer.monitorsMutex.RUnlock()
+
+		return len(testServer.monitors)
+	}
+
+	// The monitors of previous tests are released asynchronously
+	if !waitFor(func() bool { return monitorCount() == 0 }) {
+		t.Fatalf("monitors = %d before the test; want 0", monitorCount())
+	}
+
+	client := newTestClient(t)
+	client.send(t, "MONITOR")
+	client.send(t, "MULTI")
+
+	if monitorCount() != 1 {
+		t.Fatalf("monitors = %d; want 1", monitorCount())
+	}
+
+	client.conn.Close()
+
+	if !waitFor(func() bool { return monitorCount() == 0 }) {
+		t.Errorf("monitors = %d after close; want 0", monitorCount())
+	}
+}
+
+func TestCancelCommandOnClose(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer()
+
+	clientConn, serverConn := net.Pipe()
+
+	done := make(chan struct{})
+	go func() {
+		server.handleRequest(serverConn)
+		close(done)
+	}()
+
+	if _, err := clientConn.Write([]byte(returnArray([]string{"DEBUG", "SLEEP", "10"}))); err != nil {
+		t.Fatal(err)
+	}
+
+	// Let the command start before closing the connection
+	time.Sleep(50 * time.Millisecond)
+	clientConn.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("DEBUG SLEEP 10 kept running after the connection was closed")
+	}
+
+	result := server.Execute(0, "INFO", "clients")
+	if !strings.Contains(result, "connected_clients:0\r\n") {
+		t.Errorf("INFO clients = %s; want connected_clients:0", result)
+	}
+}
+
+// inTempDir runs the function inside a temporary working directory and returns the names of the files left there.
+func inTempDir(t *testing.T, f func()) []string {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	f()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	return names
+}
+
+func TestShutdown(t *testing.T) {
+	defer teardown()
+
+	setCommand(testContext, []string{"key", "value"})
+
+	// Test with SAVE
+	files := inTempDir(t, func() {
+		if code := testServer.Shutdown(true); code != 0 {
+			t.Errorf("Shutdown(true) = %d; want 0", code)
+		}
+	})
+	if len(files) != len(testServer.databases) {
+		t.Errorf("Shutdown(true) saved %v; want one dump per database", files)
+	}
+
+	// Test with NOSAVE
+	files = inTempDir(t, func() {
+		if code := testServer.Shutdown(false); code != 0 {
+			t.Errorf("Shutdown(false) = %d; want 0", code)
+		}
+	})
+	if len(files) != 0 {
+		t.Errorf("Shutdown(false) saved %v; want nothing", files)
+	}
+}
+
+func TestSavePoints(t *testing.T) {
+	server := newTestServer(func(cfg *config) { cfg.save = "100 1 1 2" })
+	defer server.Shutdown(false)
+
+	ctx := &commandContext{Context: context.Background(), server: server}
+	dump := "database_0_dump.db"
+
+	files := inTempDir(t, func() {
+		setCommand(ctx, []string{"key1", "value"})
+
+		time.Sleep(time.Second + 2*saveCheckInterval)
+		if _, err := os.Stat(dump); err == nil {
+			t.Errorf("%s was saved after a single change; want no save before the second one", dump)
+		}
+
+		setCommand(ctx, []string{"key2", "value"})
+
+		if !waitFor(func() bool { _, err := os.Stat(dump); return err == nil }) {
+			t.Errorf("%s was not saved after two changes in more than a second; want a save", dump)
+		}
+	})
+	if len(files) != 1 {
+		t.Errorf("the save points saved %v; want only the modified database", files)
+	}
+
+	if changes := server.databases[0].ChangesSinceSave(); changes != 0 {
+		t.Errorf("ChangesSinceSave() = %d after the save point; want 0", changes)
+	}
+}
+
+// writeSelfSignedCertificate writes a self-signed certificate for 127.0.0.1 and its key
+// in a temporary directory, and returns their paths.
+func writeSelfSignedCertificate(t *testing.T) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "redis-whistle"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "testServer.crt")
+	keyFile := filepath.Join(dir, "testServer.key")
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privateKey}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestTLSListener(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCertificate(t)
+
+	l, err := listenTLS("127.0.0.1:0", certFile, keyFile)
+	if err != nil {
+		t.Fatalf("listenTLS returned %s", err)
+	}
+	defer l.Close()
+
+	go testServer.serve(l)
+
+	pemCertificate, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pemCertificate)
+
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatalf("tls.Dial returned %s", err)
+	}
+
+	client := &testClient{conn: conn, reader: bufio.NewReader(conn)}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	result := client.send(t, "PING")
+	if result != "+PONG\r\n" {
+		t.Errorf("PING = %s; want +PONG\\r\\n", result)
+	}
+}
+
+func TestUnixSocketListener(t *testing.T) {
+	defer teardown()
+
+	path := filepath.Join(t.TempDir(), "testServer.sock")
+
+	l, err := listenUnix(path)
+	if err != nil {
+		t.Fatalf("listenUnix returned %s", err)
+	}
+
+	go testServer.serve(l)
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("net.Dial returned %s", err)
+	}
+
+	client := &testClient{conn: conn, reader: bufio.NewReader(conn)}
+	defer conn.Close()
+
+	result := client.send(t, "SET", "key", "value")
+	if result != okReply {
+		t.Errorf("SET key value = %s; want +OK\\r\\n", result)
+	}
+
+	result = client.send(t, "GET", "key")
+	if result != returnBulkString("value") {
+		t.Errorf("GET key = %s; want $5\\r\\nvalue\\r\\n", result)
+	}
+
+	l.Close()
+
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("socket file still exists after closing the listener: %v", err)
+	}
+}
+
+func TestBindListener(t *testing.T) {
+	cfg := &config{bind: "127.0.0.1"}
+
+	l, err := net.Listen("tcp", cfg.bindAddresses(0)[0])
+	if err != nil {
+		t.Fatalf("net.Listen returned %s", err)
+	}
+	defer l.Close()
+
+	go testServer.serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial returned %s", err)
+	}
+
+	client := &testClient{conn: conn, reader: bufio.NewReader(conn)}
+	defer conn.Close()
+
+	result := client.send(t, "PING")
+	if result != "+PONG\r\n" {
+		t.Errorf("PING = %s; want +PONG\\r\\n", result)
+	}
+}
+
+func BenchmarkSetGetLargeValue(b *testing.B) {
+	defer teardown()
+
+	clientConn, serverConn := net.Pipe()
+	go testServer.handleRequest(serverConn)
+	defer clientConn.Close()
+
+	value := strings.Repeat("a", 1<<20)
+	set := "*3\r\n" + returnBulkString("SET") + returnBulkString("key") + returnBulkString(value)
+	get := "*2\r\n" + returnBulkString("GET") + returnBulkString("key")
+	reader := bufio.NewReaderSize(clientConn, 1<<21)
+
+	b.SetBytes(2 << 20)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, request := range []string{set, get} {
+			if _, err := io.WriteString(clientConn, request); err != nil {
+				b.Fatal(err)
+			}
+
+			if _, err := readReply(reader); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkExecuteSetGet(b *testing.B) {
+	defer teardown()
+
+	for i := 0; i < b.N; i++ {
+		testServer.Execute(0, "SET", "key", "value")
+		testServer.Execute(0, "GET", "key")
+	}
+}
+
+func TestRequirePass(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(func(cfg *config) { cfg.requirePass = "secret" })
+
+	clientConn, serverConn := net.Pipe()
+	go server.handleRequest(serverConn)
+	defer clientConn.Close()
+
+	client := &testClient{conn: clientConn, reader: bufio.NewReader(clientConn)}
+
+	if result := client.send(t, "GET", "key"); result != noAuthReply {
+		t.Errorf("GET before AUTH = %s; want %s", result, noAuthReply)
+	}
+
+	if result := client.send(t, "AUTH", "wrong"); result != wrongPassReply {
+		t.Errorf("AUTH wrong = %s; want %s", result, wrongPassReply)
+	}
+
+	if result := client.send(t, "AUTH", "admin", "secret"); result != wrongPassReply {
+		t.Errorf("AUTH admin secret = %s; want %s", result, wrongPassReply)
+	}
+
+	if result := client.send(t, "AUTH", "default", "secret"); result != okReply {
+		t.Errorf("AUTH default secret = %s; want +OK\\r\\n", result)
+	}
+
+	if result := client.send(t, "GET", "key"); result != nullReply {
+		t.Errorf("GET after AUTH = %s; want $-1\\r\\n", result)
+	}
+
+	// Execute runs in-process and needs no password
+	if result := server.Execute(0, "SET", "key", "value"); result != okReply {
+		t.Errorf("Execute(SET) = %s; want +OK\\r\\n", result)
+	}
+}
+
+func TestAuthWithoutPassword(t *testing.T) {
+	client := newTestClient(t)
+
+	result := client.send(t, "AUTH", "secret")
+	if !strings.HasPrefix(result, "-ERR AUTH <password> called without any password configured") {
+		t.Errorf("AUTH secret = %s; want an error", result)
+	}
+}
+
+func TestSaveIntoDir(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	server := newTestServer(func(cfg *config) { cfg.dir = dir })
+
+	server.Execute(0, "SET", "key", "value")
+	if result := server.Execute(0, "SAVE"); result != okReply {
+		t.Fatalf("SAVE = %s; want +OK\\r\\n", result)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "database_0_dump.db")); err != nil {
+		t.Errorf("SAVE did not write the dump into dir: %s", err)
+	}
+}
+
+func TestSlowlogRedactsAndTrims(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(func(cfg *config) { cfg.slowlogLogSlowerThan = 0 })
+
+	server.Execute(0, "CONFIG", "SET", "requirepass", "secret")
+
+	args := []string{"MSET"}
+	for i := 0; i < 40; i++ {
+		args = append(args, "arg")
+	}
+	server.Execute(0, args[0], args[1:]...)
+
+	server.Execute(0, "ECHO", strings.Repeat("a", 200))
+
+	entries := server.slowlog.Get(-1)
+	if len(entries) != 3 {
+		t.Fatalf("slowlog has %d entries; want 3", len(entries))
+	}
+
+	if got := entries[0].args; len(got) != 2 || got[1] != strings.Repeat("a", 128)+"... (72 more bytes)" {
+		t.Errorf("long argument recorded as %q; want it cut at 128 bytes", got)
+	}
+
+	if got := entries[1].args; len(got) != 32 || got[31] != "... (10 more arguments)" {
+		t.Errorf("41 arguments recorded as %q; want 31 of them and a note", got)
+	}
+
+	if got := entries[2].args; !reflect.DeepEqual(got, []string{"CONFIG", "SET", "requirepass", "(redacted)"}) {
+		t.Errorf("CONFIG SET requirepass recorded as %q; want the password redacted", got)
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	tests := []struct {
+		command string
+		args    []string
+		want    []string
+	}{
+		{"AUTH", []string{"default", "secret"}, []string{"AUTH", "(redacted)", "(redacted)"}},
+		{"CONFIG", []string{"set", "RequirePass", "secret"}, []string{"CONFIG", "set", "RequirePass", "(redacted)"}},
+		{"CONFIG", []string{"SET", "maxmemory", "100"}, []string{"CONFIG", "SET", "maxmemory", "100"}},
+		{"SET", []string{"requirepass", "value"}, []string{"SET", "requirepass", "value"}},
+	}
+
+	for _, test := range tests {
+		if got := redactArgs(test.command, test.args); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("redactArgs(%q, %q) = %q; want %q", test.command, test.args, got, test.want)
+		}
+	}
+}
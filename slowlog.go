@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// slowlogMaxArgs is the number of arguments, the command included, kept in a slowlog entry.
+	slowlogMaxArgs = 32
+	// slowlogMaxArgLen is the number of bytes kept from each argument of a slowlog entry.
+	slowlogMaxArgLen = 128
+)
+
+// A slowlogEntry is a command that exceeded the slowlog threshold.
+type slowlogEntry struct {
+	id        int64
+	timestamp int64
+	duration  int64
+	args      []string
+}
+
+// A slowlog keeps the most recent commands whose execution
+// took longer than the configured threshold, newest first.
+type slowlog struct {
+	entries []slowlogEntry
+	nextID  int64
+	mutex   sync.Mutex
+}
+
+// Record adds the command to the slowlog if its duration exceeds the threshold in microseconds.
+// A negative threshold disables the slowlog, zero records every command.
+// The slowlog is trimmed to maxLen entries.
+func (sl *slowlog) Record(args []string, duration time.Duration, threshold int, maxLen int) {
+	if threshold < 0 || duration.Microseconds() < int64(threshold) {
+		return
+	}
+
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	entry := slowlogEntry{
+		id:        sl.nextID,
+		timestamp: time.Now().Unix(),
+		duration:  duration.Microseconds(),
+		args:      slowlogArgs(args),
+	}
+	sl.nextID++
+
+	sl.entries = append([]slowlogEntry{entry}, sl.entries...)
+	if len(sl.entries) > maxLen {
+		sl.entries = sl.entries[:maxLen]
+	}
+}
+
+// slowlogArgs returns a copy of the arguments trimmed as Redis does, so that the entries stay small
+// and do not keep the buffers the arguments were read into alive.
+// Past slowlogMaxArgs, the last kept argument tells how many more there were,
+// and the arguments longer than slowlogMaxArgLen bytes are cut, telling how many more bytes there were.
+func slowlogArgs(args []string) []string {
+	count := len(args)
+	if count > slowlogMaxArgs {
+		count = slowlogMaxArgs
+	}
+
+	trimmed := make([]string, count)
+	for i := 0; i < count; i++ {
+		if i == slowlogMaxArgs-1 && len(args) > slowlogMaxArgs {
+			trimmed[i] = "... (" + strconv.Itoa(len(args)-slowlogMaxArgs+1) + " more arguments)"
+			break
+		}
+
+		if len(args[i]) > slowlogMaxArgLen {
+			trimmed[i] = args[i][:slowlogMaxArgLen] + "... (" + strconv.Itoa(len(args[i])-slowlogMaxArgLen) + " more bytes)"
+		} else {
+			trimmed[i] = strings.Clone(args[i])
+		}
+	}
+
+	return trimmed
+}
+
+// Get returns the count most recent entries.
+// If count is negative, all the entries are returned.
+func (sl *slowlog) Get(count int) []slowlogEntry {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	if count < 0 || count > len(sl.entries) {
+		count = len(sl.entries)
+	}
+
+	entries := make([]slowlogEntry, count)
+	copy(entries, sl.entries)
+
+	return entries
+}
+
+// Len returns the number of entries in the slowlog.
+func (sl *slowlog) Len() int {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	return len(sl.entries)
+}
+
+// Reset deletes all the entries of the slowlog.
+func (sl *slowlog) Reset() {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	sl.entries = nil
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errWrongType         = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errInvalidStreamID   = errors.New("Invalid stream ID specified as stream command argument")
+	errStreamIDTooSmall  = errors.New("The ID specified in XADD is equal or smaller than the target stream top item")
+	errStreamIDIsZero    = errors.New("The ID specified in XADD must be greater than 0-0")
+	errStreamIDExhausted = errors.New("The stream has exhausted the last possible ID, unable to add more items")
+)
+
+// A streamID identifies an entry of a stream:
+// the Unix time in milliseconds it was added at, and a sequence number for entries added in the same millisecond.
+type streamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+// maxStreamID is the largest possible ID, which + stands for.
+var maxStreamID = streamID{math.MaxUint64, math.MaxUint64}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// less reports whether id comes before other.
+func (id streamID) less(other streamID) bool {
+	return id.Ms < other.Ms || id.Ms == other.Ms && id.Seq < other.Seq
+}
+
+// next returns the smallest ID after id, and false if id is the largest possible one.
+func (id streamID) next() (streamID, bool) {
+	switch {
+	case id.Seq < math.MaxUint64:
+		return streamID{id.Ms, id.Seq + 1}, true
+	case id.Ms < math.MaxUint64:
+		return streamID{id.Ms + 1, 0}, true
+	default:
+		return id, false
+	}
+}
+
+// parseStreamID parses an ID given as "ms-seq", or as "ms" in which case the sequence number is seq.
+func parseStreamID(s string, seq uint64) (streamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, errInvalidStreamID
+	}
+
+	if hasSeq {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return streamID{}, errInvalidStreamID
+		}
+	}
+
+	return streamID{ms, seq}, nil
+}
+
+// A streamEntry is an entry of a stream: its ID, and its fields and values, alternating, in the order they were given.
+type streamEntry struct {
+	ID     streamID
+	Fields []string
+}
+
+// A stream is an append-only log of entries, sorted by ID.
+// LastID is the ID of the last entry ever added, which the next one must be greater than.
+// Entries are never modified once added, so a slice of them can be read without holding the lock of the shard.
+type stream struct {
+	Entries []streamEntry
+	LastID  streamID
+}
+
+// nextID returns the ID of the next entry, as given by id:
+// "*" generates it from the current time, "ms-*" from the given time, and "ms-seq" or "ms" are taken as is.
+func (st *stream) nextID(id string) (streamID, error) {
+	if id == "*" {
+		now := uint64(time.Now().UnixMilli())
+		if now > st.LastID.Ms {
+			return streamID{now, 0}, nil
+		}
+
+		next, ok := st.LastID.next()
+		if !ok {
+			return streamID{}, errStreamIDExhausted
+		}
+
+		return next, nil
+	}
+
+	if ms, ok := strings.CutSuffix(id, "-*"); ok {
+		if strings.Contains(ms, "-") {
+			return streamID{}, errInvalidStreamID
+		}
+
+		parsed, err := parseStreamID(ms, 0)
+		if err != nil {
+			return streamID{}, err
+		}
+
+		if parsed.Ms == st.LastID.Ms {
+			if st.LastID.Seq == math.MaxUint64 {
+				return streamID{}, errStreamIDTooSmall
+			}
+
+			parsed.Seq = st.LastID.Seq + 1
+		}
+
+		if parsed == (streamID{}) {
+			parsed.Seq = 1
+		}
+
+		return parsed, nil
+	}
+
+	return parseStreamID(id, 0)
+}
+
+// streamAt returns the stream at key, or nil if the key does not exist.
+// It returns errWrongType if the key holds another type.
+// The caller must hold at least the read lock of the shard.
+func (s *shard) streamAt(key string) (*stream, error) {
+	if !s.exists(key) {
+		return nil, nil
+	}
+
+	st, ok := s.StreamKeys[key]
+	if !ok {
+		return nil, errWrongType
+	}
+
+	return st, nil
+}
+
+// XAdd adds an entry with the given fields and values to the stream at key, creating the stream if needed.
+// It returns the ID of the new entry, which must be greater than the ID of the last entry.
+// Clients blocked reading the stream are woken up.
+func (db *Database) XAdd(key string, id string, fields []string) (streamID, error) {
+	s := db.shardOf(key)
+
+	s.mutex.Lock()
+	defer s.unlock()
+
+	st, err := s.streamAt(key)
+	if err != nil {
+		return streamID{}, err
+	}
+
+	if st == nil {
+		s.remove(key)
+		st = &stream{}
+	}
+
+	entryID, err := st.nextID(id)
+	if err != nil {
+		return streamID{}, err
+	}
+
+	if entryID == (streamID{}) {
+		return streamID{}, errStreamIDIsZero
+	}
+
+	if !st.LastID.less(entryID) {
+		return streamID{}, errStreamIDTooSmall
+	}
+
+	st.Entries = append(st.Entries, streamEntry{ID: entryID, Fields: append([]string(nil), fields...)})
+	st.LastID = entryID
+	s.StreamKeys[key] = st
+	db.touch(1)
+
+	db.notifyStreamWaiters(key)
+
+	return entryID, nil
+}
+
+// XLen returns the number of entries in the stream at key, 0 if the key does not exist.
+func (db *Database) XLen(key string) (int, error) {
+	s := db.shardOf(key)
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	st, err := s.streamAt(key)
+	if err != nil || st == nil {
+		return 0, err
+	}
+
+	return len(st.Entries), nil
+}
+
+// XRange returns the entries of the stream at key with an ID between start and end, both included.
+// If count is positive, at most count entries are returned.
+func (db *Database) XRange(key string, start streamID, end streamID, count int) ([]streamEntry, error) {
+	s := db.shardOf(key)
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	st, err := s.streamAt(key)
+	if err != nil || st == nil {
+		return nil, err
+	}
+
+	var entries []streamEntry
+
+	for _, entry := range st.Entries {
+		if entry.ID.less(start) {
+			continue
+		}
+
+		if end.less(entry.ID) || count > 0 && len(entries) == count {
+			break
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// LastStreamID returns the ID of the last entry added to the stream at key, 0-0 if the key does not exist.
+func (db *Database) LastStreamID(key string) (streamID, error) {
+	s := db.shardOf(key)
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	st, err := s.streamAt(key)
+	if err != nil || st == nil {
+		return streamID{}, err
+	}
+
+	return st.LastID, nil
+}
+
+// watchStreams returns a channel receiving a value when an entry is added to the stream at any of the keys,
+// and the function to call once the channel is no longer watched.
+func (db *Database) watchStreams(keys []string) (<-chan struct{}, func()) {
+	added := make(chan struct{}, 1)
+
+	db.waitersMutex.Lock()
+	for _, key := range keys {
+		if db.streamWaiters[key] == nil {
+			db.streamWaiters[key] = make(map[chan struct{}]bool)
+		}
+
+		db.streamWaiters[key][added] = true
+	}
+	db.waitersMutex.Unlock()
+
+	return added, func() {
+		db.waitersMutex.Lock()
+		defer db.waitersMutex.Unlock()
+
+		for _, key := range keys {
+			delete(db.streamWaiters[key], added)
+
+			if len(db.streamWaiters[key]) == 0 {
+				delete(db.streamWaiters, key)
+			}
+		}
+	}
+}
+
+// notifyStreamWaiters wakes up the clients watching the stream at key.
+func (db *Database) notifyStreamWaiters(key string) {
+	db.waitersMutex.Lock()
+	defer db.waitersMutex.Unlock()
+
+	for added := range db.streamWaiters[key] {
+		select {
+		case added <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func init() {
+	registerCommand("XADD", CommandSpec{handler: xaddCommand, arity: -5, flags: []string{"write", "fast"}, keys: keySpec{1, 1, 1, []string{"RW"}}})
+	registerCommand("XLEN", CommandSpec{handler: xlenCommand, arity: 2, flags: []string{"readonly", "fast"}, keys: keySpec{1, 1, 1, []string{"RO"}}})
+	registerCommand("XRANGE", CommandSpec{handler: xrangeCommand, arity: -4, flags: []string{"readonly"}, keys: keySpec{1, 1, 1, []string{"RO"}}})
+	registerCommand("XREAD", CommandSpec{handler: xreadCommand, arity: -4, flags: []string{"readonly"}, keys: keySpec{flags: []string{"RO"}}, findKeys: xreadKeyPositions})
+}
+
+// xreadKeyPositions returns the indexes of the keys of XREAD: the first half of the arguments after STREAMS,
+// the second half being their IDs. It returns nil if there is no STREAMS or the keys and IDs do not pair up.
+func xreadKeyPositions(args []string) []int {
+	for i, arg := range args {
+		if !strings.EqualFold(arg, "STREAMS") {
+			continue
+		}
+
+		rest := len(args) - i - 1
+		if rest == 0 || rest%2 != 0 {
+			return nil
+		}
+
+		positions := make([]int, 0, rest/2)
+		for j := i + 1; j <= i+rest/2; j++ {
+			positions = append(positions, j)
+		}
+
+		return positions
+	}
+
+	return nil
+}
+
+// returnStreamEntries returns the entries as a RESP array of their IDs and fields.
+func returnStreamEntries(entries []streamEntry) string {
+	reply := "*" + strconv.Itoa(len(entries)) + "\r\n"
+
+	for _, entry := range entries {
+		reply += "*2\r\n" + returnBulkString(entry.ID.String()) + "*" + strconv.Itoa(len(entry.Fields)) + "\r\n"
+
+		for _, field := range entry.Fields {
+			reply += returnBulkString(field)
+		}
+	}
+
+	return reply
+}
+
+// xaddCommand adds an entry to the stream at key and returns its ID.
+// The ID is given as "*" to generate it, "ms-*" to generate the sequence number only, or "ms-seq".
+func xaddCommand(ctx *commandContext, args []string) string {
+	fields := args[2:]
+	if len(fields)%2 != 0 {
+		return returnWrongNumberOfArgumentsError("XADD")
+	}
+
+	id, err := ctx.db().XAdd(args[0], args[1], fields)
+	if err != nil {
+		return returnKeyError(err)
+	}
+
+	return returnBulkString(id.String())
+}
+
+// xlenCommand returns the number of entries in the stream at key.
+func xlenCommand(ctx *commandContext, args []string) string {
+	length, err := ctx.db().XLen(args[0])
+	if err != nil {
+		return returnKeyError(err)
+	}
+
+	return returnInteger(length)
+}
+
+// xrangeCommand returns the entries of the stream at key between start and end, both included.
+// - and + stand for the smallest and the largest possible IDs,
+// and an ID without sequence number covers the whole millisecond.
+func xrangeCommand(ctx *commandContext, args []string) string {
+	if len(args) != 3 && len(args) != 5 {
+		return returnError("syntax error")
+	}
+
+	start, err := parseStreamID(args[1], 0)
+	if args[1] == "-" {
+		start, err = streamID{}, nil
+	}
+
+	if err != nil {
+		return returnKeyError(err)
+	}
+
+	end, err := parseStreamID(args[2], math.MaxUint64)
+	if args[2] == "+" {
+		end, err = maxStreamID, nil
+	}
+
+	if err != nil {
+		return returnKeyError(err)
+	}
+
+	count := 0
+
+	if len(args) == 5 {
+		if strings.ToUpper(args[3]) != "COUNT" {
+			return returnError("syntax error")
+		}
+
+		if count, err = strconv.Atoi(args[4]); err != nil {
+			return returnError(errNotInteger.Error())
+		}
+
+		if count <= 0 {
+			return returnArray(nil)
+		}
+	}
+
+	entries, err := ctx.db().XRange(args[0], start, end, count)
+	if err != nil {
+		return returnKeyError(err)
+	}
+
+	return returnStreamEntries(entries)
+}
+
+// xreadCommand returns the entries added to the streams at the keys after the given IDs.
+// $ stands for the ID of the last entry of the stream when the command is run.
+// With BLOCK, it waits for the given number of milliseconds, or forever if 0, until an entry is added.
+// It returns a null array if there is no entry.
+func xreadCommand(ctx *commandContext, args []string) string {
+	count := 0
+	block := time.Duration(-1)
+
+	var err error
+	i := 0
+
+	for ; i < len(args) && strings.ToUpper(args[i]) != "STREAMS"; i += 2 {
+		if i+1 == len(args) {
+			return returnError("syntax error")
+		}
+
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			if count, err = strconv.Atoi(args[i+1]); err != nil {
+				return returnError(errNotInteger.Error())
+			}
+		case "BLOCK":
+			if block, err = parseDuration("PX", args[i+1]); err != nil {
+				return returnError("timeout is not an integer or out of range")
+			}
+
+			if block < 0 {
+				return returnError("timeout is negative")
+			}
+		default:
+			return returnError("syntax error")
+		}
+	}
+
+	if i == len(args) {
+		return returnError("syntax error")
+	}
+
+	streams := args[i+1:]
+	if len(streams) == 0 || len(streams)%2 != 0 {
+		return returnError("Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
+	}
+
+	keys, ids := streams[:len(streams)/2], streams[len(streams)/2:]
+	after := make([]streamID, len(keys))
+
+	for j, key := range keys {
+		if ids[j] == "$" {
+			after[j], err = ctx.db().LastStreamID(key)
+		} else {
+			after[j], err = parseStreamID(ids[j], 0)
+		}
+
+		if err != nil {
+			return returnKeyError(err)
+		}
+	}
+
+	var deadline <-chan time.Time
+	if block > 0 {
+		timer := time.NewTimer(block)
+		defer timer.Stop()
+
+		deadline = timer.C
+	}
+
+	for {
+		added, stop := ctx.db().watchStreams(keys)
+
+		reply, err := readStreams(ctx.db(), keys, after, count)
+		if err != nil || reply != "" || block < 0 {
+			stop()
+
+			if err != nil {
+				return returnKeyError(err)
+			}
+
+			if reply == "" {
+				return returnNullArray()
+			}
+
+			return reply
+		}
+
+		select {
+		case <-added:
+			stop()
+		case <-deadline:
+			stop()
+			return returnNullArray()
+		case <-ctx.Done():
+			stop()
+			return returnError(ctx.Err().Error())
+		}
+	}
+}
+
+// readStreams returns the reply of XREAD for the entries of the streams at the keys after the given IDs,
+// or an empty string if there is none.
+func readStreams(db *Database, keys []string, after []streamID, count int) (string, error) {
+	var reply string
+	found := 0
+
+	for i, key := range keys {
+		start, ok := after[i].next()
+		if !ok {
+			continue
+		}
+
+		entries, err := db.XRange(key, start, maxStreamID, count)
+		if err != nil {
+			return "", err
+		}
+
+		if len(entries) == 0 {
+			continue
+		}
+
+		reply += "*2\r\n" + returnBulkString(key) + returnStreamEntries(entries)
+		found++
+	}
+
+	if found == 0 {
+		return "", nil
+	}
+
+	return "*" + strconv.Itoa(found) + "\r\n" + reply, nil
+}
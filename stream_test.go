@@ -0,0 +1,289 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestXaddCommand(t *testing.T) {
+	defer teardown()
+
+	// Test that generated IDs are increasing
+	var previous streamID
+
+	for i := 0; i < 100; i++ {
+		result := xaddCommand(testContext, []string{"stream", "*", "field", "value"})
+
+		id, err := parseStreamID(strings.Split(result, "\r\n")[1], 0)
+		if err != nil {
+			t.Fatalf("xaddCommand([]string{\"stream\", \"*\", \"field\", \"value\"}) = %s; want an ID", result)
+		}
+
+		if !previous.less(id) {
+			t.Fatalf("xaddCommand([]string{\"stream\", \"*\", \"field\", \"value\"}) = %s; want an ID greater than %s", result, previous)
+		}
+
+		previous = id
+	}
+
+	result := xlenCommand(testContext, []string{"stream"})
+	if result != ":100\r\n" {
+		t.Errorf("xlenCommand([]string{\"stream\"}) = %s; want :100\\r\\n", result)
+	}
+
+	// Test with explicit IDs
+	result = xaddCommand(testContext, []string{"explicit", "5-1", "field", "value"})
+	if result != "$3\r\n5-1\r\n" {
+		t.Errorf("xaddCommand([]string{\"explicit\", \"5-1\", ...}) = %s; want $3\\r\\n5-1\\r\\n", result)
+	}
+
+	result = xaddCommand(testContext, []string{"explicit", "5-*", "field", "value"})
+	if result != "$3\r\n5-2\r\n" {
+		t.Errorf("xaddCommand([]string{\"explicit\", \"5-*\", ...}) = %s; want $3\\r\\n5-2\\r\\n", result)
+	}
+
+	result = xaddCommand(testContext, []string{"explicit", "5-2", "field", "value"})
+	if result != "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n" {
+		t.Errorf("xaddCommand([]string{\"explicit\", \"5-2\", ...}) = %s; want an ID too small error", result)
+	}
+
+	result = xaddCommand(testContext, []string{"zero", "0-0", "field", "value"})
+	if result != "-ERR The ID specified in XADD must be greater than 0-0\r\n" {
+		t.Errorf("xaddCommand([]string{\"zero\", \"0-0\", ...}) = %s; want an ID greater than 0-0 error", result)
+	}
+
+	result = xaddCommand(testContext, []string{"explicit", "abc", "field", "value"})
+	if result != "-ERR Invalid stream ID specified as stream command argument\r\n" {
+		t.Errorf("xaddCommand([]string{\"explicit\", \"abc\", ...}) = %s; want an invalid ID error", result)
+	}
+
+	// Test with a missing value
+	result = xaddCommand(testContext, []string{"explicit", "*", "field", "value", "other"})
+	if result != "-ERR wrong number of arguments for 'XADD' command\r\n" {
+		t.Errorf("xaddCommand([]string{\"explicit\", \"*\", \"field\", \"value\", \"other\"}) = %s; want a wrong number of arguments error", result)
+	}
+
+	// Test with a key holding a string
+	setCommand(testContext, []string{"string", "value"})
+
+	result = xaddCommand(testContext, []string{"string", "*", "field", "value"})
+	if result != "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n" {
+		t.Errorf("xaddCommand([]string{\"string\", \"*\", ...}) = %s; want a WRONGTYPE error", result)
+	}
+
+	result = xlenCommand(testContext, []string{"string"})
+	if result != "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n" {
+		t.Errorf("xlenCommand([]string{\"string\"}) = %s; want a WRONGTYPE error", result)
+	}
+
+	// Test that streams are keys like any other
+	if exists := testContext.db().Exists("stream"); exists != 1 {
+		t.Errorf("EXISTS stream = %d; want 1", exists)
+	}
+
+	setCommand(testContext, []string{"stream", "value"})
+
+	result = xlenCommand(testContext, []string{"stream"})
+	if result != "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n" {
+		t.Errorf("xlenCommand([]string{\"stream\"}) = %s after SET; want a WRONGTYPE error", result)
+	}
+}
+
+func TestStringCommandsOnStream(t *testing.T) {
+	defer teardown()
+
+	xaddCommand(testContext, []string{"stream", "1-1", "field", "value"})
+
+	wrongType := "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+	commands := map[string]struct {
+		handler func(*commandContext, []string) string
+		args    []string
+	}{
+		"INCR":     {incrCommand, []string{"stream"}},
+		"INCRBY":   {incrbyCommand, []string{"stream", "2"}},
+		"DECR":     {decrCommand, []string{"stream"}},
+		"DECRBY":   {decrbyCommand, []string{"stream", "2"}},
+		"GETSET":   {getsetCommand, []string{"stream", "value"}},
+		"GETDEL":   {getdelCommand, []string{"stream"}},
+		"APPEND":   {appendCommand, []string{"stream", "value"}},
+		"SETRANGE": {setrangeCommand, []string{"stream", "0", "value"}},
+		"PFADD":    {pfaddCommand, []string{"stream", "a"}},
+		"PFMERGE":  {pfmergeCommand, []string{"stream"}},
+		"GET":      {getCommand, []string{"stream"}},
+		"PFCOUNT":  {pfcountCommand, []string{"stream"}},
+		"DEBUG":    {debugCommand, []string{"OBJECT", "stream"}},
+		"MEMORY":   {memoryCommand, []string{"USAGE", "stream"}},
+	}
+
+	for name, command := range commands {
+		if result := command.handler(testContext, command.args); result != wrongType {
+			t.Errorf("%s %v = %q; want a WRONGTYPE error", name, command.args, result)
+		}
+
+		if result := xlenCommand(testContext, []string{"stream"}); result != ":1\r\n" {
+			t.Errorf("XLEN stream = %q after %s; want :1, the stream left untouched", result, name)
+		}
+	}
+
+	if result := objectCommand(testContext, []string{"ENCODING", "stream"}); result != returnBulkString("stream") {
+		t.Errorf("OBJECT ENCODING stream = %q; want stream", result)
+	}
+
+	if result := mgetCommand(testContext, []string{"stream"}); result != "*1\r\n$-1\r\n" {
+		t.Errorf("MGET stream = %q; want a null", result)
+	}
+
+	// Test that expire times apply to streams, and to strings holding an empty value
+	setCommand(testContext, []string{"empty", ""})
+
+	for _, key := range []string{"stream", "empty"} {
+		if result := ttlCommand(testContext, []string{key}); result != ":-1\r\n" {
+			t.Errorf("TTL %s = %q; want :-1", key, result)
+		}
+
+		if result := expireCommand(testContext, []string{key, "100"}); result != oneReply {
+			t.Errorf("EXPIRE %s 100 = %q; want :1", key, result)
+		}
+
+		if result := ttlCommand(testContext, []string{key}); result != ":100\r\n" && result != ":99\r\n" {
+			t.Errorf("TTL %s = %q after EXPIRE; want :100", key, result)
+		}
+
+		if result := persistCommand(testContext, []string{key}); result != oneReply {
+			t.Errorf("PERSIST %s = %q; want :1", key, result)
+		}
+	}
+
+	if result := ttlCommand(testContext, []string{"missing"}); result != ":-2\r\n" {
+		t.Errorf("TTL missing = %q; want :-2", result)
+	}
+}
+
+func TestGetStreamWithLockFreeReads(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(func(cfg *config) { cfg.lockFreeReads = true })
+	server.Execute(0, "XADD", "stream", "1-1", "field", "value")
+
+	if result := server.Execute(0, "GET", "stream"); result != "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n" {
+		t.Errorf("GET stream = %q; want a WRONGTYPE error", result)
+	}
+
+	if result := server.Execute(0, "OBJECT", "ENCODING", "stream"); result != returnBulkString("stream") {
+		t.Errorf("OBJECT ENCODING stream = %q; want stream", result)
+	}
+}
+
+func TestXrangeCommand(t *testing.T) {
+	defer teardown()
+
+	for _, id := range []string{"1-1", "1-2", "2-1", "3-1"} {
+		xaddCommand(testContext, []string{"stream", id, "id", id})
+	}
+
+	entry := func(id string) string {
+		return "*2\r\n" + returnBulkString(id) + "*2\r\n$2\r\nid\r\n" + returnBulkString(id)
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"stream", "-", "+"}, "*4\r\n" + entry("1-1") + entry("1-2") + entry("2-1") + entry("3-1")},
+		{[]string{"stream", "1-2", "2-1"}, "*2\r\n" + entry("1-2") + entry("2-1")},
+		{[]string{"stream", "1", "1"}, "*2\r\n" + entry("1-1") + entry("1-2")},
+		{[]string{"stream", "2", "+"}, "*2\r\n" + entry("2-1") + entry("3-1")},
+		{[]string{"stream", "-", "+", "COUNT", "1"}, "*1\r\n" + entry("1-1")},
+		{[]string{"stream", "4", "+"}, "*0\r\n"},
+		{[]string{"missing", "-", "+"}, "*0\r\n"},
+		{[]string{"stream", "x", "+"}, "-ERR Invalid stream ID specified as stream command argument\r\n"},
+		{[]string{"stream", "-", "+", "LIMIT", "1"}, "-ERR syntax error\r\n"},
+	}
+
+	for _, test := range tests {
+		if result := xrangeCommand(testContext, test.args); result != test.want {
+			t.Errorf("xrangeCommand(%q) = %q; want %q", test.args, result, test.want)
+		}
+	}
+}
+
+func TestXreadCommand(t *testing.T) {
+	defer teardown()
+
+	xaddCommand(testContext, []string{"stream", "1-1", "field", "one"})
+	xaddCommand(testContext, []string{"stream", "1-2", "field", "two"})
+
+	// Test reading after a given ID
+	result := xreadCommand(testContext, []string{"STREAMS", "stream", "1-1"})
+	want := "*1\r\n*2\r\n$6\r\nstream\r\n*1\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$5\r\nfield\r\n$3\r\ntwo\r\n"
+	if result != want {
+		t.Errorf("xreadCommand([]string{\"STREAMS\", \"stream\", \"1-1\"}) = %q; want %q", result, want)
+	}
+
+	// Test with COUNT
+	result = xreadCommand(testContext, []string{"COUNT", "1", "STREAMS", "stream", "0"})
+	want = "*1\r\n*2\r\n$6\r\nstream\r\n*1\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$5\r\nfield\r\n$3\r\none\r\n"
+	if result != want {
+		t.Errorf("xreadCommand([]string{\"COUNT\", \"1\", \"STREAMS\", \"stream\", \"0\"}) = %q; want %q", result, want)
+	}
+
+	// Test with no new entries
+	result = xreadCommand(testContext, []string{"STREAMS", "stream", "missing", "1-2", "0"})
+	if result != "*-1\r\n" {
+		t.Errorf("xreadCommand([]string{\"STREAMS\", \"stream\", \"missing\", \"1-2\", \"0\"}) = %s; want *-1\\r\\n", result)
+	}
+
+	// Test with a timeout
+	result = xreadCommand(testContext, []string{"BLOCK", "10", "STREAMS", "stream", "$"})
+	if result != "*-1\r\n" {
+		t.Errorf("xreadCommand([]string{\"BLOCK\", \"10\", \"STREAMS\", \"stream\", \"$\"}) = %s; want *-1\\r\\n", result)
+	}
+
+	// Test with unbalanced streams
+	result = xreadCommand(testContext, []string{"STREAMS", "stream"})
+	if !strings.HasPrefix(result, "-ERR Unbalanced 'xread' list of streams") {
+		t.Errorf("xreadCommand([]string{\"STREAMS\", \"stream\"}) = %s; want an unbalanced list error", result)
+	}
+}
+
+func TestXreadCommandBlock(t *testing.T) {
+	defer teardown()
+
+	xaddCommand(testContext, []string{"stream", "1-1", "field", "one"})
+
+	reply := make(chan string, 1)
+	go func() {
+		reply <- xreadCommand(testContext, []string{"BLOCK", "0", "STREAMS", "stream", "$"})
+	}()
+
+	db := testContext.db()
+	waiting := waitFor(func() bool {
+		db.waitersMutex.Lock()
+		defer db.waitersMutex.Unlock()
+
+		return len(db.streamWaiters["stream"]) == 1
+	})
+	if !waiting {
+		t.Fatal("XREAD BLOCK 0 did not wait for the stream")
+	}
+
+	xaddCommand(testContext, []string{"stream", "1-2", "field", "two"})
+
+	select {
+	case result := <-reply:
+		want := "*1\r\n*2\r\n$6\r\nstream\r\n*1\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$5\r\nfield\r\n$3\r\ntwo\r\n"
+		if result != want {
+			t.Errorf("XREAD BLOCK 0 STREAMS stream $ = %q; want %q", result, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("XREAD BLOCK 0 was not woken up by XADD")
+	}
+
+	db.waitersMutex.Lock()
+	defer db.waitersMutex.Unlock()
+
+	if len(db.streamWaiters) != 0 {
+		t.Errorf("streamWaiters = %v; want no waiter left", db.streamWaiters)
+	}
+}
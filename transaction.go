@@ -0,0 +1,87 @@
+package main
+
+import "strconv"
+
+// A queuedCommand is a command queued by a client inside a transaction.
+type queuedCommand struct {
+	command string
+	args    []string
+}
+
+func init() {
+	registerCommand("MULTI", CommandSpec{connectionHandler: multiCommand, arity: 1, flags: []string{"fast", "loading"}})
+	registerCommand("EXEC", CommandSpec{connectionHandler: execCommand, arity: 1, flags: []string{"loading"}})
+	registerCommand("DISCARD", CommandSpec{connectionHandler: discardCommand, arity: 1, flags: []string{"fast", "loading"}})
+}
+
+// isTransactionCommand reports whether the command controls the transaction,
+// and so is executed right away instead of being queued.
+func isTransactionCommand(command string) bool {
+	return command == "MULTI" || command == "EXEC" || command == "DISCARD" || command == "QUIT"
+}
+
+// failTransaction marks the client transaction as failed, so that EXEC discards it.
+// It does nothing outside of a transaction.
+func (c *client) failTransaction() {
+	if c != nil && c.inTransaction {
+		c.transactionFailed = true
+	}
+}
+
+// queueCommand queues the command in the client transaction.
+func (c *client) queueCommand(command string, args []string) string {
+	c.transaction = append(c.transaction, queuedCommand{command: command, args: args})
+
+	return returnSimpleString("QUEUED")
+}
+
+// resetTransaction leaves the transaction, discarding the queued commands.
+func (c *client) resetTransaction() {
+	c.inTransaction = false
+	c.transactionFailed = false
+	c.transaction = nil
+}
+
+// multiCommand marks the start of a transaction.
+// The following commands are queued until EXEC or DISCARD is called.
+func multiCommand(c *client, _ []string) string {
+	if c.inTransaction {
+		return returnError("MULTI calls can not be nested")
+	}
+
+	c.inTransaction = true
+
+	return returnSimpleString("OK")
+}
+
+// execCommand executes the queued commands and returns their replies as an array.
+// If a command failed to be queued, the whole transaction is discarded.
+func execCommand(c *client, _ []string) string {
+	if !c.inTransaction {
+		return returnError("EXEC without MULTI")
+	}
+
+	defer c.resetTransaction()
+
+	if c.transactionFailed {
+		return "-EXECABORT Transaction discarded because of previous errors\r\n"
+	}
+
+	reply := "*" + strconv.Itoa(len(c.transaction)) + "\r\n"
+	for _, queued := range c.transaction {
+		reply += c.handler(c, queued.command, queued.args)
+	}
+
+	return reply
+}
+
+// discardCommand discards the queued commands and leaves the transaction.
+func discardCommand(c *client, _ []string) string {
+	if !c.inTransaction {
+		return returnError("DISCARD without MULTI")
+	}
+
+	c.resetTransaction()
+
+	return returnSimpleString("OK")
+}
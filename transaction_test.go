@@ -0,0 +1,97 @@
+package main
+
+import "testing"
+
+const execAbortReply = "-EXECABORT Transaction discarded because of previous errors\r\n"
+
+func TestTransaction(t *testing.T) {
+	defer teardown()
+
+	client := newTestClient(t)
+
+	result := client.send(t, "MULTI")
+	if result != okReply {
+		t.Errorf("MULTI = %s; want +OK\\r\\n", result)
+	}
+
+	result = client.send(t, "SET", "key", "value")
+	if result != "+QUEUED\r\n" {
+		t.Errorf("SET key value = %s; want +QUEUED\\r\\n", result)
+	}
+
+	client.send(t, "GET", "key")
+
+	result = client.send(t, "EXEC")
+	if result != "*2\r\n+OK\r\n$5\r\nvalue\r\n" {
+		t.Errorf("EXEC = %s; want *2\\r\\n+OK\\r\\n$5\\r\\nvalue\\r\\n", result)
+	}
+
+	result = client.send(t, "EXEC")
+	if result != "-ERR EXEC without MULTI\r\n" {
+		t.Errorf("EXEC = %s; want -ERR EXEC without MULTI\\r\\n", result)
+	}
+}
+
+func TestTransactionDiscard(t *testing.T) {
+	defer teardown()
+
+	client := newTestClient(t)
+	client.send(t, "MULTI")
+	client.send(t, "SET", "key", "value")
+
+	result := client.send(t, "DISCARD")
+	if result != okReply {
+		t.Errorf("DISCARD = %s; want +OK\\r\\n", result)
+	}
+
+	result = client.send(t, "GET", "key")
+	if result != nullReply {
+		t.Errorf("GET key = %s; want $-1\\r\\n", result)
+	}
+}
+
+func TestTransactionUnknownCommand(t *testing.T) {
+	defer teardown()
+
+	client := newTestClient(t)
+	client.send(t, "MULTI")
+	client.send(t, "SET", "key", "value")
+
+	result := client.send(t, "FOO")
+	if result != "-ERR Unknown command 'FOO'\r\n" {
+		t.Errorf("FOO = %s; want -ERR Unknown command 'FOO'\\r\\n", result)
+	}
+
+	result = client.send(t, "EXEC")
+	if result != execAbortReply {
+		t.Errorf("EXEC = %s; want %s", result, execAbortReply)
+	}
+
+	result = client.send(t, "GET", "key")
+	if result != nullReply {
+		t.Errorf("GET key = %s; want $-1\\r\\n", result)
+	}
+}
+
+func TestTransactionWrongArity(t *testing.T) {
+	defer teardown()
+
+	client := newTestClient(t)
+	client.send(t, "MULTI")
+	client.send(t, "SET", "key", "value")
+
+	result := client.send(t, "GET")
+	if result != "-ERR wrong number of arguments for 'GET' command\r\n" {
+		t.Errorf("GET = %s; want -ERR wrong number of arguments for 'GET' command\\r\\n", result)
+	}
+
+	result = client.send(t, "EXEC")
+	if result != execAbortReply {
+		t.Errorf("EXEC = %s; want %s", result, execAbortReply)
+	}
+
+	result = client.send(t, "GET", "key")
+	if result != nullReply {
+		t.Errorf("GET key = %s; want $-1\\r\\n", result)
+	}
+}